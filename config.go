@@ -0,0 +1,437 @@
+package serial
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Config holds the settings applied when a port is opened.
+type Config struct {
+	Name           string
+	Baud           int
+	DataBits       int // 5 to 8
+	Parity         Parity
+	StopBits       StopBits
+	FlowControl    FlowControl
+	XON, XOFF      byte          // characters of FlowXONXOFF, 0 for XONDefault and XOFFDefault
+	ReadTimeout    time.Duration // 0 for blocking reads
+	EOL            byte
+	EOLString      string // multi-byte EOL, see SerialPort.EOLString, replacing EOL if set
+	ClearOnOpen    bool
+	Preamble       string // skipped after opening, see SerialPort.SkipPreamble
+	LazyLines      bool   // see SerialPort.LazyLineProcessing
+	NoConfigure    bool   // shared read-only open, see SerialPort.NoConfigure
+	NoDTROnOpen    bool   // DTR deasserted on open, see SerialPort.DTROnOpen
+	BreakHandling  BreakHandling
+	WriteBuffering WriteBuffering
+	ReadWatchdog   time.Duration // 0 to disable the read watchdog
+	WriteChunk     int           // maximum size of the port writes, 0 for no chunking
+	WriteDelay     time.Duration // delay between write chunks
+	AutoReconnect  time.Duration // first retry delay of SerialPort.SetAutoReconnect, 0 to disable
+	ReconnectMax   time.Duration // longest retry delay of the auto-reconnect
+}
+
+// Option changes a setting of a Config, see Open.
+type Option func(c *Config) error
+
+// Open opens the named port with the given options and returns it. Settings without an
+// option keep their default: 9600 baud 8N1, blocking reads and newline EOL.
+//
+// All the options are validated before the port is opened, a single error listing
+// every invalid setting being returned.
+//
+//	sp, err := serial.Open("COM1", serial.WithBaud(115200), serial.WithReadTimeout(time.Second))
+func Open(name string, opts ...Option) (*SerialPort, error) {
+	c := Config{Name: name, Baud: 9600, DataBits: 8, Parity: ParityNone, StopBits: Stop1, EOL: EOL_DEFAULT}
+	var errs []error
+	for _, opt := range opts {
+		if err := opt(&c); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := c.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return c.open()
+}
+
+// OpenConfig opens the port described by c and returns it, all the settings being given
+// at once. Zero DataBits, Parity, StopBits and EOL mean 8N1 and newline EOL as with Open,
+// Name and Baud are required. The settings are validated like the options of Open
+// before the port is opened.
+func OpenConfig(c Config) (*SerialPort, error) {
+	if c.DataBits == 0 {
+		c.DataBits = 8
+	}
+	if c.Parity == 0 {
+		c.Parity = ParityNone
+	}
+	if c.StopBits == 0 {
+		c.StopBits = Stop1
+	}
+	if c.EOL == 0 {
+		c.EOL = EOL_DEFAULT
+	}
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	return c.open()
+}
+
+// open applies the settings of c, once validated, to a new SerialPort and opens it.
+func (c *Config) open() (*SerialPort, error) {
+	sp := New()
+	sp.dataBits, sp.parity, sp.stopBits = c.DataBits, c.Parity, c.StopBits
+	sp.SetFlowControl(c.FlowControl)
+	if c.XON != 0 || c.XOFF != 0 {
+		sp.SetXONXOFFChars(c.xonChars())
+	}
+	sp.EOL(c.EOL)
+	sp.EOLString(c.EOLString)
+	sp.ClearOnOpen(c.ClearOnOpen)
+	sp.SkipPreamble([]byte(c.Preamble))
+	sp.LazyLineProcessing(c.LazyLines)
+	sp.NoConfigure(c.NoConfigure)
+	sp.DTROnOpen(!c.NoDTROnOpen)
+	sp.SetBreakHandling(c.BreakHandling)
+	sp.SetWriteBuffering(c.WriteBuffering)
+	sp.SetReadWatchdog(c.ReadWatchdog)
+	sp.SetWriteChunking(c.WriteChunk, c.WriteDelay)
+	sp.SetAutoReconnect(c.AutoReconnect, c.ReconnectMax)
+	if err := sp.Open(c.Name, c.Baud, c.ReadTimeout); err != nil {
+		return nil, err
+	}
+	return sp, nil
+}
+
+// Settings returns the effective configuration of the port: the settings it was opened
+// with and the changes made since (SetBaud, SetMode, SetFlowControl...). Opening a port
+// with OpenConfig(sp.Settings()) gives the same configuration. A multi-byte EOL set with
+// EOLString is returned whole in EOLString, EOL holding its last byte.
+//
+// The settings are read under the locks their setters and the reconnections take, so it
+// is safe to call from any goroutine, e.g. to display the connection while it is used.
+// It doesn't wait for the writes in progress.
+func (sp *SerialPort) Settings() Config {
+	sp.settingsMu.RLock()
+	c := Config{
+		Name:           sp.name,
+		Baud:           sp.baud,
+		DataBits:       sp.dataBits,
+		Parity:         sp.parity,
+		StopBits:       sp.stopBits,
+		FlowControl:    sp.flow,
+		XON:            sp.xon,
+		XOFF:           sp.xoff,
+		ReadTimeout:    sp.readTimeout,
+		ClearOnOpen:    sp.clearOnOpen,
+		Preamble:       string(sp.preamble),
+		LazyLines:      sp.lazyLines,
+		NoConfigure:    sp.noConfigure,
+		NoDTROnOpen:    sp.holdDTR,
+		WriteBuffering: sp.writeMode,
+		ReadWatchdog:   sp.watchdog,
+		WriteChunk:     sp.chunkSize,
+		WriteDelay:     sp.chunkDelay,
+	}
+	sp.settingsMu.RUnlock()
+	sp.buffMu.Lock()
+	c.EOL = sp.eol[len(sp.eol)-1]
+	if len(sp.eol) > 1 {
+		c.EOLString = string(sp.eol)
+	}
+	sp.buffMu.Unlock()
+	sp.handlersMu.Lock()
+	c.BreakHandling = sp.breakMode
+	c.AutoReconnect, c.ReconnectMax = sp.autoRetry, sp.autoMax
+	sp.handlersMu.Unlock()
+	return c
+}
+
+// OpenPort opens the port described by c for direct reads and writes of the device,
+// without the buffering and the reader threads of a SerialPort. The line settings of c are
+// applied, zero DataBits, Parity and StopBits meaning 8N1; the settings of the buffering
+// layer (EOL, handlers, write buffering...) don't apply.
+func OpenPort(c *Config) (*Port, error) {
+	cfg := *c
+	if cfg.DataBits == 0 {
+		cfg.DataBits = 8
+	}
+	if cfg.Parity == 0 {
+		cfg.Parity = ParityNone
+	}
+	if cfg.StopBits == 0 {
+		cfg.StopBits = Stop1
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	p, err := openPort(cfg.Name, cfg.Baud, cfg.ReadTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open port \"%s\" - %s", cfg.Name, err)
+	}
+	if cfg.NoDTROnOpen {
+		if err := p.holdDTR(); err != nil {
+			p.Close()
+			return nil, fmt.Errorf("Unable to open port \"%s\" - %s", cfg.Name, err)
+		}
+	}
+	if cfg.DataBits != 8 || cfg.Parity != ParityNone || cfg.StopBits != Stop1 {
+		if err := p.setFraming(cfg.DataBits, cfg.Parity, cfg.StopBits); err != nil {
+			p.Close()
+			return nil, fmt.Errorf("Unable to open port \"%s\" - %s", cfg.Name, err)
+		}
+	}
+	if cfg.FlowControl != FlowNone {
+		xon, xoff := cfg.xonChars()
+		if err := p.setFlowControl(cfg.FlowControl, xon, xoff); err != nil {
+			p.Close()
+			return nil, fmt.Errorf("Unable to open port \"%s\" - %s", cfg.Name, err)
+		}
+	}
+	return p, nil
+}
+
+// WithBaud sets the baud rate.
+func WithBaud(baud int) Option {
+	return func(c *Config) error {
+		if baud <= 0 {
+			return fmt.Errorf("Invalid baud rate %v", baud)
+		}
+		c.Baud = baud
+		return nil
+	}
+}
+
+// WithMode sets the baud rate and framing from a shorthand like "115200,8N1", see
+// ParseMode.
+func WithMode(mode string) Option {
+	return func(c *Config) error {
+		m, err := ParseMode(mode)
+		if err != nil {
+			return err
+		}
+		c.Baud, c.DataBits, c.Parity, c.StopBits = m.Baud, m.DataBits, m.Parity, m.StopBits
+		return nil
+	}
+}
+
+// WithDataBits sets the number of data bits, 5 to 8.
+func WithDataBits(bits int) Option {
+	return func(c *Config) error {
+		c.DataBits = bits
+		return nil
+	}
+}
+
+// WithParity sets the parity bit, see Parity.
+func WithParity(parity Parity) Option {
+	return func(c *Config) error {
+		c.Parity = parity
+		return nil
+	}
+}
+
+// WithStopBits sets the number of stop bits, see StopBits.
+func WithStopBits(stopBits StopBits) Option {
+	return func(c *Config) error {
+		c.StopBits = stopBits
+		return nil
+	}
+}
+
+// WithFlowControl selects the flow control, see FlowControl.
+func WithFlowControl(flow FlowControl) Option {
+	return func(c *Config) error {
+		if flow < FlowNone || flow > FlowXONXOFF {
+			return fmt.Errorf("Invalid flow control %v", flow)
+		}
+		c.FlowControl = flow
+		return nil
+	}
+}
+
+// WithXONXOFFChars sets the characters of the software flow control.
+func WithXONXOFFChars(xon, xoff byte) Option {
+	return func(c *Config) error {
+		c.XON, c.XOFF = xon, xoff
+		return nil
+	}
+}
+
+// WithReadTimeout sets the read timeout of the port, see "NonBlocking Mode".
+func WithReadTimeout(timeout time.Duration) Option {
+	return func(c *Config) error {
+		if timeout < 0 {
+			return fmt.Errorf("Invalid read timeout %v", timeout)
+		}
+		c.ReadTimeout = timeout
+		return nil
+	}
+}
+
+// WithEOL sets the end of line character.
+func WithEOL(eol byte) Option {
+	return func(c *Config) error {
+		c.EOL = eol
+		return nil
+	}
+}
+
+// WithClearOnOpen discards the data queued by the driver when the port is opened.
+func WithClearOnOpen() Option {
+	return func(c *Config) error {
+		c.ClearOnOpen = true
+		return nil
+	}
+}
+
+// WithPreamble discards the preamble the device sends when the port is opened, see
+// SerialPort.SkipPreamble.
+func WithPreamble(preamble []byte) Option {
+	return func(c *Config) error {
+		c.Preamble = string(preamble)
+		return nil
+	}
+}
+
+// WithLazyLineProcessing skips the line processing until a line handler is registered,
+// see SerialPort.LazyLineProcessing.
+func WithLazyLineProcessing() Option {
+	return func(c *Config) error {
+		c.LazyLines = true
+		return nil
+	}
+}
+
+// WithNoConfigure opens the port read-only alongside another opener, without changing
+// its settings, see SerialPort.NoConfigure.
+func WithNoConfigure() Option {
+	return func(c *Config) error {
+		c.NoConfigure = true
+		return nil
+	}
+}
+
+// WithoutDTROnOpen keeps DTR deasserted when the port is opened, so that boards resetting
+// on DTR don't, see SerialPort.DTROnOpen.
+func WithoutDTROnOpen() Option {
+	return func(c *Config) error {
+		c.NoDTROnOpen = true
+		return nil
+	}
+}
+
+// WithBreakHandling selects how BREAK conditions are received.
+func WithBreakHandling(mode BreakHandling) Option {
+	return func(c *Config) error {
+		c.BreakHandling = mode
+		return nil
+	}
+}
+
+// WithWriteBuffering selects how written data is handed to the port.
+func WithWriteBuffering(mode WriteBuffering) Option {
+	return func(c *Config) error {
+		c.WriteBuffering = mode
+		return nil
+	}
+}
+
+// WithReadWatchdog enables the read watchdog, see SetReadWatchdog.
+func WithReadWatchdog(interval time.Duration) Option {
+	return func(c *Config) error {
+		if interval < 0 {
+			return fmt.Errorf("Invalid watchdog interval %v", interval)
+		}
+		c.ReadWatchdog = interval
+		return nil
+	}
+}
+
+// WithWriteChunking splits writes into chunks of at most size bytes, paced by the line
+// speed plus delay, see SetWriteChunking.
+func WithWriteChunking(size int, delay time.Duration) Option {
+	return func(c *Config) error {
+		if size < 0 {
+			return fmt.Errorf("Invalid chunk size %v", size)
+		}
+		if delay < 0 {
+			return fmt.Errorf("Invalid chunk delay %v", delay)
+		}
+		c.WriteChunk = size
+		c.WriteDelay = delay
+		return nil
+	}
+}
+
+// WithAutoReconnect reopens the port by itself once its device is disconnected, retrying
+// after retry then up to every maxRetry, see SetAutoReconnect. A retry of 0 disables it.
+func WithAutoReconnect(retry, maxRetry time.Duration) Option {
+	return func(c *Config) error {
+		if err := validateReconnect(retry); err != nil {
+			return err
+		}
+		c.AutoReconnect = retry
+		c.ReconnectMax = maxRetry
+		return nil
+	}
+}
+
+// xonChars returns the XON and XOFF characters of c, with their defaults.
+func (c *Config) xonChars() (xon, xoff byte) {
+	xon, xoff = c.XON, c.XOFF
+	if xon == 0 {
+		xon = XONDefault
+	}
+	if xoff == 0 {
+		xoff = XOFFDefault
+	}
+	return xon, xoff
+}
+
+// validate checks the combined settings of c.
+func (c *Config) validate() error {
+	var errs []error
+	if c.Name == "" {
+		errs = append(errs, fmt.Errorf("Missing port name"))
+	}
+	if c.Baud <= 0 {
+		errs = append(errs, fmt.Errorf("Invalid baud rate %v", c.Baud))
+	}
+	if c.ReadTimeout < 0 {
+		errs = append(errs, fmt.Errorf("Invalid read timeout %v", c.ReadTimeout))
+	}
+	if err := (Mode{c.Baud, c.DataBits, c.Parity, c.StopBits}).validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if c.FlowControl < FlowNone || c.FlowControl > FlowXONXOFF {
+		errs = append(errs, fmt.Errorf("Invalid flow control %v", c.FlowControl))
+	}
+	if xon, xoff := c.xonChars(); xon == xoff {
+		errs = append(errs, fmt.Errorf("Identical XON and XOFF characters 0x%02x", xon))
+	}
+	if c.BreakHandling < BreakInject || c.BreakHandling > BreakEvent {
+		errs = append(errs, fmt.Errorf("Invalid break handling %v", c.BreakHandling))
+	}
+	if c.WriteBuffering < Unbuffered || c.WriteBuffering > LineBuffered {
+		errs = append(errs, fmt.Errorf("Invalid write buffering %v", c.WriteBuffering))
+	}
+	if c.ReadWatchdog < 0 {
+		errs = append(errs, fmt.Errorf("Invalid watchdog interval %v", c.ReadWatchdog))
+	}
+	if c.WriteChunk < 0 {
+		errs = append(errs, fmt.Errorf("Invalid chunk size %v", c.WriteChunk))
+	}
+	if c.WriteDelay < 0 {
+		errs = append(errs, fmt.Errorf("Invalid chunk delay %v", c.WriteDelay))
+	}
+	if err := validateReconnect(c.AutoReconnect); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
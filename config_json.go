@@ -0,0 +1,302 @@
+package serial
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// configJSON is the JSON layout of a Config, with readable enums and durations.
+type configJSON struct {
+	Name           string         `json:"name"`
+	Baud           int            `json:"baud"`
+	DataBits       int            `json:"dataBits"`
+	Parity         Parity         `json:"parity"`
+	StopBits       StopBits       `json:"stopBits"`
+	FlowControl    FlowControl    `json:"flowControl"`
+	XON            byte           `json:"xon,omitempty"`
+	XOFF           byte           `json:"xoff,omitempty"`
+	ReadTimeout    jsonDuration   `json:"readTimeout"`
+	EOL            string         `json:"eol"`
+	EOLString      string         `json:"eolString,omitempty"` // hex
+	ClearOnOpen    bool           `json:"clearOnOpen,omitempty"`
+	Preamble       string         `json:"preamble,omitempty"` // hex
+	LazyLines      bool           `json:"lazyLines,omitempty"`
+	NoConfigure    bool           `json:"noConfigure,omitempty"`
+	NoDTROnOpen    bool           `json:"noDTROnOpen,omitempty"`
+	BreakHandling  BreakHandling  `json:"breakHandling"`
+	WriteBuffering WriteBuffering `json:"writeBuffering"`
+	ReadWatchdog   jsonDuration   `json:"readWatchdog,omitempty"`
+	WriteChunk     int            `json:"writeChunk,omitempty"`
+	WriteDelay     jsonDuration   `json:"writeDelay,omitempty"`
+	AutoReconnect  jsonDuration   `json:"autoReconnect,omitempty"`
+	ReconnectMax   jsonDuration   `json:"reconnectMax,omitempty"`
+}
+
+// jsonDuration is a time.Duration written as a string like "1.5s".
+type jsonDuration time.Duration
+
+func (d jsonDuration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *jsonDuration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return fmt.Errorf("Invalid duration %q", text)
+	}
+	*d = jsonDuration(v)
+	return nil
+}
+
+// MarshalJSON writes c with the enums as names, e.g. "parity": "even", and the durations
+// as strings, e.g. "readTimeout": "500ms". Zero DataBits, Parity, StopBits and EOL are
+// written as 8N1 and newline EOL, what they mean to OpenConfig.
+func (c Config) MarshalJSON() ([]byte, error) {
+	if c.DataBits == 0 {
+		c.DataBits = 8
+	}
+	if c.Parity == 0 {
+		c.Parity = ParityNone
+	}
+	if c.StopBits == 0 {
+		c.StopBits = Stop1
+	}
+	if c.EOL == 0 {
+		c.EOL = EOL_DEFAULT
+	}
+	return json.Marshal(configJSON{
+		Name:           c.Name,
+		Baud:           c.Baud,
+		DataBits:       c.DataBits,
+		Parity:         c.Parity,
+		StopBits:       c.StopBits,
+		FlowControl:    c.FlowControl,
+		XON:            c.XON,
+		XOFF:           c.XOFF,
+		ReadTimeout:    jsonDuration(c.ReadTimeout),
+		EOL:            string(rune(c.EOL)),
+		EOLString:      hex.EncodeToString([]byte(c.EOLString)),
+		ClearOnOpen:    c.ClearOnOpen,
+		Preamble:       hex.EncodeToString([]byte(c.Preamble)),
+		LazyLines:      c.LazyLines,
+		NoConfigure:    c.NoConfigure,
+		NoDTROnOpen:    c.NoDTROnOpen,
+		BreakHandling:  c.BreakHandling,
+		WriteBuffering: c.WriteBuffering,
+		ReadWatchdog:   jsonDuration(c.ReadWatchdog),
+		WriteChunk:     c.WriteChunk,
+		WriteDelay:     jsonDuration(c.WriteDelay),
+		AutoReconnect:  jsonDuration(c.AutoReconnect),
+		ReconnectMax:   jsonDuration(c.ReconnectMax),
+	})
+}
+
+// UnmarshalJSON reads a Config written by MarshalJSON. The settings missing from data
+// keep the defaults of Open.
+func (c *Config) UnmarshalJSON(data []byte) error {
+	j := configJSON{Baud: 9600, DataBits: 8, Parity: ParityNone, StopBits: Stop1, EOL: string(rune(EOL_DEFAULT))}
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	preamble, err := hex.DecodeString(j.Preamble)
+	if err != nil {
+		return fmt.Errorf("Invalid preamble %q - expected hex digits", j.Preamble)
+	}
+	eolString, err := hex.DecodeString(j.EOLString)
+	if err != nil {
+		return fmt.Errorf("Invalid EOL string %q - expected hex digits", j.EOLString)
+	}
+	eol := []rune(j.EOL)
+	if len(eol) != 1 || eol[0] > 0xff {
+		return fmt.Errorf("Invalid EOL %q - expected a single character", j.EOL)
+	}
+	*c = Config{
+		Name:           j.Name,
+		Baud:           j.Baud,
+		DataBits:       j.DataBits,
+		Parity:         j.Parity,
+		StopBits:       j.StopBits,
+		FlowControl:    j.FlowControl,
+		XON:            j.XON,
+		XOFF:           j.XOFF,
+		ReadTimeout:    time.Duration(j.ReadTimeout),
+		EOL:            byte(eol[0]),
+		EOLString:      string(eolString),
+		ClearOnOpen:    j.ClearOnOpen,
+		Preamble:       string(preamble),
+		LazyLines:      j.LazyLines,
+		NoConfigure:    j.NoConfigure,
+		NoDTROnOpen:    j.NoDTROnOpen,
+		BreakHandling:  j.BreakHandling,
+		WriteBuffering: j.WriteBuffering,
+		ReadWatchdog:   time.Duration(j.ReadWatchdog),
+		WriteChunk:     j.WriteChunk,
+		WriteDelay:     time.Duration(j.WriteDelay),
+		AutoReconnect:  time.Duration(j.AutoReconnect),
+		ReconnectMax:   time.Duration(j.ReconnectMax),
+	}
+	return nil
+}
+
+// SaveConfig writes c to the file at path as JSON, see Config.MarshalJSON.
+func SaveConfig(path string, c Config) error {
+	data, err := json.MarshalIndent(c, "", "\t")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// LoadConfig reads a Config saved by SaveConfig from the file at path, and validates it.
+func LoadConfig(path string) (Config, error) {
+	var c Config
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return c, err
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return c, fmt.Errorf("Invalid configuration \"%s\" - %s", path, err)
+	}
+	if err := c.validate(); err != nil {
+		return c, fmt.Errorf("Invalid configuration \"%s\" - %s", path, err)
+	}
+	return c, nil
+}
+
+var parityNames = map[Parity]string{
+	ParityNone:  "none",
+	ParityOdd:   "odd",
+	ParityEven:  "even",
+	ParityMark:  "mark",
+	ParitySpace: "space",
+}
+
+// String returns the name of the parity, e.g. "even".
+func (p Parity) String() string {
+	if name, ok := parityNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("Parity(%q)", byte(p))
+}
+
+func (p Parity) MarshalText() ([]byte, error) {
+	if _, ok := parityNames[p]; !ok {
+		return nil, fmt.Errorf("Invalid parity %v", p)
+	}
+	return []byte(p.String()), nil
+}
+
+// UnmarshalText accepts the names of String, and the initials of ParseMode.
+func (p *Parity) UnmarshalText(text []byte) error {
+	s := strings.ToLower(string(text))
+	for v, name := range parityNames {
+		if s == name || s == strings.ToLower(string(rune(v))) {
+			*p = v
+			return nil
+		}
+	}
+	return fmt.Errorf("Invalid parity %q", text)
+}
+
+func (s StopBits) MarshalText() ([]byte, error) {
+	switch s {
+	case Stop1, Stop1Half, Stop2:
+		return []byte(s.String()), nil
+	}
+	return nil, fmt.Errorf("Invalid stop bits %v", s)
+}
+
+func (s *StopBits) UnmarshalText(text []byte) error {
+	for _, v := range []StopBits{Stop1, Stop1Half, Stop2} {
+		if string(text) == v.String() {
+			*s = v
+			return nil
+		}
+	}
+	return fmt.Errorf("Invalid stop bits %q", text)
+}
+
+var flowControlNames = []string{"none", "rtscts", "xonxoff"}
+
+// String returns the name of the flow control, e.g. "rtscts" for FlowRTSCTS.
+func (f FlowControl) String() string {
+	if f >= 0 && int(f) < len(flowControlNames) {
+		return flowControlNames[f]
+	}
+	return fmt.Sprintf("FlowControl(%d)", int(f))
+}
+
+func (f FlowControl) MarshalText() ([]byte, error) {
+	if f < 0 || int(f) >= len(flowControlNames) {
+		return nil, fmt.Errorf("Invalid flow control %v", f)
+	}
+	return []byte(f.String()), nil
+}
+
+func (f *FlowControl) UnmarshalText(text []byte) error {
+	for i, name := range flowControlNames {
+		if strings.EqualFold(string(text), name) {
+			*f = FlowControl(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("Invalid flow control %q", text)
+}
+
+var breakHandlingNames = []string{"inject", "ignore", "event"}
+
+// String returns the name of the mode, e.g. "ignore" for BreakIgnore.
+func (b BreakHandling) String() string {
+	if b >= 0 && int(b) < len(breakHandlingNames) {
+		return breakHandlingNames[b]
+	}
+	return fmt.Sprintf("BreakHandling(%d)", int(b))
+}
+
+func (b BreakHandling) MarshalText() ([]byte, error) {
+	if b < 0 || int(b) >= len(breakHandlingNames) {
+		return nil, fmt.Errorf("Invalid break handling %v", b)
+	}
+	return []byte(b.String()), nil
+}
+
+func (b *BreakHandling) UnmarshalText(text []byte) error {
+	for i, name := range breakHandlingNames {
+		if strings.EqualFold(string(text), name) {
+			*b = BreakHandling(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("Invalid break handling %q", text)
+}
+
+var writeBufferingNames = []string{"unbuffered", "line"}
+
+// String returns the name of the mode, e.g. "line" for LineBuffered.
+func (w WriteBuffering) String() string {
+	if w >= 0 && int(w) < len(writeBufferingNames) {
+		return writeBufferingNames[w]
+	}
+	return fmt.Sprintf("WriteBuffering(%d)", int(w))
+}
+
+func (w WriteBuffering) MarshalText() ([]byte, error) {
+	if w < 0 || int(w) >= len(writeBufferingNames) {
+		return nil, fmt.Errorf("Invalid write buffering %v", w)
+	}
+	return []byte(w.String()), nil
+}
+
+func (w *WriteBuffering) UnmarshalText(text []byte) error {
+	for i, name := range writeBufferingNames {
+		if strings.EqualFold(string(text), name) {
+			*w = WriteBuffering(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("Invalid write buffering %q", text)
+}
@@ -0,0 +1,173 @@
+package serial
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOpenOptionsValidation(t *testing.T) {
+	_, err := Open("", WithBaud(0), WithReadTimeout(-time.Second), WithBreakHandling(BreakHandling(7)), WithMode("9600,9N1"),
+		WithFlowControl(FlowXONXOFF), WithXONXOFFChars(0x11, 0x11))
+	if err == nil {
+		t.Fatal("Expected invalid options to be rejected")
+	}
+	for _, want := range []string{"port name", "baud rate", "read timeout", "break handling", "data bits", "XON and XOFF"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected the error to mention %q, got %q", want, err)
+		}
+	}
+}
+
+func TestAutoReconnectValidation(t *testing.T) {
+	// The option, the setter and OpenConfig agree on the valid delays
+	for _, test := range []struct {
+		retry time.Duration
+		valid bool
+	}{{-time.Second, false}, {0, true}, {time.Second, true}} {
+		var c Config
+		optErr := WithAutoReconnect(test.retry, 0)(&c)
+		setErr := New().SetAutoReconnect(test.retry, 0)
+		_, cfgErr := OpenConfig(Config{Name: "COM1", Baud: 9600, AutoReconnect: test.retry})
+		cfgInvalid := cfgErr != nil && strings.Contains(cfgErr.Error(), "reconnect delay")
+		if (optErr == nil) != test.valid || (setErr == nil) != test.valid || cfgInvalid == test.valid {
+			t.Errorf("%v: expected valid %v, got %v, %v and %v", test.retry, test.valid, optErr, setErr, cfgErr)
+		}
+	}
+}
+
+func TestOpenFramingOptions(t *testing.T) {
+	_, err := Open("COM1", WithDataBits(9), WithParity(Parity('X')), WithStopBits(StopBits(3)))
+	if err == nil || !strings.Contains(err.Error(), "data bits") {
+		t.Fatalf("Expected bad data bits to be rejected, got %v", err)
+	}
+	if _, err := Open("COM1", WithParity(Parity('X'))); err == nil || !strings.Contains(err.Error(), "parity") {
+		t.Fatalf("Expected a bad parity to be rejected, got %v", err)
+	}
+	if _, err := Open("COM1", WithStopBits(Stop1Half)); err == nil || !strings.Contains(err.Error(), "stop bits") {
+		t.Fatalf("Expected 1.5 stop bits with 8 data bits to be rejected, got %v", err)
+	}
+}
+
+func TestOpenConfig(t *testing.T) {
+	_, err := OpenConfig(Config{ReadTimeout: -time.Second, FlowControl: FlowControl(5)})
+	if err == nil {
+		t.Fatal("Expected an invalid config to be rejected")
+	}
+	for _, want := range []string{"port name", "baud rate", "read timeout", "flow control"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected the error to mention %q, got %q", want, err)
+		}
+	}
+	// The zero framing is 8N1, the port is missing
+	_, err = OpenConfig(Config{Name: "/dev/does-not-exist", Baud: 115200})
+	if err == nil || strings.Contains(err.Error(), "Invalid") {
+		t.Fatalf("Expected opening a missing port to fail, got %v", err)
+	}
+}
+
+func TestOpenOptionsMissingPort(t *testing.T) {
+	if _, err := Open("/dev/does-not-exist", WithBaud(115200)); err == nil {
+		t.Fatal("Expected opening a missing port to fail")
+	}
+}
+
+func TestConfigSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "port.json")
+	c := Config{Name: "/dev/ttyUSB0", Baud: 19200, DataBits: 7, Parity: ParityEven, StopBits: Stop2, FlowControl: FlowRTSCTS,
+		ReadTimeout: 500 * time.Millisecond, EOL: '\n', EOLString: "\r\n", NoDTROnOpen: true, BreakHandling: BreakIgnore, WriteBuffering: LineBuffered}
+	if err := SaveConfig(path, c); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	for _, want := range []string{`"parity": "even"`, `"stopBits": "2"`, `"readTimeout": "500ms"`, `"breakHandling": "ignore"`, `"flowControl": "rtscts"`} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("Expected %s in %s", want, data)
+		}
+	}
+	loaded, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded != c {
+		t.Fatalf("Expected %+v, got %+v", c, loaded)
+	}
+
+	// The zero settings are saved as the defaults they stand for
+	if err := SaveConfig(path, Config{Name: "COM1", Baud: 9600}); err != nil {
+		t.Fatal(err)
+	}
+	want := Config{Name: "COM1", Baud: 9600, DataBits: 8, Parity: ParityNone, StopBits: Stop1, EOL: EOL_DEFAULT}
+	if loaded, err := LoadConfig(path); err != nil || loaded != want {
+		t.Fatalf("Expected %+v, got %+v (%v)", want, loaded, err)
+	}
+
+	os.WriteFile(path, []byte(`{"name": "COM1", "parity": "sideways"}`), 0644)
+	if _, err := LoadConfig(path); err == nil || !strings.Contains(err.Error(), "parity") {
+		t.Fatalf("Expected a bad parity to be rejected, got %v", err)
+	}
+	os.WriteFile(path, []byte(`{"name": "COM1", "dataBits": 9}`), 0644)
+	if _, err := LoadConfig(path); err == nil || !strings.Contains(err.Error(), "data bits") {
+		t.Fatalf("Expected bad data bits to be rejected, got %v", err)
+	}
+	os.WriteFile(path, []byte(`{"name": "COM1", "flowControl": "dsrdtr"}`), 0644)
+	if _, err := LoadConfig(path); err == nil || !strings.Contains(err.Error(), "flow control") {
+		t.Fatalf("Expected a bad flow control to be rejected, got %v", err)
+	}
+}
+
+func TestSettings(t *testing.T) {
+	sp := New()
+	var f *fakePort
+	sp.openPort = func(name string, baud int, readTimeout time.Duration) (io.ReadWriteCloser, error) {
+		f = newFakePort()
+		return f, nil
+	}
+	sp.EOL('\r')
+	sp.SetFlowControl(FlowXONXOFF)
+	sp.SetWriteChunking(64, time.Millisecond)
+	sp.SetAutoReconnect(time.Second, 0)
+	if err := sp.Open("fake", 19200, 200*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	defer sp.Close()
+	if sp.Name() != "fake" || sp.Baud() != 19200 {
+		t.Fatalf("Expected fake at 19200, got %v at %v", sp.Name(), sp.Baud())
+	}
+	// Read back while the reader thread buffers data
+	go f.dev.Write([]byte("OK\r"))
+	want := Config{Name: "fake", Baud: 19200, DataBits: 8, Parity: ParityNone, StopBits: Stop1, FlowControl: FlowXONXOFF,
+		XON: XONDefault, XOFF: XOFFDefault, ReadTimeout: 200 * time.Millisecond, EOL: '\r', WriteChunk: 64,
+		WriteDelay: time.Millisecond, AutoReconnect: time.Second, ReconnectMax: time.Second}
+	if got := sp.Settings(); got != want {
+		t.Fatalf("Expected %+v, got %+v", want, got)
+	}
+	waitAvailable(t, sp, 3)
+	if line, err := sp.ReadLine(); err != nil || line != "OK" {
+		t.Fatalf("Expected OK, got %q (%v)", line, err)
+	}
+	sp.EOLString("\r\n")
+	if c := sp.Settings(); c.EOLString != "\r\n" || c.EOL != '\n' {
+		t.Fatalf("Expected the multi-byte EOL whole, got %q and %q", c.EOLString, c.EOL)
+	}
+
+	// Read back while the settings change
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			sp.SetFlowControl(FlowControl(i % 3))
+			sp.SetWriteChunking(i, 0)
+			sp.ClearOnOpen(i%2 == 0)
+			sp.SetReadWatchdog(time.Duration(i) * time.Second)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		sp.Settings()
+		sp.Write([]byte("x"))
+	}
+	<-done
+}
@@ -0,0 +1,238 @@
+package serial
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+// maxFrameLength bounds the payload of the frames read by ReadFrameSpec when the spec
+// has no MaxLength, the frames being held in memory.
+const maxFrameLength = 1 << 20
+
+// FrameSpec describes how the messages of a protocol are framed: either by a header
+// declaring the length of the payload, or by a delimiter ending the payload.
+type FrameSpec struct {
+	// Delimiter ends the payload of delimited frames. The header settings are then
+	// ignored.
+	Delimiter []byte
+	// HeaderSize is the size of the header preceding the payload.
+	HeaderSize int
+	// LengthOffset and LengthSize locate the length field in the header. LengthSize is
+	// 1, 2 or 4 bytes.
+	LengthOffset int
+	LengthSize   int
+	// ByteOrder of the length field, big endian if nil.
+	ByteOrder binary.ByteOrder
+	// LengthIncludesHeader tells that the length field counts the header too.
+	LengthIncludesHeader bool
+	// MaxLength rejects the frames declaring a longer payload, 0 for no limit.
+	MaxLength int
+}
+
+// validate checks that spec describes a framing.
+func (spec FrameSpec) validate() error {
+	if len(spec.Delimiter) > 0 {
+		return nil
+	}
+	switch spec.LengthSize {
+	case 1, 2, 4:
+	default:
+		return fmt.Errorf("Invalid length field size %v", spec.LengthSize)
+	}
+	if spec.LengthOffset < 0 || spec.LengthOffset+spec.LengthSize > spec.HeaderSize {
+		return fmt.Errorf("Length field at %v out of the %v bytes header", spec.LengthOffset, spec.HeaderSize)
+	}
+	if spec.MaxLength < 0 {
+		return fmt.Errorf("Invalid maximum length %v", spec.MaxLength)
+	}
+	return nil
+}
+
+// payloadLength returns the length of the payload declared by header.
+func (spec FrameSpec) payloadLength(header []byte) (int, error) {
+	order := spec.ByteOrder
+	if order == nil {
+		order = binary.BigEndian
+	}
+	field := header[spec.LengthOffset : spec.LengthOffset+spec.LengthSize]
+	var n uint64
+	switch spec.LengthSize {
+	case 1:
+		n = uint64(field[0])
+	case 2:
+		n = uint64(order.Uint16(field))
+	case 4:
+		n = uint64(order.Uint32(field))
+	}
+	if spec.LengthIncludesHeader {
+		if n < uint64(spec.HeaderSize) {
+			return 0, fmt.Errorf("Invalid frame length %v, shorter than the header", n)
+		}
+		n -= uint64(spec.HeaderSize)
+	}
+	if spec.MaxLength > 0 && n > uint64(spec.MaxLength) {
+		return 0, fmt.Errorf("Frame length %v exceeds the maximum of %v", n, spec.MaxLength)
+	}
+	return int(n), nil
+}
+
+// frameSize returns the size of the frame starting data, header included, or -1 while
+// its header is incomplete.
+func (spec FrameSpec) frameSize(data []byte) (int, error) {
+	if len(data) < spec.HeaderSize {
+		return -1, nil
+	}
+	n, err := spec.payloadLength(data[:spec.HeaderSize])
+	if err != nil {
+		return 0, err
+	}
+	return spec.HeaderSize + n, nil
+}
+
+// ReadFrameSpec waits up to timeout for a complete frame, framed as described by spec,
+// and consumes and returns it as received: the header followed by the payload, e.g. the
+// type, length and value of a TLV message, or the payload followed by the delimiter.
+//
+// Without MaxLength, a payload longer than 1 MiB is rejected, the length field being
+// more likely garbage. The header of a frame rejected is consumed, so that the next call
+// starts after it. On timeout, the beginning of the frame received is consumed and
+// returned with the timeout error.
+func (sp *SerialPort) ReadFrameSpec(spec FrameSpec, timeout time.Duration) ([]byte, error) {
+	if err := spec.validate(); err != nil {
+		return nil, err
+	}
+	if spec.MaxLength == 0 {
+		spec.MaxLength = maxFrameLength
+	}
+	var frame []byte
+	var frameErr error
+	err := sp.waitBuffer(timeout, func(buff *bytes.Buffer) bool {
+		data := buff.Bytes()
+		size := -1
+		if len(spec.Delimiter) > 0 {
+			if i := bytes.Index(data, spec.Delimiter); i >= 0 {
+				size = i + len(spec.Delimiter)
+			}
+		} else if size, frameErr = spec.frameSize(data); frameErr != nil {
+			buff.Next(spec.HeaderSize)
+			return true
+		}
+		if size < 0 || len(data) < size {
+			return false
+		}
+		frame = append([]byte(nil), buff.Next(size)...)
+		return true
+	})
+	if frameErr != nil {
+		return nil, frameErr
+	}
+	if err != nil {
+		sp.buffMu.Lock()
+		n := sp.buff.Len()
+		if len(spec.Delimiter) == 0 {
+			if size, err := spec.frameSize(sp.buff.Bytes()); err == nil && size >= 0 && size < n {
+				n = size
+			}
+		}
+		frame = append([]byte(nil), sp.buff.Next(n)...)
+		sp.buffMu.Unlock()
+		return frame, err
+	}
+	return frame, nil
+}
+
+// FrameReader returns a reader of the payload of the next frame received, framed as
+// described by spec, which returns io.EOF at the end of the frame. The payload is
+// consumed as it is read, so a large frame can be copied, e.g. to a file, without being
+// held in memory.
+//
+// For frames with a header, FrameReader first waits for the header and consumes it; the
+// delimiter of delimited frames is consumed but not returned. Like TextprotoReader, the
+// reads wait up to the read timeout given to Open. Closing the reader before the end of
+// the frame discards the rest of the frame.
+func (sp *SerialPort) FrameReader(spec FrameSpec) (io.ReadCloser, error) {
+	if err := spec.validate(); err != nil {
+		return nil, err
+	}
+	if len(spec.Delimiter) > 0 {
+		return &frameReader{sp: sp, delim: spec.Delimiter}, nil
+	}
+	var header []byte
+	err := sp.waitBuffer(sp.streamTimeout(), func(buff *bytes.Buffer) bool {
+		if buff.Len() < spec.HeaderSize {
+			return false
+		}
+		// Copied, the buffer is written by the reader thread once released
+		header = append([]byte(nil), buff.Next(spec.HeaderSize)...)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	n, err := spec.payloadLength(header)
+	if err != nil {
+		return nil, err
+	}
+	return &frameReader{sp: sp, remaining: n}, nil
+}
+
+// frameReader reads the payload of a frame, either remaining bytes or up to delim.
+type frameReader struct {
+	sp        *SerialPort
+	delim     []byte
+	remaining int
+	ended     bool
+}
+
+func (r *frameReader) Read(b []byte) (int, error) {
+	if r.ended || (r.delim == nil && r.remaining == 0) {
+		return 0, io.EOF
+	}
+	if len(b) == 0 {
+		return 0, nil
+	}
+	var n int
+	err := r.sp.waitBuffer(r.sp.streamTimeout(), func(buff *bytes.Buffer) bool {
+		if r.delim == nil {
+			if buff.Len() == 0 {
+				return false
+			}
+			if len(b) > r.remaining {
+				b = b[:r.remaining]
+			}
+			n, _ = buff.Read(b)
+			r.remaining -= n
+			return true
+		}
+		data := buff.Bytes()
+		end := bytes.Index(data, r.delim)
+		if end < 0 {
+			// Keep what could be the start of the delimiter
+			end = len(data) - len(r.delim) + 1
+			if end <= 0 {
+				return false
+			}
+		}
+		n = copy(b, data[:end])
+		buff.Next(n)
+		if bytes.HasPrefix(buff.Bytes(), r.delim) {
+			buff.Next(len(r.delim))
+			r.ended = true
+		}
+		return n > 0 || r.ended
+	})
+	if err == nil && n == 0 {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// Close discards the rest of the frame.
+func (r *frameReader) Close() error {
+	_, err := io.Copy(io.Discard, r)
+	r.ended = true
+	return err
+}
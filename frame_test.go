@@ -0,0 +1,136 @@
+package serial
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestFrameReaderLength(t *testing.T) {
+	sp, f := New(), newFakePort()
+	sp.readTimeout = time.Second
+	sp.start("fake", 9600, f)
+	defer sp.Close()
+	payload := bytes.Repeat([]byte("0123456789"), 100)
+	spec := FrameSpec{HeaderSize: 3, LengthOffset: 1, LengthSize: 2, ByteOrder: binary.LittleEndian}
+	go func() {
+		f.dev.Write([]byte{0x42, 0xe8, 0x03})
+		f.dev.Write(payload[:300])
+		time.Sleep(5 * time.Millisecond)
+		f.dev.Write(payload[300:])
+		f.dev.Write([]byte{0x42, 0x04, 0x00, 'a', 'b', 'c', 'd', 'n', 'e', 'x', 't'})
+	}()
+
+	r, err := sp.FrameReader(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got bytes.Buffer
+	if n, err := io.Copy(&got, r); err != nil || n != int64(len(payload)) || !bytes.Equal(got.Bytes(), payload) {
+		t.Fatalf("Expected the %v bytes payload, got %v bytes (%v)", len(payload), n, err)
+	}
+	r.Close()
+
+	// Closing early discards the rest of the frame
+	r, err = sp.FrameReader(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, 2)
+	if n, _ := io.ReadFull(r, b); n != 2 || string(b) != "ab" {
+		t.Fatalf("Expected \"ab\", got %q", b[:n])
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	waitAvailable(t, sp, 4)
+	if data, _ := sp.ReadBurst(time.Second, 10*time.Millisecond, 16); string(data) != "next" {
+		t.Fatalf("Expected \"next\" after the frame, got %q", data)
+	}
+
+	spec.MaxLength = 2
+	f.dev.Write([]byte{0x42, 0x04, 0x00})
+	if _, err := sp.FrameReader(spec); err == nil {
+		t.Fatal("Expected a too long frame to be rejected")
+	}
+}
+
+func TestFrameReaderDelimited(t *testing.T) {
+	sp, f := New(), newFakePort()
+	sp.readTimeout = time.Second
+	sp.start("fake", 9600, f)
+	defer sp.Close()
+	go func() {
+		f.dev.Write([]byte("hello wor"))
+		f.dev.Write([]byte("ld\r"))
+		time.Sleep(5 * time.Millisecond)
+		f.dev.Write([]byte("\nnext"))
+	}()
+	r, err := sp.FrameReader(FrameSpec{Delimiter: []byte("\r\n")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil || string(data) != "hello world" {
+		t.Fatalf("Expected \"hello world\", got %q (%v)", data, err)
+	}
+	waitAvailable(t, sp, 4)
+	if n := sp.Available(); n != 4 {
+		t.Fatalf("Expected only \"next\" left, %v bytes available", n)
+	}
+
+	if _, err := sp.FrameReader(FrameSpec{HeaderSize: 2, LengthOffset: 1, LengthSize: 2}); err == nil {
+		t.Fatal("Expected a length field out of the header to be rejected")
+	}
+}
+
+func TestReadFrameSpec(t *testing.T) {
+	sp, f := openFake(t)
+	defer sp.Close()
+	// TLV: a type byte, then a 2 bytes length counting the header
+	spec := FrameSpec{HeaderSize: 3, LengthOffset: 1, LengthSize: 2, LengthIncludesHeader: true}
+	go func() {
+		f.dev.Write([]byte{0x01, 0x00})
+		time.Sleep(5 * time.Millisecond)
+		f.dev.Write([]byte{0x07, 'a', 'b'})
+		time.Sleep(5 * time.Millisecond)
+		f.dev.Write([]byte{'c', 'd', 0x02, 0x00, 0x04, 'z'})
+	}()
+	frame, err := sp.ReadFrameSpec(spec, time.Second)
+	if err != nil || !bytes.Equal(frame, []byte{0x01, 0x00, 0x07, 'a', 'b', 'c', 'd'}) {
+		t.Fatalf("Expected the 7 bytes frame, got %q (%v)", frame, err)
+	}
+	frame, err = sp.ReadFrameSpec(spec, time.Second)
+	if err != nil || !bytes.Equal(frame, []byte{0x02, 0x00, 0x04, 'z'}) {
+		t.Fatalf("Expected the 4 bytes frame, got %q (%v)", frame, err)
+	}
+
+	// Partial frame on timeout
+	f.dev.Write([]byte{0x03, 0x00, 0x10, 'p', 'a'})
+	waitAvailable(t, sp, 5)
+	frame, err = sp.ReadFrameSpec(spec, 20*time.Millisecond)
+	if err == nil || !bytes.Equal(frame, []byte{0x03, 0x00, 0x10, 'p', 'a'}) {
+		t.Fatalf("Expected the partial frame with a timeout, got %q (%v)", frame, err)
+	}
+
+	// Absurd lengths are rejected, their header consumed
+	spec.LengthSize, spec.HeaderSize = 4, 5
+	f.dev.Write([]byte{0x04, 0x7f, 0xff, 0xff, 0xff, 'x'})
+	waitAvailable(t, sp, 6)
+	if _, err := sp.ReadFrameSpec(spec, time.Second); err == nil {
+		t.Fatal("Expected an absurd length to be rejected")
+	}
+	if n := sp.Available(); n != 1 {
+		t.Fatalf("Expected the header to be consumed, %v bytes available", n)
+	}
+
+	// Delimited frames keep their delimiter
+	f.dev.Write([]byte("OK\r\nnext"))
+	waitAvailable(t, sp, 9)
+	frame, err = sp.ReadFrameSpec(FrameSpec{Delimiter: []byte("\r\n")}, time.Second)
+	if err != nil || string(frame) != "xOK\r\n" {
+		t.Fatalf("Expected \"xOK\\r\\n\", got %q (%v)", frame, err)
+	}
+}
@@ -0,0 +1,32 @@
+package serial
+
+import (
+	"syscall"
+	"testing"
+	"time"
+)
+
+// cpuTime returns the CPU time used by the process so far.
+func cpuTime(tb testing.TB) time.Duration {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		tb.Fatal(err)
+	}
+	return time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+}
+
+// BenchmarkWaitForRegexIdle waits for a line that never comes on a silent port, and
+// reports the CPU used during the wait in percent of the time waited: it stays close to
+// 0 as the wait sleeps until data is buffered.
+func BenchmarkWaitForRegexIdle(b *testing.B) {
+	sp, _ := openFake(b)
+	defer sp.Close()
+	const wait = 50 * time.Millisecond
+	start, cpu := time.Now(), cpuTime(b)
+	for i := 0; i < b.N; i++ {
+		if _, err := sp.WaitForRegexTimeout(`^OK$`, wait); err == nil {
+			b.Fatal("Expected a timeout on a silent port")
+		}
+	}
+	b.ReportMetric(100*float64(cpuTime(b)-cpu)/float64(time.Since(start)), "cpu-%")
+}
@@ -0,0 +1,58 @@
+package serial
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ReadJSON waits up to timeout for a complete JSON value, e.g. an object of a device
+// streaming newline-delimited JSON, and consumes and unmarshals it into v. The value is
+// framed by its syntax, not by lines, so an object split across reads or lines is
+// returned once complete, and the data following it stays buffered. On timeout, the
+// partial value is kept in the buffer.
+//
+// Malformed JSON is discarded up to the end of its line, or the whole buffer without a
+// newline, and returned as an error so the stream can resync on the next value.
+func (sp *SerialPort) ReadJSON(v interface{}, timeout time.Duration) error {
+	var raw json.RawMessage
+	var syntaxErr error
+	err := sp.waitBuffer(timeout, func(buff *bytes.Buffer) bool {
+		data := buff.Bytes()
+		dec := json.NewDecoder(bytes.NewReader(data))
+		if err := dec.Decode(&raw); err != nil {
+			if err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF) {
+				// Nothing or a partial value yet
+				return false
+			}
+			syntaxErr = err
+			skip := len(data)
+			var se *json.SyntaxError
+			if errors.As(err, &se) && se.Offset > 0 && int(se.Offset) <= len(data) {
+				if i := bytes.IndexByte(data[se.Offset-1:], '\n'); i >= 0 {
+					skip = int(se.Offset) + i
+				}
+			}
+			buff.Next(skip)
+			return true
+		}
+		end := int(dec.InputOffset())
+		if c := raw[0]; (c == '-' || c >= '0' && c <= '9') && end == len(data) {
+			// A number may go on in the next read
+			return false
+		}
+		raw = append(json.RawMessage(nil), raw...)
+		buff.Next(end)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if syntaxErr != nil {
+		return fmt.Errorf("Malformed JSON - %s", syntaxErr)
+	}
+	return json.Unmarshal(raw, v)
+}
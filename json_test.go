@@ -0,0 +1,50 @@
+package serial
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadJSON(t *testing.T) {
+	sp, f := openFake(t)
+	defer sp.Close()
+	type reading struct {
+		Sensor string  `json:"sensor"`
+		Value  float64 `json:"value"`
+	}
+	// An object split across writes and lines, followed by the next one
+	go func() {
+		f.dev.Write([]byte(`{"sensor": "temp",`))
+		time.Sleep(20 * time.Millisecond)
+		f.dev.Write([]byte("\n\"value\": 21.5}\n{\"sensor\": \"hum\", \"value\": 40}\n42"))
+	}()
+	var r reading
+	if err := sp.ReadJSON(&r, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if r != (reading{"temp", 21.5}) {
+		t.Fatalf("Expected the temp reading, got %+v", r)
+	}
+	if err := sp.ReadJSON(&r, time.Second); err != nil || r != (reading{"hum", 40}) {
+		t.Fatalf("Expected the hum reading, got %+v (%v)", r, err)
+	}
+	// The number may go on, it is complete once followed by a delimiter
+	var n int
+	if err := sp.ReadJSON(&n, 20*time.Millisecond); err == nil {
+		t.Fatalf("Expected a timeout on a trailing number, got %v", n)
+	}
+	f.dev.Write([]byte("1\n"))
+	if err := sp.ReadJSON(&n, time.Second); err != nil || n != 421 {
+		t.Fatalf("Expected 421, got %v (%v)", n, err)
+	}
+
+	// Malformed JSON is dropped up to the end of its line
+	f.dev.Write([]byte("{\"sensor\": oops}\n{\"sensor\": \"ok\"}\n"))
+	if err := sp.ReadJSON(&r, time.Second); err == nil || !strings.Contains(err.Error(), "Malformed JSON") {
+		t.Fatalf("Expected a malformed JSON error, got %v", err)
+	}
+	if err := sp.ReadJSON(&r, time.Second); err != nil || r.Sensor != "ok" {
+		t.Fatalf("Expected to resync on the next object, got %+v (%v)", r, err)
+	}
+}
@@ -0,0 +1,165 @@
+package serial
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Parity selects the parity bit of the characters.
+type Parity byte
+
+const (
+	ParityNone  Parity = 'N'
+	ParityOdd   Parity = 'O'
+	ParityEven  Parity = 'E'
+	ParityMark  Parity = 'M' // parity bit always 1
+	ParitySpace Parity = 'S' // parity bit always 0
+)
+
+// StopBits selects the number of stop bits of the characters.
+type StopBits byte
+
+const (
+	Stop1     StopBits = 1
+	Stop1Half StopBits = 15
+	Stop2     StopBits = 2
+)
+
+// String returns the number of stop bits, "1", "1.5" or "2".
+func (s StopBits) String() string {
+	if s == Stop1Half {
+		return "1.5"
+	}
+	return strconv.Itoa(int(s))
+}
+
+// FlowControl selects how the transmission is paused when the receiver can't keep up.
+type FlowControl int
+
+const (
+	// FlowNone disables flow control (default).
+	FlowNone FlowControl = iota
+	// FlowRTSCTS is the hardware flow control on the RTS and CTS lines.
+	FlowRTSCTS
+	// FlowXONXOFF is the software flow control, the receiver sending the XOFF and XON
+	// characters to pause and resume the transmission.
+	FlowXONXOFF
+)
+
+// Default XON and XOFF characters, DC1 and DC3.
+const (
+	XONDefault  byte = 0x11
+	XOFFDefault byte = 0x13
+)
+
+// Mode is a line configuration, as written in the shorthand of the serial tools: baud
+// rate, data bits, parity and stop bits ("115200,8N1").
+type Mode struct {
+	Baud     int
+	DataBits int // 5 to 8
+	Parity   Parity
+	StopBits StopBits
+}
+
+// ParseMode parses a line configuration in the "<baud>,<data bits><parity><stop bits>"
+// shorthand, e.g. "115200,8N1", "9600,7E2" or "4800,5N1.5". The parity is given by its
+// initial: N (none), O (odd), E (even), M (mark) or S (space).
+func ParseMode(s string) (Mode, error) {
+	var m Mode
+	baud, frame, ok := strings.Cut(strings.TrimSpace(s), ",")
+	if !ok || len(frame) < 3 {
+		return m, fmt.Errorf("Invalid mode %q - expected <baud>,<data bits><parity><stop bits> like \"115200,8N1\"", s)
+	}
+	var err error
+	if m.Baud, err = strconv.Atoi(baud); err != nil || m.Baud <= 0 {
+		return m, fmt.Errorf("Invalid mode %q - bad baud rate %q", s, baud)
+	}
+	m.DataBits = int(frame[0] - '0')
+	m.Parity = Parity(strings.ToUpper(frame[1:2])[0])
+	switch frame[2:] {
+	case "1":
+		m.StopBits = Stop1
+	case "1.5":
+		m.StopBits = Stop1Half
+	case "2":
+		m.StopBits = Stop2
+	default:
+		return m, fmt.Errorf("Invalid mode %q - bad stop bits %q", s, frame[2:])
+	}
+	if err := m.validate(); err != nil {
+		return m, fmt.Errorf("Invalid mode %q - %s", s, err)
+	}
+	return m, nil
+}
+
+// String returns m in the shorthand parsed by ParseMode.
+func (m Mode) String() string {
+	return fmt.Sprintf("%d,%d%c%v", m.Baud, m.DataBits, m.Parity, m.StopBits)
+}
+
+// SetMode changes the line configuration of the open port to the shorthand mode, see
+// ParseMode. The data received is kept, and the data written before is transmitted with
+// the old configuration first. Either the whole configuration is applied or none of it.
+func (sp *SerialPort) SetMode(mode string) error {
+	m, err := ParseMode(mode)
+	if err != nil {
+		return err
+	}
+	sp.writeMu.Lock()
+	defer sp.writeMu.Unlock()
+	p, err := sp.sysPort()
+	if err != nil {
+		return err
+	}
+	// Let the data written leave the line
+	time.Sleep(time.Until(sp.txEnd))
+	st, err := p.saveState()
+	if err != nil {
+		return err
+	}
+	if err = p.setFraming(m.DataBits, m.Parity, m.StopBits); err == nil {
+		err = p.setBaud(m.Baud)
+	}
+	if err != nil {
+		// Put the previous configuration back
+		p.restoreState(st)
+		return err
+	}
+	sp.settingsMu.Lock()
+	sp.baud = m.Baud
+	sp.dataBits, sp.parity, sp.stopBits = m.DataBits, m.Parity, m.StopBits
+	sp.settingsMu.Unlock()
+	return nil
+}
+
+// Mode returns the active line settings of the port: baud rate, data bits, parity and
+// stop bits.
+func (sp *SerialPort) Mode() Mode {
+	sp.settingsMu.RLock()
+	defer sp.settingsMu.RUnlock()
+	return Mode{sp.baud, sp.dataBits, sp.parity, sp.stopBits}
+}
+
+// validate checks the framing settings of m.
+func (m Mode) validate() error {
+	if m.DataBits < 5 || m.DataBits > 8 {
+		return fmt.Errorf("Invalid data bits %v", m.DataBits)
+	}
+	switch m.Parity {
+	case ParityNone, ParityOdd, ParityEven, ParityMark, ParitySpace:
+	default:
+		return fmt.Errorf("Invalid parity %q", byte(m.Parity))
+	}
+	switch m.StopBits {
+	case Stop1, Stop1Half, Stop2:
+	default:
+		return fmt.Errorf("Invalid stop bits %v", m.StopBits)
+	}
+	// UARTs only send 1.5 stop bits with 5 data bits, as 2 stop bits otherwise
+	if m.StopBits == Stop1Half && m.DataBits != 5 {
+		return fmt.Errorf("Invalid stop bits 1.5 with %v data bits - only valid with 5 data bits", m.DataBits)
+	}
+	return nil
+}
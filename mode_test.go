@@ -0,0 +1,92 @@
+package serial
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseMode(t *testing.T) {
+	tests := []struct {
+		in   string
+		mode Mode
+	}{
+		{"115200,8N1", Mode{115200, 8, ParityNone, Stop1}},
+		{"9600,7E2", Mode{9600, 7, ParityEven, Stop2}},
+		{"4800,5o1.5", Mode{4800, 5, ParityOdd, Stop1Half}},
+		{" 300,8S1 ", Mode{300, 8, ParitySpace, Stop1}},
+	}
+	for _, test := range tests {
+		m, err := ParseMode(test.in)
+		if err != nil || m != test.mode {
+			t.Errorf("ParseMode(%q) = %+v (%v), expected %+v", test.in, m, err, test.mode)
+		}
+	}
+
+	for _, in := range []string{"", "115200", "8N1", "abc,8N1", "-1,8N1", "9600,9N1", "9600,8X1", "9600,8N3", "9600,8N"} {
+		if _, err := ParseMode(in); err == nil {
+			t.Errorf("Expected an error for %q", in)
+		}
+	}
+}
+
+func TestModeCombinations(t *testing.T) {
+	if _, err := ParseMode("9600,8N1.5"); err == nil || !strings.Contains(err.Error(), "5 data bits") {
+		t.Fatalf("Expected 1.5 stop bits with 8 data bits to be rejected, got %v", err)
+	}
+	if _, err := ParseMode("9600,5N1.5"); err != nil {
+		t.Fatal(err)
+	}
+	sp := New()
+	sp.baud = 4800
+	sp.dataBits, sp.parity, sp.stopBits = 7, ParityEven, Stop2
+	if m := sp.Mode(); m != (Mode{4800, 7, ParityEven, Stop2}) {
+		t.Fatalf("Expected 4800,7E2, got %v", m)
+	}
+}
+
+func TestModeString(t *testing.T) {
+	tests := []struct {
+		mode Mode
+		out  string
+	}{
+		{Mode{115200, 8, ParityNone, Stop1}, "115200,8N1"},
+		{Mode{9600, 7, ParityEven, Stop2}, "9600,7E2"},
+		{Mode{4800, 5, ParityOdd, Stop1Half}, "4800,5O1.5"},
+		{Mode{19200, 7, ParityMark, Stop1Half}, "19200,7M1.5"},
+		{Mode{300, 6, ParitySpace, Stop1}, "300,6S1"},
+	}
+	for _, test := range tests {
+		if out := test.mode.String(); out != test.out {
+			t.Errorf("%+v.String() = %q, expected %q", test.mode, out, test.out)
+		}
+		// What String returns parses back, as far as the mode is valid
+		if m, err := ParseMode(test.out); err == nil && m != test.mode {
+			t.Errorf("ParseMode(%q) = %+v, expected %+v", test.out, m, test.mode)
+		}
+	}
+}
+
+func TestSetMode(t *testing.T) {
+	sp, _ := openFake(t)
+	defer sp.Close()
+	tests := []struct {
+		mode string
+		err  string
+	}{
+		{"9600,8X1", "parity"},
+		{"9600,8N1.5", "5 data bits"},
+		{"115200,7E1", "not supported"}, // only the ports of the platform are reconfigured
+	}
+	for _, test := range tests {
+		if err := sp.SetMode(test.mode); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("SetMode(%q) = %v, expected an error containing %q", test.mode, err, test.err)
+		}
+		if m := sp.Mode(); m != (Mode{9600, 8, ParityNone, Stop1}) {
+			t.Fatalf("Expected the mode unchanged by SetMode(%q), got %v", test.mode, m)
+		}
+	}
+	sp.Close()
+	if err := sp.SetMode("9600,8N1"); err != errNotOpen {
+		t.Fatalf("Expected errNotOpen once closed, got %v", err)
+	}
+}
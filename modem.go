@@ -0,0 +1,180 @@
+package serial
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ModemLine is a set of input modem control lines.
+type ModemLine int
+
+const (
+	LineCTS ModemLine = 1 << iota // Clear To Send
+	LineDSR                       // Data Set Ready
+	LineDCD                       // Data Carrier Detect
+	LineRI                        // Ring Indicator
+)
+
+// String returns the names of the lines, e.g. "CTS|DSR".
+func (l ModemLine) String() string {
+	var names []string
+	for _, line := range []struct {
+		line ModemLine
+		name string
+	}{{LineCTS, "CTS"}, {LineDSR, "DSR"}, {LineDCD, "DCD"}, {LineRI, "RI"}} {
+		if l&line.line != 0 {
+			names = append(names, line.name)
+		}
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, "|")
+}
+
+// errModemWaitUnsupported is returned by waitModemChange when the lines must be polled.
+var errModemWaitUnsupported = errors.New("Modem line wait not supported")
+
+// modemPort is a port with input modem control lines.
+type modemPort interface {
+	modemLines() (ModemLine, error)
+	waitModemChange(lines ModemLine) error
+}
+
+// WaitForModemLine waits up to timeout for line (LineCTS, LineDSR, LineDCD or LineRI) to
+// be in state, asserted or not, e.g. for a modem raising DSR once ready. It returns right
+// away if the line is already in state.
+//
+// On Linux the driver signals the line changes (TIOCMIWAIT), elsewhere, and for drivers
+// without it, the line is polled every 10 ms. The wait in the driver can't be cancelled:
+// after a timeout, a thread keeps waiting there until the line changes or the port is
+// closed.
+func (sp *SerialPort) WaitForModemLine(line ModemLine, state bool, timeout time.Duration) error {
+	if !sp.portIsOpen.Load() {
+		return errNotOpen
+	}
+	p, ok := sp.device().(modemPort)
+	if !ok {
+		return fmt.Errorf("Modem lines not supported on \"%s\"", sp.Name())
+	}
+	reached := func() (bool, error) {
+		lines, err := p.modemLines()
+		return (lines&line != 0) == state, err
+	}
+	if ok, err := reached(); err != nil || ok {
+		return err
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	// Polled as well while waiting for the driver, to catch a change just before the wait
+	poll := time.NewTicker(100 * time.Millisecond)
+	defer poll.Stop()
+	changed := make(chan error, 1)
+	waiting := false
+	wait := func() {
+		waiting = true
+		result := changed
+		sp.goThread(func() { result <- p.waitModemChange(line) })
+	}
+	wait()
+	for {
+		select {
+		case err := <-changed:
+			waiting = false
+			if err == errModemWaitUnsupported {
+				poll.Reset(10 * time.Millisecond)
+				changed = nil
+			} else if err != nil {
+				return err
+			}
+		case <-poll.C:
+		case <-timer.C:
+			return fmt.Errorf("Timeout expired")
+		}
+		if ok, err := reached(); err != nil || ok {
+			return err
+		}
+		if !waiting && changed != nil {
+			wait()
+		}
+	}
+}
+
+// ModemStatus returns the input modem control lines asserted, e.g. lines&LineDSR != 0
+// once a modem is ready. It reads them from the driver (TIOCMGET on POSIX,
+// GetCommModemStatus on Windows).
+func (sp *SerialPort) ModemStatus() (ModemLine, error) {
+	if !sp.portIsOpen.Load() {
+		return 0, errNotOpen
+	}
+	p, ok := sp.device().(modemPort)
+	if !ok {
+		return 0, fmt.Errorf("Modem lines not supported on \"%s\"", sp.Name())
+	}
+	return p.modemLines()
+}
+
+// FlowBlocked reports whether the transmission is paused by the flow control, to find why
+// writes hang: CTS deasserted by the remote with FlowRTSCTS, or an XOFF received and not
+// followed by XON with FlowXONXOFF. It is always false with FlowNone.
+//
+// On Windows both states are reported by the driver (ClearCommError). On POSIX systems
+// the driver consumes the XOFF and XON characters without reporting its state, so only
+// FlowRTSCTS is supported, from the CTS line.
+func (sp *SerialPort) FlowBlocked() (bool, error) {
+	if !sp.portIsOpen.Load() {
+		return false, errNotOpen
+	}
+	flow := sp.FlowControl()
+	if flow == FlowNone {
+		return false, nil
+	}
+	if p, ok := sp.device().(interface{ flowHold() (bool, bool, error) }); ok {
+		cts, xoff, err := p.flowHold()
+		if flow == FlowRTSCTS {
+			return cts, err
+		}
+		return xoff, err
+	}
+	if flow == FlowXONXOFF {
+		return false, fmt.Errorf("XOFF state not reported by the driver of \"%s\"", sp.Name())
+	}
+	lines, err := sp.ModemStatus()
+	if err != nil {
+		return false, err
+	}
+	return lines&LineCTS == 0, nil
+}
+
+// SetDTR asserts or deasserts the Data Terminal Ready output line, e.g. with SetRTS to
+// reset a board or make it enter its bootloader (ESP8266, ESP32).
+func (sp *SerialPort) SetDTR(on bool) error {
+	if !sp.portIsOpen.Load() {
+		return errNotOpen
+	}
+	p, ok := sp.device().(interface{ setDTR(on bool) error })
+	if !ok {
+		return fmt.Errorf("DTR control not supported on \"%s\"", sp.Name())
+	}
+	return p.setDTR(on)
+}
+
+// SetRTS asserts or deasserts the Request To Send output line. It fails while the RTS
+// turnaround drives the line, see SetRTSTurnaround.
+func (sp *SerialPort) SetRTS(on bool) error {
+	sp.writeMu.Lock()
+	defer sp.writeMu.Unlock()
+	if !sp.portIsOpen.Load() {
+		return errNotOpen
+	}
+	if sp.rtsTurnaround {
+		return fmt.Errorf("RTS driven by the RTS turnaround on \"%s\"", sp.Name())
+	}
+	p, ok := sp.device().(interface{ setRTS(on bool) error })
+	if !ok {
+		return fmt.Errorf("RTS control not supported on \"%s\"", sp.Name())
+	}
+	return p.setRTS(on)
+}
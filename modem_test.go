@@ -0,0 +1,154 @@
+package serial
+
+import (
+	"fmt"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestWaitForModemLine(t *testing.T) {
+	for _, signaled := range []bool{false, true} {
+		sp, f := openFake(t)
+		if signaled {
+			f.modemChanges = make(chan struct{})
+		}
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			f.setModem(LineCTS)
+			time.Sleep(20 * time.Millisecond)
+			f.setModem(LineCTS | LineDSR)
+		}()
+		start := time.Now()
+		if err := sp.WaitForModemLine(LineDSR, true, time.Second); err != nil {
+			t.Fatal(err)
+		}
+		if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+			t.Fatalf("Expected to wait for DSR, returned after %v", elapsed)
+		}
+		if err := sp.WaitForModemLine(LineRI, false, time.Second); err != nil {
+			t.Fatalf("Expected RI to be low already, got %v", err)
+		}
+		if err := sp.WaitForModemLine(LineDCD, true, 20*time.Millisecond); err == nil {
+			t.Fatal("Expected a timeout")
+		}
+		sp.Close()
+	}
+}
+
+func TestModemLineString(t *testing.T) {
+	if s := (LineCTS | LineDCD).String(); s != "CTS|DCD" {
+		t.Fatalf("Expected \"CTS|DCD\", got %q", s)
+	}
+}
+
+func TestModemStatus(t *testing.T) {
+	sp, f := openFake(t)
+	defer sp.Close()
+	f.setModem(LineDSR | LineDCD)
+	lines, err := sp.ModemStatus()
+	if err != nil || lines != LineDSR|LineDCD {
+		t.Fatalf("Expected DSR|DCD, got %v (%v)", lines, err)
+	}
+	sp.Close()
+	if _, err := sp.ModemStatus(); err != errNotOpen {
+		t.Fatalf("Expected %v, got %v", errNotOpen, err)
+	}
+}
+
+// dtrLoopback is a fakePort whose DTR is tied to DSR, like a loopback adapter.
+type dtrLoopback struct {
+	*fakePort
+}
+
+func (f dtrLoopback) setDTR(on bool) error {
+	f.fakePort.setDTR(on)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if on {
+		f.modem |= LineDSR
+	} else {
+		f.modem &^= LineDSR
+	}
+	return nil
+}
+
+func TestModemLoopback(t *testing.T) {
+	sp := New()
+	sp.openPort = func(name string, baud int, readTimeout time.Duration) (io.ReadWriteCloser, error) {
+		return dtrLoopback{newFakePort()}, nil
+	}
+	if err := sp.Open("fake", 115200); err != nil {
+		t.Fatal(err)
+	}
+	defer sp.Close()
+	for _, on := range []bool{true, false, true} {
+		if err := sp.SetDTR(on); err != nil {
+			t.Fatal(err)
+		}
+		lines, err := sp.ModemStatus()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (lines&LineDSR != 0) != on {
+			t.Fatalf("Expected DSR to follow DTR %v, got %v", on, lines)
+		}
+	}
+}
+
+func TestFlowBlocked(t *testing.T) {
+	sp, f := openFake(t)
+	defer sp.Close()
+	if blocked, err := sp.FlowBlocked(); err != nil || blocked {
+		t.Fatalf("Expected no blocking without flow control, got %v (%v)", blocked, err)
+	}
+	sp.SetFlowControl(FlowRTSCTS)
+	f.setModem(LineDSR)
+	if blocked, err := sp.FlowBlocked(); err != nil || !blocked {
+		t.Fatalf("Expected blocking with CTS low, got %v (%v)", blocked, err)
+	}
+	f.setModem(LineCTS | LineDSR)
+	if blocked, err := sp.FlowBlocked(); err != nil || blocked {
+		t.Fatalf("Expected no blocking with CTS high, got %v (%v)", blocked, err)
+	}
+	sp.SetFlowControl(FlowXONXOFF)
+	if _, err := sp.FlowBlocked(); err == nil {
+		t.Fatal("Expected the XOFF state to be unsupported")
+	}
+	sp.Close()
+	if _, err := sp.FlowBlocked(); err != errNotOpen {
+		t.Fatalf("Expected %v, got %v", errNotOpen, err)
+	}
+}
+
+func TestSetDTRRTS(t *testing.T) {
+	sp, f := openFake(t)
+	defer sp.Close()
+	// Reset sequence of an ESP8266 into its bootloader
+	for _, step := range []struct{ dtr, rts bool }{{false, true}, {true, false}, {false, false}} {
+		if err := sp.SetDTR(step.dtr); err != nil {
+			t.Fatal(err)
+		}
+		if err := sp.SetRTS(step.rts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f.mu.Lock()
+	dtr, rts := fmt.Sprint(f.dtr), make([]bool, len(f.rts))
+	for i, c := range f.rts {
+		rts[i] = c.on
+	}
+	f.mu.Unlock()
+	if dtr != "[false true false]" || fmt.Sprint(rts) != "[true false false]" {
+		t.Fatalf("Unexpected line changes, DTR %v and RTS %v", dtr, rts)
+	}
+
+	sp.SetRTSTurnaround(true, 0)
+	if err := sp.SetRTS(true); err == nil {
+		t.Fatal("Expected RTS to be refused with the RTS turnaround")
+	}
+	sp.Close()
+	if err := sp.SetDTR(true); err != errNotOpen {
+		t.Fatalf("Expected %v, got %v", errNotOpen, err)
+	}
+}
@@ -0,0 +1,44 @@
+package serial
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ReadNMEA waits up to timeout for a NMEA-0183 sentence, a line starting with '$' or
+// '!', and consumes and returns it without the line end. valid reports whether the
+// sentence ends with a checksum ("*hh") matching the XOR of the characters between the
+// start character and '*'; a sentence without a checksum is returned as not valid.
+// The other lines received before the sentence are discarded.
+func (sp *SerialPort) ReadNMEA(timeout time.Duration) (sentence string, valid bool, err error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		eol := sp.currentEOL()
+		data, err := sp.readUntil(eol, time.Until(deadline))
+		if err != nil {
+			return "", false, err
+		}
+		line := trimEOL(data, eol)
+		if strings.HasPrefix(line, "$") || strings.HasPrefix(line, "!") {
+			return line, nmeaChecksumValid(line), nil
+		}
+	}
+}
+
+// nmeaChecksumValid reports whether sentence ends with a valid "*hh" checksum.
+func nmeaChecksumValid(sentence string) bool {
+	i := strings.LastIndexByte(sentence, '*')
+	if i < 1 || len(sentence)-i != 3 {
+		return false
+	}
+	want, err := strconv.ParseUint(sentence[i+1:], 16, 8)
+	if err != nil {
+		return false
+	}
+	var sum byte
+	for _, c := range []byte(sentence[1:i]) {
+		sum ^= c
+	}
+	return sum == byte(want)
+}
@@ -0,0 +1,36 @@
+package serial
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReadNMEA(t *testing.T) {
+	sp, f := openFake(t)
+	defer sp.Close()
+	f.dev.Write([]byte("garbage\r\n" +
+		"$GPGGA,123519,4807.038,N,01131.000,E,1,08,0.9,545.4,M,46.9,M,,*47\r\n" +
+		"$GPGGA,123519,4807.038,N,01131.000,E,1,08,0.9,545.4,M,46.9,M,,*48\r\n" +
+		"!AIVDM,1,1,,A,13aEOK?P00PD2wVMdLDRhgvL289?,0*26\r\n" +
+		"$GPGSA,A,3\r\n"))
+	for _, want := range []struct {
+		prefix string
+		valid  bool
+	}{
+		{"$GPGGA", true},
+		{"$GPGGA", false},
+		{"!AIVDM", true},
+		{"$GPGSA", false},
+	} {
+		sentence, valid, err := sp.ReadNMEA(time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sentence[:6] != want.prefix || valid != want.valid {
+			t.Fatalf("Expected a %s sentence valid=%v, got %q valid=%v", want.prefix, want.valid, sentence, valid)
+		}
+	}
+	if _, _, err := sp.ReadNMEA(20 * time.Millisecond); err == nil {
+		t.Fatal("Expected a timeout")
+	}
+}
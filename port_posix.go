@@ -0,0 +1,72 @@
+// +build linux,!cgo !windows,cgo
+
+package serial
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+type Port struct {
+	// We intentionly do not use an "embedded" struct so that we
+	// don't export File
+	f         *os.File
+	fd        uintptr       // descriptor of f, never put back in blocking mode
+	timeout   time.Duration // VTIME of the reads, 0 to wait for data forever
+	unblocked atomic.Bool   // set by unblock, the reads return at once
+}
+
+// newPort returns the Port of the tty f, opened with O_NONBLOCK. f is left non-blocking,
+// its reads waiting in the runtime poller rather than in the driver: Close and unblock
+// interrupt a pending read, which a blocking read of a tty ignores. os.File.Fd would put
+// f back in blocking mode, the descriptor is taken through SyscallConn instead.
+//
+// The reads emulate the VMIN and VTIME settings of readTimeout (see posixTimeoutValues):
+// they wait for a byte, for up to VTIME when readTimeout is positive, then return 0 bytes
+// and io.EOF as a tty does.
+func newPort(f *os.File, readTimeout time.Duration) (*Port, error) {
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	p := &Port{f: f}
+	if err := rc.Control(func(fd uintptr) { p.fd = fd }); err != nil {
+		return nil, err
+	}
+	p.emulateTimeouts(posixTimeoutValues(readTimeout))
+	return p, nil
+}
+
+// emulateTimeouts makes the reads wait as with the VMIN and VTIME values vmin and vtime,
+// vmin 0 being the only one to differ from 1.
+func (p *Port) emulateTimeouts(vmin, vtime uint8) {
+	p.timeout = 0
+	if vmin == 0 {
+		p.timeout = time.Duration(vtime) * 100 * time.Millisecond
+	}
+}
+
+func (p *Port) Read(b []byte) (n int, err error) {
+	if p.timeout > 0 && !p.unblocked.Load() {
+		p.f.SetReadDeadline(time.Now().Add(p.timeout))
+	}
+	n, err = p.f.Read(b)
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		// Expired VTIME
+		return n, io.EOF
+	}
+	return n, err
+}
+
+// Makes a pending read return, and the next ones at once
+func (p *Port) unblock() error {
+	p.unblocked.Store(true)
+	if err := p.f.SetReadDeadline(time.Now()); err != nil {
+		// Not handled by the poller, the reads block in the driver
+		return p.unblockTermios()
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package serial
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// PortInfo describes a serial port found by ListPorts.
+type PortInfo struct {
+	Name     string // device name to give to Open, e.g. "/dev/ttyUSB0" or "COM3"
+	VID, PID uint16 // USB vendor and product IDs, 0 when unknown or not a USB adapter
+	Product  string // USB product string, empty when unknown
+}
+
+// ListPorts returns the serial ports of the system, sorted by name: the ttyUSB, ttyACM
+// and ttyS devices of /dev on Linux, the /dev/cu devices on macOS and the BSDs, and the
+// ports registered in HARDWARE\DEVICEMAP\SERIALCOMM on Windows.
+//
+// The USB IDs and product string are filled in where the platform tells them: from sysfs
+// on Linux, from the USB device registry keys on Windows. Use them to find a specific
+// adapter, e.g. the VID 0x2341 of an Arduino.
+func ListPorts() ([]PortInfo, error) {
+	ports, err := listPorts()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(ports, func(i, j int) bool { return ports[i].Name < ports[j].Name })
+	return ports, nil
+}
+
+// OpenMostRecent opens the serial port that appeared last, e.g. the board just plugged in,
+// with the settings of c whose Name is ignored, and returns it: sp.Name tells which port
+// was picked. The ports are those of ListPorts, dated by the creation of their device
+// node (its change time on Linux, its modification time on macOS and the BSDs).
+//
+// Windows doesn't date the ports: the single port of the system is opened, several ports
+// being an error. No port at all is an error too.
+func OpenMostRecent(c Config) (*SerialPort, error) {
+	return openMostRecent(c, ListPorts, portAppeared)
+}
+
+// openMostRecent is OpenMostRecent with the ports listed by list and dated by appeared.
+func openMostRecent(c Config, list func() ([]PortInfo, error), appeared func(name string) (time.Time, error)) (*SerialPort, error) {
+	ports, err := list()
+	if err != nil {
+		return nil, err
+	}
+	name, err := mostRecentPort(ports, appeared)
+	if err != nil {
+		return nil, err
+	}
+	c.Name = name
+	return OpenConfig(c)
+}
+
+// mostRecentPort returns the name of the port of ports that appeared last, according to
+// appeared. Ports that can't be dated are skipped, unless there is only one port.
+func mostRecentPort(ports []PortInfo, appeared func(name string) (time.Time, error)) (string, error) {
+	if len(ports) == 0 {
+		return "", fmt.Errorf("No serial port found")
+	}
+	if len(ports) == 1 {
+		return ports[0].Name, nil
+	}
+	var name string
+	var last time.Time
+	for _, p := range ports {
+		t, err := appeared(p.Name)
+		if err != nil || t.IsZero() {
+			continue
+		}
+		if name == "" || t.After(last) {
+			name, last = p.Name, t
+		}
+	}
+	if name == "" {
+		return "", fmt.Errorf("Unable to tell which of the %v serial ports appeared last", len(ports))
+	}
+	return name, nil
+}
+
+// globPorts returns the files of dir matching any of the patterns.
+func globPorts(dir string, patterns ...string) ([]string, error) {
+	var names []string
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, matches...)
+	}
+	return names, nil
+}
@@ -0,0 +1,31 @@
+// +build !linux,!windows
+
+package serial
+
+import (
+	"os"
+	"time"
+)
+
+// listPorts returns the callout devices of /dev, see ListPorts: cu.* on macOS, cuaU* and
+// cuau* on the BSDs. The USB IDs aren't looked up on these platforms.
+func listPorts() ([]PortInfo, error) {
+	names, err := globPorts("/dev", "cu.*", "cuaU*", "cuau*")
+	if err != nil {
+		return nil, err
+	}
+	ports := make([]PortInfo, 0, len(names))
+	for _, name := range names {
+		ports = append(ports, PortInfo{Name: name})
+	}
+	return ports, nil
+}
+
+// portAppeared returns when the device node name was created, its modification time.
+func portAppeared(name string) (time.Time, error) {
+	fi, err := os.Stat(name)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return fi.ModTime(), nil
+}
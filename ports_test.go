@@ -0,0 +1,84 @@
+package serial
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMostRecentPortDates(t *testing.T) {
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	// Dates of the device nodes, the missing ones can't be dated
+	appeared := map[string]time.Time{
+		"/dev/ttyS0":   base,
+		"/dev/ttyUSB0": base.Add(time.Minute),
+		"/dev/ttyUSB1": base.Add(time.Hour),
+		"/dev/ttyACM0": {},
+	}
+	lookup := func(name string) (time.Time, error) {
+		at, ok := appeared[name]
+		if !ok {
+			return time.Time{}, errors.New("no such file")
+		}
+		return at, nil
+	}
+	tests := []struct {
+		ports []string
+		want  string // empty for an error
+	}{
+		{nil, ""},
+		{[]string{"/dev/ttyUSB0"}, "/dev/ttyUSB0"},
+		{[]string{"/dev/gone"}, "/dev/gone"}, // a single port needs no date
+		{[]string{"/dev/ttyS0", "/dev/ttyUSB1", "/dev/ttyUSB0"}, "/dev/ttyUSB1"},
+		{[]string{"/dev/ttyUSB0", "/dev/ttyS0"}, "/dev/ttyUSB0"},
+		{[]string{"/dev/gone", "/dev/ttyACM0", "/dev/ttyS0"}, "/dev/ttyS0"},
+		{[]string{"/dev/gone", "/dev/ttyACM0"}, ""},
+	}
+	for _, test := range tests {
+		ports := make([]PortInfo, len(test.ports))
+		for i, name := range test.ports {
+			ports[i] = PortInfo{Name: name}
+		}
+		name, err := mostRecentPort(ports, lookup)
+		if test.want == "" {
+			if err == nil {
+				t.Errorf("%v: expected an error, got %q", test.ports, name)
+			}
+		} else if err != nil || name != test.want {
+			t.Errorf("%v: expected %q, got %q (%v)", test.ports, test.want, name, err)
+		}
+	}
+}
+
+func TestOpenMostRecent(t *testing.T) {
+	dir := t.TempDir()
+	older, newer := filepath.Join(dir, "older"), filepath.Join(dir, "newer")
+	list := func() ([]PortInfo, error) {
+		return []PortInfo{{Name: newer}, {Name: older}}, nil
+	}
+	appeared := func(name string) (time.Time, error) {
+		if name == newer {
+			return time.Now(), nil
+		}
+		return time.Now().Add(-time.Hour), nil
+	}
+	// The name of c is replaced by the port picked, which doesn't exist
+	_, err := openMostRecent(Config{Name: "ignored", Baud: 9600}, list, appeared)
+	if err == nil || !strings.Contains(err.Error(), newer) {
+		t.Fatalf("Expected the open of %v to fail, got %v", newer, err)
+	}
+	// The settings of c are validated as by OpenConfig
+	if _, err := openMostRecent(Config{}, list, appeared); err == nil || !strings.Contains(err.Error(), "baud rate") {
+		t.Fatalf("Expected a missing baud rate to be rejected, got %v", err)
+	}
+	failing := func() ([]PortInfo, error) { return nil, errors.New("no /dev") }
+	if _, err := openMostRecent(Config{Baud: 9600}, failing, appeared); err == nil || err.Error() != "no /dev" {
+		t.Fatalf("Expected the listing error, got %v", err)
+	}
+	empty := func() ([]PortInfo, error) { return nil, nil }
+	if _, err := openMostRecent(Config{Baud: 9600}, empty, appeared); err == nil {
+		t.Fatal("Expected an error without ports")
+	}
+}
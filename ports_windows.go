@@ -0,0 +1,161 @@
+// +build windows
+
+package serial
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+var nRegEnumValue uintptr
+
+const errorNoMoreItems syscall.Errno = 259 // ERROR_NO_MORE_ITEMS
+
+func init() {
+	adv, err := syscall.LoadLibrary("advapi32.dll")
+	if err != nil {
+		panic("LoadLibrary " + err.Error())
+	}
+	defer syscall.FreeLibrary(adv)
+
+	nRegEnumValue = getProcAddr(adv, "RegEnumValueW")
+}
+
+// listPorts returns the ports registered in HARDWARE\DEVICEMAP\SERIALCOMM, see ListPorts,
+// with the USB IDs and product string of the adapters found by usbPortInfos.
+func listPorts() ([]PortInfo, error) {
+	k, err := openRegKey(syscall.HKEY_LOCAL_MACHINE, `HARDWARE\DEVICEMAP\SERIALCOMM`)
+	if err != nil {
+		if err == syscall.ERROR_FILE_NOT_FOUND {
+			// No serial port at all
+			return []PortInfo{}, nil
+		}
+		return nil, fmt.Errorf("Unable to list serial ports - %s", err)
+	}
+	defer syscall.RegCloseKey(k)
+
+	usb := usbPortInfos()
+	ports := []PortInfo{}
+	for i := uint32(0); ; i++ {
+		var name [256]uint16
+		var data [256]uint16
+		nameLen := uint32(len(name))
+		dataLen := uint32(len(data) * 2)
+		var typ uint32
+		r, _, _ := syscall.Syscall9(nRegEnumValue, 8, uintptr(k), uintptr(i),
+			uintptr(unsafe.Pointer(&name[0])), uintptr(unsafe.Pointer(&nameLen)), 0,
+			uintptr(unsafe.Pointer(&typ)), uintptr(unsafe.Pointer(&data[0])), uintptr(unsafe.Pointer(&dataLen)), 0)
+		if syscall.Errno(r) == errorNoMoreItems {
+			break
+		}
+		if r != 0 {
+			return nil, fmt.Errorf("Unable to list serial ports - %s", syscall.Errno(r))
+		}
+		if typ != syscall.REG_SZ {
+			continue
+		}
+		port := syscall.UTF16ToString(data[:])
+		info, ok := usb[port]
+		if !ok {
+			info = PortInfo{Name: port}
+		}
+		ports = append(ports, info)
+	}
+	return ports, nil
+}
+
+// portAppeared returns the zero time: the registry doesn't tell when the ports appeared.
+func portAppeared(name string) (time.Time, error) {
+	return time.Time{}, nil
+}
+
+// usbPortInfos returns the ports of the USB serial adapters known to the system, by name,
+// from the keys SYSTEM\CurrentControlSet\Enum\USB\VID_xxxx&PID_xxxx\<instance>, whose
+// PortName value is under "Device Parameters". Adapters exposed by their own bus driver
+// (e.g. FTDIBUS) are not found. It is best effort: errors make entries missing.
+func usbPortInfos() map[string]PortInfo {
+	infos := make(map[string]PortInfo)
+	root, err := openRegKey(syscall.HKEY_LOCAL_MACHINE, `SYSTEM\CurrentControlSet\Enum\USB`)
+	if err != nil {
+		return infos
+	}
+	defer syscall.RegCloseKey(root)
+	for _, device := range regSubKeys(root) {
+		vid, pid, ok := parseUSBDeviceID(device)
+		if !ok {
+			continue
+		}
+		dev, err := openRegKey(root, device)
+		if err != nil {
+			continue
+		}
+		for _, instance := range regSubKeys(dev) {
+			params, err := openRegKey(dev, instance+`\Device Parameters`)
+			if err != nil {
+				continue
+			}
+			name := regString(params, "PortName")
+			syscall.RegCloseKey(params)
+			if name == "" {
+				continue
+			}
+			info := PortInfo{Name: name, VID: vid, PID: pid}
+			if inst, err := openRegKey(dev, instance); err == nil {
+				info.Product = regString(inst, "FriendlyName")
+				syscall.RegCloseKey(inst)
+			}
+			infos[name] = info
+		}
+		syscall.RegCloseKey(dev)
+	}
+	return infos
+}
+
+// parseUSBDeviceID parses the IDs of a device key name like "VID_2341&PID_0043".
+func parseUSBDeviceID(s string) (vid uint16, pid uint16, ok bool) {
+	parts := strings.Split(strings.ToUpper(s), "&")
+	if len(parts) < 2 || !strings.HasPrefix(parts[0], "VID_") || !strings.HasPrefix(parts[1], "PID_") {
+		return 0, 0, false
+	}
+	v, verr := strconv.ParseUint(parts[0][4:], 16, 16)
+	p, perr := strconv.ParseUint(parts[1][4:], 16, 16)
+	if verr != nil || perr != nil {
+		return 0, 0, false
+	}
+	return uint16(v), uint16(p), true
+}
+
+func openRegKey(parent syscall.Handle, path string) (syscall.Handle, error) {
+	var k syscall.Handle
+	err := syscall.RegOpenKeyEx(parent, syscall.StringToUTF16Ptr(path), 0, syscall.KEY_READ, &k)
+	return k, err
+}
+
+// regSubKeys returns the names of the subkeys of k.
+func regSubKeys(k syscall.Handle) []string {
+	var names []string
+	for i := uint32(0); ; i++ {
+		var name [256]uint16
+		n := uint32(len(name))
+		if err := syscall.RegEnumKeyEx(k, i, &name[0], &n, nil, nil, nil, nil); err != nil {
+			return names
+		}
+		names = append(names, syscall.UTF16ToString(name[:n]))
+	}
+}
+
+// regString returns the string value name of k, empty if it isn't there.
+func regString(k syscall.Handle, name string) string {
+	var buf [256]uint16
+	n := uint32(len(buf) * 2)
+	var typ uint32
+	err := syscall.RegQueryValueEx(k, syscall.StringToUTF16Ptr(name), nil, &typ, (*byte)(unsafe.Pointer(&buf[0])), &n)
+	if err != nil || typ != syscall.REG_SZ {
+		return ""
+	}
+	return syscall.UTF16ToString(buf[:])
+}
@@ -0,0 +1,304 @@
+package serial
+
+import (
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+// openPty opens a pseudo terminal as posix_openpt does, returning its master side and
+// the name of its slave tty.
+func openPty(t *testing.T) (*os.File, string) {
+	t.Helper()
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		t.Skipf("No pseudo terminal: %v", err)
+	}
+	t.Cleanup(func() { master.Close() })
+	rc, err := master.SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n uint32
+	var errno syscall.Errno
+	rc.Control(func(fd uintptr) {
+		unlock := int32(0)
+		if _, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); errno == 0 {
+			_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, syscall.TIOCGPTN, uintptr(unsafe.Pointer(&n)))
+		}
+	})
+	if errno != 0 {
+		t.Fatal(errno)
+	}
+	return master, "/dev/pts/" + strconv.Itoa(int(n))
+}
+
+// closeWithin fails the test if sp.Close doesn't return within d.
+func closeWithin(t *testing.T, sp *SerialPort, d time.Duration) {
+	t.Helper()
+	closed := make(chan error, 1)
+	go func() { closed <- sp.Close() }()
+	select {
+	case <-closed:
+	case <-time.After(d):
+		t.Fatal("Close blocked by the pending read of the tty")
+	}
+}
+
+func TestPtyCloseWhileReading(t *testing.T) {
+	for _, watchdog := range []time.Duration{0, time.Second} {
+		master, name := openPty(t)
+		sp := New()
+		sp.SetReadWatchdog(watchdog)
+		if err := sp.Open(name, 9600); err != nil {
+			t.Fatal(err)
+		}
+		master.Write([]byte("OK\n"))
+		if line, err := sp.ReadLineTimeout(time.Second); err != nil || line != "OK" {
+			t.Fatalf("Expected OK, got %q (%v)", line, err)
+		}
+		// The reader thread now waits in a blocking read of the silent line
+		time.Sleep(50 * time.Millisecond)
+		closeWithin(t, sp, 2*time.Second)
+		if n := sp.LiveThreads(); n != 0 {
+			t.Fatalf("Expected the threads to exit with Close, %v left", n)
+		}
+	}
+}
+
+func TestPtyOpenCloseNoLeak(t *testing.T) {
+	_, name := openPty(t)
+	sp := New()
+	before := runtime.NumGoroutine()
+	for i := 0; i < 10; i++ {
+		if err := sp.Open(name, 9600); err != nil {
+			t.Fatal(err)
+		}
+		closeWithin(t, sp, 2*time.Second)
+		if n := sp.LiveThreads(); n != 0 {
+			t.Fatalf("Expected no thread left once closed, got %v", n)
+		}
+	}
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("Leaked goroutines: %v (%v before)", runtime.NumGoroutine(), before)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestPtyReadTimeout(t *testing.T) {
+	master, name := openPty(t)
+	p, err := OpenPort(&Config{Name: name, Baud: 9600, ReadTimeout: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	// A silent line ends the read after VTIME, as a blocking tty does
+	start := time.Now()
+	b := make([]byte, 16)
+	if n, _ := p.Read(b); n != 0 || time.Since(start) < 90*time.Millisecond {
+		t.Fatalf("Expected the read to time out empty, got %v bytes after %v", n, time.Since(start))
+	}
+	master.Write([]byte("data"))
+	if n, err := p.Read(b); err != nil || string(b[:n]) != "data" {
+		t.Fatalf("Expected the data, got %q (%v)", b[:n], err)
+	}
+}
+
+func TestPtySetBaud(t *testing.T) {
+	_, name := openPty(t)
+	sp := New()
+	if err := sp.Open(name, 9600); err != nil {
+		t.Fatal(err)
+	}
+	defer sp.Close()
+	for _, baud := range []int{921600, 4000000, 300, 115200} {
+		if err := sp.SetBaud(baud); err != nil || sp.Baud() != baud {
+			t.Fatalf("Expected %v baud, got %v (%v)", baud, sp.Baud(), err)
+		}
+	}
+	if err := sp.SetBaudPreserving(230400); err != nil || sp.Baud() != 230400 {
+		t.Fatalf("Expected 230400 baud, got %v (%v)", sp.Baud(), err)
+	}
+	if err := sp.SetBaud(123456); err == nil {
+		t.Fatal("Expected an unknown baud rate to be rejected")
+	}
+}
+
+func TestPtySetMode(t *testing.T) {
+	_, name := openPty(t)
+	sp := New()
+	if err := sp.Open(name, 9600); err != nil {
+		t.Fatal(err)
+	}
+	defer sp.Close()
+	// A pty keeps CS8 without PARENB, the other framing flags are applied
+	tests := []struct {
+		mode  string
+		flags []string
+	}{
+		{"115200,8N1", []string{"CS8"}},
+		{"9600,7E2", []string{"CSTOPB", "INPCK"}},
+		{"4800,5O1.5", []string{"CSTOPB", "PARODD", "INPCK"}},
+		{"921600,8N1", []string{"CS8"}},
+	}
+	for _, test := range tests {
+		if err := sp.SetMode(test.mode); err != nil {
+			t.Fatalf("SetMode(%q): %v", test.mode, err)
+		}
+		if m := sp.Mode().String(); m != test.mode {
+			t.Fatalf("Expected mode %q, got %q", test.mode, m)
+		}
+		dump, err := sp.DebugTermios()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, flag := range test.flags {
+			if !strings.Contains(dump, flag) {
+				t.Fatalf("Expected %s applied by SetMode(%q), got:\n%s", flag, test.mode, dump)
+			}
+		}
+	}
+
+	// A baud rate the tty doesn't know leaves the framing unchanged
+	before, _ := sp.DebugTermios()
+	if err := sp.SetMode("123456,7E1"); err == nil {
+		t.Fatal("Expected an unknown baud rate to be rejected")
+	}
+	if after, _ := sp.DebugTermios(); after != before || sp.Mode().String() != "921600,8N1" {
+		t.Fatalf("Expected the configuration unchanged, got %v:\n%s", sp.Mode(), after)
+	}
+}
+
+func TestPtyReadTimeoutValues(t *testing.T) {
+	_, name := openPty(t)
+	sp := New()
+	if err := sp.Open(name, 9600, 1250*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	defer sp.Close()
+	sp.SetReadTimeout(0)
+	if vmin, vtime := sp.ReadTimeoutValues(); vmin != 0 || vtime != 12 {
+		t.Fatalf("Expected VMIN 0 VTIME 12 as programmed on open, got %v %v", vmin, vtime)
+	}
+	if dump, err := sp.DebugTermios(); err != nil || !strings.Contains(dump, "VMIN: 0 VTIME: 12") {
+		t.Fatalf("Expected the tty to have VMIN 0 VTIME 12, got %v:\n%s", err, dump)
+	}
+}
+
+func TestPtyDebugTermios(t *testing.T) {
+	_, name := openPty(t)
+	sp := New()
+	if _, err := sp.DebugTermios(); err != errNotOpen {
+		t.Fatalf("Expected errNotOpen before Open, got %v", err)
+	}
+	if err := sp.Open(name, 19200, 500*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	defer sp.Close()
+	dump, err := sp.DebugTermios()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"c_iflag: ", "c_oflag: ", "CREAD", "CLOCAL", "CS8", "ispeed: 19200 ospeed: 19200", "VMIN: 0 VTIME: 5", "modem: "} {
+		if !strings.Contains(dump, want) {
+			t.Errorf("Expected %q in the dump, got:\n%s", want, dump)
+		}
+	}
+	// Raw mode, as configured by Open
+	for _, line := range strings.Split(dump, "\n") {
+		if strings.HasPrefix(line, "c_lflag: ") && strings.Contains(line, "ICANON") {
+			t.Errorf("Expected the tty in raw mode, got %q", line)
+		}
+	}
+	// Only reading the settings, the dump doesn't change them
+	if again, _ := sp.DebugTermios(); again != dump {
+		t.Fatalf("Expected the same dump, got:\n%s\nthen:\n%s", dump, again)
+	}
+}
+
+func TestPtyNoConfigure(t *testing.T) {
+	_, name := openPty(t)
+	primary := New()
+	if err := primary.Open(name, 19200, 500*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	defer primary.Close()
+	before, err := primary.DebugTermios()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Settings Open would apply otherwise
+	sniffer := New()
+	sniffer.NoConfigure(true)
+	sniffer.ClearOnOpen(true)
+	sniffer.SetFlowControl(FlowXONXOFF)
+	if err := sniffer.Open(name, 9600); err != nil {
+		t.Fatal(err)
+	}
+	if after, _ := primary.DebugTermios(); after != before {
+		t.Fatalf("Expected the termios untouched by the open, got:\n%s\ninstead of:\n%s", after, before)
+	}
+	if vmin, vtime := sniffer.ReadTimeoutValues(); vmin != 0 || vtime != 5 {
+		t.Fatalf("Expected the VMIN and VTIME of the primary opener, got %v %v", vmin, vtime)
+	}
+	if _, err := sniffer.Write([]byte("x")); err == nil {
+		t.Fatal("Expected the shared port to be read-only")
+	}
+	closeWithin(t, sniffer, 2*time.Second)
+	if after, _ := primary.DebugTermios(); after != before {
+		t.Fatalf("Expected the termios untouched by the close, got:\n%s\ninstead of:\n%s", after, before)
+	}
+}
+
+func TestPtyOpenStty(t *testing.T) {
+	// 8N1 with CREAD and CLOCAL at each speed, VMIN 1 and VTIME 0
+	for baud, speed := range map[int]uint32{1200: 0x9, 115200: 0x1002, 921600: 0x1007} {
+		_, name := openPty(t)
+		sp := New()
+		settings := "0:0:" + strconv.FormatUint(uint64(0x8b0|speed), 16) + ":0:3:1c:7f:15:4:0:1:0"
+		if err := sp.OpenStty(name, settings); err != nil {
+			t.Fatalf("%v: %v", baud, err)
+		}
+		if sp.Baud() != baud {
+			t.Fatalf("Expected %v, got %v", baud, sp.Baud())
+		}
+		closeWithin(t, sp, time.Second)
+	}
+}
+
+func TestPtyOpenSttyTimeouts(t *testing.T) {
+	// 9600 8N1, with VMIN and VTIME at indexes 6 and 5 of the control characters
+	const stty = "0:0:8bd:0:3:1c:7f:15:4:"
+	_, name := openPty(t)
+	sp := New()
+	if err := sp.OpenStty(name, stty+"0:0:0"); err == nil || !strings.Contains(err.Error(), "VMIN and VTIME 0") {
+		t.Fatalf("Expected VMIN 0 and VTIME 0 rejected, got %v", err)
+	}
+
+	// The silent line isn't taken as hung up, with VMIN 0 the reads wait for VTIME
+	for _, cc := range []string{"0:1:0", "2:0:0"} {
+		master, name := openPty(t)
+		sp := New()
+		if err := sp.OpenStty(name, stty+cc); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(300 * time.Millisecond)
+		if err := sp.LastError(); err != nil {
+			t.Fatalf("%v: expected no hangup on the silent line, got %v", cc, err)
+		}
+		master.Write([]byte("OK\n"))
+		if line, err := sp.ReadLineTimeout(time.Second); err != nil || line != "OK" {
+			t.Fatalf("%v: expected OK, got %q (%v)", cc, line, err)
+		}
+		closeWithin(t, sp, time.Second)
+	}
+}
@@ -1,17 +1,96 @@
 package serial
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash"
+	"hash/crc32"
 	"io"
 	"io/ioutil"
+	"math"
+	"net/textproto"
+	"os"
 	"regexp"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
 // End of line character (AKA EOL), newline character (ASCII 10, CR, '\n'). is used by default.
 const EOL_DEFAULT byte = '\n'
 
+var errNotOpen = errors.New("Serial port is not open")
+
+// ErrPortDisconnected is returned by writes and reads once the device has gone away
+// (e.g. a USB adapter was unplugged). Data received before is still readable.
+var ErrPortDisconnected = errors.New("Serial port disconnected")
+
+// ErrBreak is reported on the Errors channel for every BREAK received in BreakEvent mode.
+var ErrBreak = errors.New("Break received")
+
+// ErrLoopbackUnsupported is returned by SetHardwareLoopback when the driver or the
+// platform has no internal loopback.
+var ErrLoopbackUnsupported = errors.New("Hardware loopback not supported")
+
+// ErrSerialInfoUnsupported is returned by SerialInfo when the driver or the platform
+// doesn't provide the serial configuration.
+var ErrSerialInfoUnsupported = errors.New("Serial info not supported")
+
+// ErrReadStuck is reported on the Errors channel when the read watchdog closes a port
+// whose read did not return in time, see SetReadWatchdog.
+var ErrReadStuck = errors.New("Serial port read stuck")
+
+// ErrCloseAbandoned is returned by Close when the driver is still stuck closing the port
+// after the read watchdog forced it, see SetReadWatchdog. The port is released anyway.
+var ErrCloseAbandoned = errors.New("Serial port close abandoned")
+
+// BreakHandling selects what happens when a BREAK condition is received.
+type BreakHandling int
+
+const (
+	// BreakInject delivers a BREAK as a 0x00 byte in the received data (default).
+	BreakInject BreakHandling = iota
+	// BreakIgnore discards BREAK conditions.
+	BreakIgnore
+	// BreakEvent reports a BREAK as ErrBreak on the Errors channel, keeping it out of the
+	// received data. POSIX only, it relies on PARMRK.
+	BreakEvent
+)
+
+// WriteBuffering selects how Write and the Print functions hand data to the port.
+type WriteBuffering int
+
+const (
+	// Unbuffered sends the data to the port immediately (default).
+	Unbuffered WriteBuffering = iota
+	// LineBuffered holds the data until a newline is written, like stdio line buffering
+	// to a terminal. Data still pending is sent by FlushWrites and Close.
+	LineBuffered
+)
+
+// ReadErrorAction is what the reader thread does after a failed read of the port, see
+// SetReadErrorClassifier.
+type ReadErrorAction int
+
+const (
+	// ReadRetry reads again after a short backoff, for transient errors like EINTR or
+	// EAGAIN.
+	ReadRetry ReadErrorAction = iota
+	// ReadStop stops the reader thread: the error is reported on the Errors channel,
+	// followed by ErrPortDisconnected, and the port is considered disconnected (see
+	// LastError) with its device closed.
+	ReadStop
+)
+
+// ErrIncompleteLine is returned by ReadLine, together with the remaining data, when
+// the port has been closed and the buffer ends with a line that has no EOL character.
+var ErrIncompleteLine = errors.New("Incomplete line")
+
 /*******************************************************************************************
 *******************************   TYPE DEFINITIONS 	****************************************
 *******************************************************************************************/
@@ -20,13 +99,83 @@ type SerialPort struct {
 	port          io.ReadWriteCloser
 	name          string
 	baud          int
-	eol           uint8
+	dataBits      int
+	parity        Parity
+	stopBits      StopBits
+	flow          FlowControl
+	xon, xoff     byte // flow control characters of FlowXONXOFF
+	eol           []byte
+	readTimeout   time.Duration
+	vmin, vtime   uint8 // VMIN and VTIME applied when the port was opened, see ReadTimeoutValues
+	clearOnOpen   bool
+	holdDTR       bool          // keep DTR deasserted on open, see DTROnOpen
+	preamble      []byte        // skipped after every open, see SkipPreamble
+	lazyLines     bool          // start the processor thread only with line handlers
+	noConfigure   bool          // open read-only, keeping the settings of the port
+	stty          *sttySettings // termios settings of OpenStty, nil with Open
+	watchdog      time.Duration // read watchdog interval, 0 when disabled
+	readyDSR      bool          // Ready requires DSR, see SetReadyLines
+	readyDCD      bool          // Ready requires DCD
+	breakMode     BreakHandling
 	rxChar        chan byte
+	done          chan struct{} // closed by Close to stop the reader threads
 	closeReqChann chan bool
 	closeAckChann chan error
 	buff          *bytes.Buffer
-	portIsOpen    bool
-	// openPort      func(port string, baud int) (io.ReadWriteCloser, error)
+	buffMu        sync.Mutex    // guards eol, buff, buffLimit, rxSignal, rxCount and lastRx
+	buffLimit     int           // most bytes buffered, 0 for no limit, see SetBufferLimit
+	dropped       atomic.Uint64 // bytes discarded by buffLimit, see DroppedBytes
+	rxSignal      chan struct{} // closed when data is buffered or the port is closed
+	rxCount       uint64        // total of the bytes buffered
+	lastRx        time.Time     // reception time of the last data buffered
+	disconnected  bool
+	lastErr       error       // why the port got disconnected, see LastError
+	portIsOpen    atomic.Bool // read by the threads and concurrent calls, see Close
+	errs          chan error
+	writeMu       sync.Mutex // serializes writes, guards the write buffering below
+	writeMode     WriteBuffering
+	txBuff        []byte
+	chunkSize     int // maximum size of the port writes, 0 for no chunking
+	readBufSize   int // size of the port reads, 0 for IdealReadBufferSize
+	chunkDelay    time.Duration
+	writeTimeout  time.Duration // bound of WriteSlow, 0 for none
+	fileDelay     time.Duration // delay between the chunks of SendFile
+	fileDelayLast bool          // also delay after the last chunk
+	filePacer     SendFilePacer // delay before each chunk, replacing fileDelay if set
+	rtsTurnaround bool          // drive RTS around the writes, see SetRTSTurnaround
+	rtsMargin     time.Duration // RTS hold time after the computed end of transmission
+	txEnd         time.Time     // computed end of the transmission of the data written
+	handlersMu    sync.Mutex    // guards the handlers below
+	lineHandler   func(line string)
+	lineTaps      map[int]func(line string) // see every line, along with lineHandler
+	nextLineTap   int
+	processing    bool              // the processor thread runs, the reader hands it the bytes
+	inputTap      func(data []byte) // sees the received data before it is buffered
+	inTransform   func(data []byte) []byte
+	outTransform  func(data []byte) []byte
+	classifyRead  func(err error) ReadErrorAction
+	onReconnect   func()
+	autoRetry     time.Duration // first delay of the auto-reconnect, 0 when disabled
+	autoMax       time.Duration // longest delay of the auto-reconnect
+	autoStop      chan struct{} // closed by Close to stop the auto-reconnect thread
+	stateHandler  StateHandler
+	writeQueue    *writeQueue // writes of WriteAsync, started by the first one of a session
+	dumper        io.WriteCloser
+	openPort      func(name string, baud int, readTimeout time.Duration) (io.ReadWriteCloser, error)
+	threads       atomic.Int64   // goroutines started and still running, see LiveThreads
+	session       sync.WaitGroup // reader threads of the current session
+	state         PortState      // see SetStateHandler, guarded by handlersMu
+	stateEvents   []stateEvent   // transitions not passed to stateHandler yet
+	delivering    bool           // a thread passes stateEvents to stateHandler
+	lifeMu        sync.Mutex     // serializes Close and the reconnections
+	settingsMu    sync.RWMutex   // guards port, name, baud, stty and the settings read by Settings
+}
+
+// State is a snapshot of the low-level settings of an open port (line settings
+// and modem control lines), as returned by SaveState.
+type State struct {
+	state portState
+	valid bool
 }
 
 /*******************************************************************************************
@@ -36,67 +185,369 @@ type SerialPort struct {
 func New() *SerialPort {
 	// Create new file
 	return &SerialPort{
-		eol:     EOL_DEFAULT,
-		buff:    bytes.NewBuffer(make([]uint8, 256)),
+		eol:       []byte{EOL_DEFAULT},
+		buff:      bytes.NewBuffer(make([]uint8, 0, 256)),
+		errs:      make(chan error, 16),
+		rxSignal:  make(chan struct{}),
+		openPort:  openSystemPort,
+		dataBits:  8,
+		parity:    ParityNone,
+		stopBits:  Stop1,
+		xon:       XONDefault,
+		xoff:      XOFFDefault,
+		fileDelay: 100 * time.Millisecond,
 	}
 }
 
 func (sp *SerialPort) Open(name string, baud int, timeout ...time.Duration) error {
 	// Check if port is open
-	if sp.portIsOpen {
+	if sp.portIsOpen.Load() {
 		return fmt.Errorf("\"%s\" is already open", name)
 	}
 	var readTimeout time.Duration
 	if len(timeout) > 0 {
 		readTimeout = timeout[0]
 	}
-	// Open serial port
-	comPort, err := openPort(name, baud, readTimeout)
+	comPort, err := sp.openConfigured(name, baud, readTimeout)
 	if err != nil {
-		return fmt.Errorf("Unable to open port \"%s\" - %s", name, err)
+		sp.setState(StateClosed, err)
+		return err
 	}
 	// Open port succesfull
-	sp.name = name
-	sp.baud = baud
-	sp.port = comPort
-	sp.portIsOpen = true
+	sp.settingsMu.Lock()
+	sp.stty = nil
+	sp.readTimeout = readTimeout
+	sp.settingsMu.Unlock()
+	sp.buffMu.Lock()
 	sp.buff.Reset()
-	// Open channels
-	sp.rxChar = make(chan byte)
-	// Enable threads
-	go sp.readSerialPort()
-	go sp.processSerialPort()
+	sp.buffMu.Unlock()
+	sp.dropped.Store(0)
+	sp.start(name, baud, comPort)
+	if err := sp.skipPreamble(0); err != nil {
+		sp.Close()
+		return err
+	}
+	return nil
+}
+
+// skipPreamble waits for the preamble set by SkipPreamble at offset from of the buffer,
+// following the data buffered before the port was opened, and removes it.
+func (sp *SerialPort) skipPreamble(from int) error {
+	sp.settingsMu.RLock()
+	preamble := sp.preamble
+	sp.settingsMu.RUnlock()
+	if len(preamble) == 0 {
+		return nil
+	}
+	matched := true
+	err := sp.waitBuffer(sp.streamTimeout(), func(buff *bytes.Buffer) bool {
+		data := buff.Bytes()[from:]
+		if len(data) > len(preamble) {
+			data = data[:len(preamble)]
+		}
+		if !bytes.HasPrefix(preamble, data) {
+			matched = false
+			return true
+		}
+		if len(data) < len(preamble) {
+			return false
+		}
+		all := buff.Bytes()
+		copy(all[from:], all[from+len(preamble):])
+		buff.Truncate(len(all) - len(preamble))
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("Preamble not received on \"%s\" - %s", sp.Name(), err)
+	}
+	if !matched {
+		return fmt.Errorf("Unexpected data instead of the preamble on \"%s\"", sp.Name())
+	}
+	return nil
+}
+
+// openConfigured opens the named port and applies the settings of sp to it.
+func (sp *SerialPort) openConfigured(name string, baud int, readTimeout time.Duration) (io.ReadWriteCloser, error) {
+	sp.settingsMu.RLock()
+	noConfigure, holdDTR, clearOnOpen := sp.noConfigure, sp.holdDTR, sp.clearOnOpen
+	sp.settingsMu.RUnlock()
+	open := sp.openPort
+	if noConfigure {
+		open = openSharedPort
+	}
+	comPort, err := open(name, baud, readTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open port \"%s\" - %s", name, err)
+	}
+	if holdDTR && !noConfigure {
+		if err = sp.applyHoldDTR(comPort); err != nil {
+			comPort.Close()
+			return nil, fmt.Errorf("Unable to open port \"%s\" - %s", name, err)
+		}
+	}
+	m, flow := sp.Mode(), sp.FlowControl()
+	sp.settingsMu.RLock()
+	xon, xoff := sp.xon, sp.xoff
+	sp.settingsMu.RUnlock()
+	framed := m.DataBits != 8 || m.Parity != ParityNone || m.StopBits != Stop1
+	if p, ok := comPort.(*Port); ok && framed && !noConfigure {
+		if err = p.setFraming(m.DataBits, m.Parity, m.StopBits); err != nil {
+			comPort.Close()
+			return nil, fmt.Errorf("Unable to open port \"%s\" - %s", name, err)
+		}
+	}
+	if p, ok := comPort.(*Port); ok && flow != FlowNone && !noConfigure {
+		if err = p.setFlowControl(flow, xon, xoff); err != nil {
+			comPort.Close()
+			return nil, fmt.Errorf("Unable to open port \"%s\" - %s", name, err)
+		}
+	}
+	sp.handlersMu.Lock()
+	breakMode := sp.breakMode
+	sp.handlersMu.Unlock()
+	if p, ok := comPort.(*Port); ok && breakMode != BreakInject && !noConfigure {
+		if err = p.setBreakHandling(breakMode); err != nil {
+			comPort.Close()
+			return nil, fmt.Errorf("Unable to open port \"%s\" - %s", name, err)
+		}
+	}
+	if f, ok := comPort.(interface{ Flush() error }); ok && clearOnOpen && !noConfigure {
+		if err = f.Flush(); err != nil {
+			comPort.Close()
+			return nil, fmt.Errorf("Unable to clear port \"%s\" - %s", name, err)
+		}
+	}
+	return comPort, nil
+}
+
+// applyHoldDTR deasserts DTR on comPort, just opened, for DTROnOpen.
+func (sp *SerialPort) applyHoldDTR(comPort io.ReadWriteCloser) error {
+	p, ok := comPort.(interface{ holdDTR() error })
+	if !ok {
+		return fmt.Errorf("DTR control not supported")
+	}
+	return p.holdDTR()
+}
+
+// Reconnect closes the port, if still open, and opens it again with the settings it had,
+// e.g. after the device was disconnected: name, baud rate, framing, read timeout, break
+// handling and the other settings of sp are applied again. The line handlers, taps and
+// EOL stay in place, and the data buffered is kept. Once the port is ready, the handler
+// registered with OnReconnect is called. See SetAutoReconnect to reconnect automatically.
+func (sp *SerialPort) Reconnect() error {
+	if sp.Name() == "" {
+		return fmt.Errorf("No port to reconnect")
+	}
+	sp.lifeMu.Lock()
+	if sp.portIsOpen.Load() {
+		// The device may be gone already, the port is replaced anyway
+		sp.close(StateClosed)
+	}
+	sp.setState(StateReconnecting, nil)
+	comPort, err := sp.reopen()
+	if err != nil {
+		sp.setState(StateClosed, err)
+		sp.lifeMu.Unlock()
+		return err
+	}
+	err = sp.resume(comPort)
+	sp.lifeMu.Unlock()
+	if err != nil {
+		return err
+	}
+	sp.reconnected()
+	return nil
+}
+
+// reopen opens the port again with the settings of sp.
+func (sp *SerialPort) reopen() (io.ReadWriteCloser, error) {
+	sp.settingsMu.RLock()
+	stty := sp.stty
+	sp.settingsMu.RUnlock()
+	if stty != nil {
+		comPort, _, err := sp.openStty(sp.Name(), *stty)
+		return comPort, err
+	}
+	return sp.openConfigured(sp.Name(), sp.Baud(), sp.ReadTimeout())
+}
+
+// resume starts a session on comPort, opened again, keeping the data buffered.
+func (sp *SerialPort) resume(comPort io.ReadWriteCloser) error {
+	sp.buffMu.Lock()
+	buffered := sp.buff.Len()
+	sp.buffMu.Unlock()
+	sp.start(sp.Name(), sp.Baud(), comPort)
+	if err := sp.skipPreamble(buffered); err != nil {
+		sp.close(StateClosed)
+		return err
+	}
+	return nil
+}
+
+// reconnected calls the handler registered with OnReconnect.
+func (sp *SerialPort) reconnected() {
+	sp.handlersMu.Lock()
+	handler := sp.onReconnect
+	sp.handlersMu.Unlock()
+	if handler != nil {
+		handler()
+	}
+}
+
+// SetAutoReconnect makes the port reconnect by itself once its device is disconnected,
+// e.g. a USB adapter re-enumerating: the port is opened again with its settings, as by
+// Reconnect, retrying after retry, then twice as long after every failure up to
+// maxRetry (retry if shorter). A retry of 0 disables it, the default.
+//
+// Until the device is back, the port stays open and disconnected: the writes fail with
+// ErrPortDisconnected, and the reads return the data buffered. The handler registered
+// with OnReconnect is called after each reconnection, the state handler sees the port
+// go through StateReconnecting, with the errors of the failed attempts, and the failures
+// are reported on the Errors channel too. Close stops the attempts.
+func (sp *SerialPort) SetAutoReconnect(retry, maxRetry time.Duration) error {
+	if err := validateReconnect(retry); err != nil {
+		return err
+	}
+	if maxRetry < retry {
+		maxRetry = retry
+	}
+	sp.handlersMu.Lock()
+	sp.autoRetry, sp.autoMax = retry, maxRetry
+	sp.handlersMu.Unlock()
+	return nil
+}
+
+// validateReconnect checks the first retry delay of the auto-reconnect, 0 disabling it.
+func validateReconnect(retry time.Duration) error {
+	if retry < 0 {
+		return fmt.Errorf("Invalid reconnect delay %v", retry)
+	}
 	return nil
 }
 
-// This method close the current Serial Port.
+// startAutoReconnect launches the auto-reconnect thread of a disconnected port, if
+// enabled and not running yet.
+func (sp *SerialPort) startAutoReconnect() {
+	sp.handlersMu.Lock()
+	defer sp.handlersMu.Unlock()
+	if sp.autoRetry == 0 || sp.autoStop != nil {
+		return
+	}
+	stop := make(chan struct{})
+	sp.autoStop = stop
+	retry, maxRetry := sp.autoRetry, sp.autoMax
+	sp.goThread(func() { sp.autoReconnect(stop, retry, maxRetry) })
+}
+
+// autoReconnect opens the port again after retry, doubling it up to maxRetry after every
+// failure attempt, until it succeeds or stop is closed by Close.
+func (sp *SerialPort) autoReconnect(stop chan struct{}, retry, maxRetry time.Duration) {
+	defer sp.recoverPanic("auto-reconnect")
+	sp.setState(StateReconnecting, nil)
+	for {
+		select {
+		case <-stop:
+			return
+		case <-time.After(retry):
+		}
+		sp.lifeMu.Lock()
+		select {
+		case <-stop:
+			sp.lifeMu.Unlock()
+			return
+		default:
+		}
+		// Free the device node of the old port for the new one
+		unblockPort(sp.device())
+		releasePort(sp.device())
+		comPort, err := sp.reopen()
+		if err == nil {
+			sp.close(StateReconnecting)
+			sp.handlersMu.Lock()
+			sp.autoStop = nil
+			sp.handlersMu.Unlock()
+			err = sp.resume(comPort)
+			sp.lifeMu.Unlock()
+			if err == nil {
+				sp.reconnected()
+			}
+			return
+		}
+		sp.lifeMu.Unlock()
+		sp.setState(StateReconnecting, err)
+		sp.reportError(err)
+		if retry *= 2; retry > maxRetry {
+			retry = maxRetry
+		}
+	}
+}
+
+// OnReconnect registers a handler called by Reconnect once the port is open and
+// configured again. A nil handler removes it.
+func (sp *SerialPort) OnReconnect(handler func()) {
+	sp.handlersMu.Lock()
+	sp.onReconnect = handler
+	sp.handlersMu.Unlock()
+}
+
+// IsOpen reports whether the port is open, from Open until Close. A port whose device was
+// disconnected stays open until it is closed, see ErrPortDisconnected. It is safe to call
+// from any goroutine.
+func (sp *SerialPort) IsOpen() bool {
+	return sp.portIsOpen.Load()
+}
+
+// This method close the current Serial Port. Buffered writes still pending are sent first.
+// It returns once the reader threads have exited, so it must not be called from a handler
+// they run (OnLine, TailTo).
 func (sp *SerialPort) Close() error {
-	if sp.portIsOpen {
-		sp.portIsOpen = false
-		close(sp.rxChar)
-		return sp.port.Close()
+	sp.handlersMu.Lock()
+	if sp.autoStop != nil {
+		close(sp.autoStop)
+		sp.autoStop = nil
+	}
+	sp.handlersMu.Unlock()
+	sp.lifeMu.Lock()
+	defer sp.lifeMu.Unlock()
+	return sp.close(StateClosed)
+}
+
+// close closes the current session of the port, which then enters state.
+func (sp *SerialPort) close(state PortState) error {
+	if sp.portIsOpen.Load() {
+		flushErr := sp.FlushWrites()
+		if !sp.portIsOpen.CompareAndSwap(true, false) {
+			// Closed by a concurrent Close
+			return flushErr
+		}
+		close(sp.done)
+		sp.buffMu.Lock()
+		sp.notifyRx()
+		sp.buffMu.Unlock()
+		sp.setState(state, nil)
+		if err := sp.closePort(); err != nil && !errors.Is(err, os.ErrClosed) {
+			return err
+		}
+		return flushErr
 	}
 	return nil
 }
 
-// This method prints data trough the serial port.
+// This method prints data trough the serial port. Like any io.Writer, it writes all of
+// data or returns an error telling why it didn't.
 func (sp *SerialPort) Write(data []byte) (n int, err error) {
-	if sp.portIsOpen {
-		n, err = sp.port.Write(data)
+	if sp.portIsOpen.Load() {
+		n, err = sp.write(data)
 	} else {
-		err = fmt.Errorf("Serial port is not open")
+		err = errNotOpen
 	}
 	return n, err
 }
 
 // This method prints data trough the serial port.
 func (sp *SerialPort) Print(str string) error {
-	if sp.portIsOpen {
-		sp.port.Write([]byte(str))
-	} else {
-		return fmt.Errorf("Serial port is not open")
-	}
-	return nil
+	_, err := sp.Write([]byte(str))
+	return err
 }
 
 // Prints data to the serial port as human-readable ASCII text followed by a carriage return character
@@ -116,152 +567,2694 @@ func (sp *SerialPort) Printf(format string, args ...interface{}) error {
 
 //This method send a binary file trough the serial port. If EnableLog is active then this method will log file related data.
 func (sp *SerialPort) SendFile(filepath string) error {
-	// Aux Vars
-	sentBytes := 0
-	q := 512
-	data := []byte{}
-	// Read file
-	file, err := ioutil.ReadFile(filepath)
-	if err != nil {
-		return err
-	} else {
-		fileSize := len(file)
-		for sentBytes <= fileSize {
-			//Try sending slices of less or equal than 512 bytes at time
-			if len(file[sentBytes:]) > q {
-				data = file[sentBytes:(sentBytes + q)]
-			} else {
-				data = file[sentBytes:]
-			}
-			// Write binaries
-			_, err := sp.port.Write(data)
-			if err != nil {
-				return err
-			} else {
-				sentBytes += q
-				time.Sleep(time.Millisecond * 100)
-			}
+	var sent int64
+	return sp.sendFile(filepath, 0, 512, nil, nil, &sent, nil)
+}
+
+// SendFileEncoded sends a file like SendFile, passing each chunk through encode before
+// writing it (e.g. base64 or hex encoding expected by the device).
+//
+// Chunks are encoded on their own, so encode must produce a valid stream when the data
+// is split at chunk boundaries. The file is sent in chunks of 510 bytes, a multiple of 3
+// so that base64 does not insert padding inside the stream.
+func (sp *SerialPort) SendFileEncoded(filepath string, encode func([]byte) []byte) error {
+	if encode == nil {
+		return fmt.Errorf("Missing encoder")
+	}
+	var sent int64
+	return sp.sendFile(filepath, 0, 510, encode, nil, &sent, nil)
+}
+
+// SendFileWithCRC sends a binary file like SendFile and returns the CRC of the bytes sent,
+// to compare with the one computed by the device. The CRC is computed with h if given,
+// e.g. crc32.New(crc32.MakeTable(crc32.Castagnoli)), the CRC-32 of IEEE 802.3 (the one of
+// zlib and Ethernet) otherwise. h must be new or reset.
+func (sp *SerialPort) SendFileWithCRC(filepath string, h ...hash.Hash32) (uint32, error) {
+	sum := crc32.NewIEEE()
+	if len(h) > 0 && h[0] != nil {
+		sum = h[0]
+	}
+	var sent int64
+	// Chunks are hashed as they are written, unchanged
+	hashChunk := func(data []byte) []byte {
+		sum.Write(data)
+		return data
+	}
+	if err := sp.sendFile(filepath, 0, 512, hashChunk, nil, &sent, nil); err != nil {
+		return 0, err
+	}
+	return sum.Sum32(), nil
+}
+
+// SendFileTimeout sends a binary file like SendFile, but the whole transfer fails with a
+// timeout error if it doesn't complete within timeout. It returns the number of bytes sent.
+//
+// On timeout, no chunk is started anymore. The chunk being written is given a short grace
+// period, the timeout up to 100 ms, to be counted; a write stalled longer, e.g. on a link
+// paused by flow control, isn't waited for: it is not counted and may still complete after
+// SendFileTimeout returned.
+func (sp *SerialPort) SendFileTimeout(filepath string, timeout time.Duration) (int, error) {
+	var sent int64
+	done := make(chan struct{})
+	c1 := make(chan error, 1)
+	sp.goThread(func() {
+		c1 <- sp.sendFile(filepath, 0, 512, nil, done, &sent, nil)
+	})
+	select {
+	case err := <-c1:
+		return int(atomic.LoadInt64(&sent)), err
+	case <-time.After(timeout):
+		close(done)
+	}
+	grace := 100 * time.Millisecond
+	if timeout < grace {
+		grace = timeout
+	}
+	select {
+	case <-c1:
+	case <-time.After(grace):
+	}
+	return int(atomic.LoadInt64(&sent)), fmt.Errorf("Timeout expired")
+}
+
+// SendFileFrom sends a binary file like SendFile, starting at the byte offset of the file,
+// e.g. to resume a transfer interrupted by a disconnection. It returns the offset reached,
+// from which the transfer can be resumed after a failure. The optional progress callback
+// is called after every chunk with the offset reached and the size of the file, e.g. to
+// persist the offset of big transfers.
+func (sp *SerialPort) SendFileFrom(filepath string, offset int, progress ...func(offset, size int)) (int, error) {
+	return sp.SendFileFromContext(context.Background(), filepath, offset, progress...)
+}
+
+// SendFileFromContext is SendFileFrom stopping between chunks with ctx.Err() when ctx is
+// done, still returning the offset reached.
+func (sp *SerialPort) SendFileFromContext(ctx context.Context, filepath string, offset int, progress ...func(offset, size int)) (int, error) {
+	var report func(offset, size int)
+	if len(progress) > 0 {
+		report = progress[0]
+	}
+	var sent int64
+	err := sp.sendFile(filepath, offset, 512, nil, ctx.Done(), &sent, report)
+	if ctxErr := ctx.Err(); err != nil && ctxErr != nil {
+		err = ctxErr
+	}
+	return offset + int(atomic.LoadInt64(&sent)), err
+}
+
+// Read reads up to len(b) bytes of the serial buffer, as an io.Reader: it waits for data
+// up to the read timeout of the port, or until data arrives in blocking mode. Once the port
+// is closed, the data still buffered is returned, then io.EOF; once it is disconnected,
+// ErrPortDisconnected. The port can so be used with bufio, io.Copy and the like.
+func (sp *SerialPort) Read(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	var n int
+	err := sp.waitBuffer(sp.streamTimeout(), func(buff *bytes.Buffer) bool {
+		if buff.Len() == 0 {
+			return false
 		}
+		n, _ = buff.Read(b)
+		return true
+	})
+	if err == errNotOpen {
+		err = io.EOF
 	}
-	//Encode data to send
-	return nil
+	return n, err
 }
 
-// Read the first byte of the serial buffer.
-func (sp *SerialPort) Read() (byte, error) {
-	if sp.portIsOpen {
-		return sp.buff.ReadByte()
-	} else {
-		return 0x00, fmt.Errorf("Serial port is not open")
+// ReadByte reads the first byte of the serial buffer, io.EOF when it is empty. Once the
+// port has been closed, the bytes still buffered can be read, then it fails.
+func (sp *SerialPort) ReadByte() (byte, error) {
+	sp.buffMu.Lock()
+	defer sp.buffMu.Unlock()
+	if sp.buff.Len() == 0 {
+		if !sp.portIsOpen.Load() {
+			return 0x00, errNotOpen
+		}
+		if sp.disconnected {
+			return 0x00, ErrPortDisconnected
+		}
 	}
-	return 0x00, nil
+	return sp.buff.ReadByte()
 }
 
 // Read first available line from serial port buffer.
 //
 // Line is delimited by the EOL character, newline character (ASCII 10, LF, '\n') is used by default.
 //
-// The text returned from ReadLine does not include the line end ("\r\n" or '\n').
+// The text returned from ReadLine does not include the line end ("\r\n" or '\n'), or the
+// sequence set with EOLString.
+//
+// While the port is open, a partial line (data without EOL) is kept in the buffer and
+// io.EOF is returned until the rest of the line arrives. Once the port has been closed,
+// the lines still buffered can be read and a final partial line is returned together
+// with ErrIncompleteLine, so the caller can decide whether to keep it. The same applies
+// once the port is disconnected, ErrPortDisconnected being returned last.
 func (sp *SerialPort) ReadLine() (string, error) {
-	if sp.portIsOpen {
-		line, err := sp.buff.ReadString(sp.eol)
-		if err != nil {
-			return "", err
-		} else {
-			return removeEOL(line), nil
+	sp.buffMu.Lock()
+	defer sp.buffMu.Unlock()
+	if line, ok := nextLine(sp.buff, sp.eol); ok {
+		return line, nil
+	}
+	if sp.portIsOpen.Load() && !sp.disconnected {
+		return "", io.EOF
+	}
+	if sp.buff.Len() > 0 {
+		line := sp.buff.String()
+		sp.buff.Reset()
+		return trimEOL([]byte(line), sp.eol), ErrIncompleteLine
+	}
+	if sp.portIsOpen.Load() {
+		return "", ErrPortDisconnected
+	}
+	return "", errNotOpen
+}
+
+// ReadLineDelim waits up to timeout for a line ending with delim, for this call only: the
+// EOL of the port is left unchanged, e.g. for a command whose reply has its own
+// terminator. Only the trailing delim is removed from the line returned. On timeout, a
+// partial line is kept in the buffer.
+func (sp *SerialPort) ReadLineDelim(delim byte, timeout time.Duration) (string, error) {
+	line, err := sp.readUntil([]byte{delim}, timeout)
+	if err != nil {
+		return "", err
+	}
+	return string(line[:len(line)-1]), nil
+}
+
+// ReadLineTimeout is ReadLine waiting up to timeout for a complete line, then returning
+// the "Timeout expired" error with the partial line left in the buffer. Only the line
+// returned is consumed. Once the port is closed or disconnected, it returns like ReadLine.
+func (sp *SerialPort) ReadLineTimeout(timeout time.Duration) (string, error) {
+	var line string
+	err := sp.waitBuffer(timeout, func(buff *bytes.Buffer) bool {
+		var ok bool
+		line, ok = nextLine(buff, sp.eol)
+		return ok
+	})
+	if err == errNotOpen || err == ErrPortDisconnected {
+		return sp.ReadLine()
+	}
+	return line, err
+}
+
+// ReadLineContext is ReadLine waiting for a complete line until ctx is done, then returning
+// ctx.Err(). Once the port is closed or disconnected, it returns like ReadLine.
+func (sp *SerialPort) ReadLineContext(ctx context.Context) (string, error) {
+	var line string
+	err := sp.waitBufferContext(ctx, math.MaxInt64, func(buff *bytes.Buffer) bool {
+		var ok bool
+		line, ok = nextLine(buff, sp.eol)
+		return ok
+	})
+	if err == errNotOpen || err == ErrPortDisconnected {
+		return sp.ReadLine()
+	}
+	return line, err
+}
+
+// ReadBurst waits up to firstByteTimeout for data to be received, then keeps reading until
+// no byte arrives for interByteTimeout or max bytes are read, and returns the data consumed.
+//
+// If no data arrives in time, the timeout error is returned without data. If the port
+// is closed or disconnected during the burst, the data read so far is returned with the
+// error.
+func (sp *SerialPort) ReadBurst(firstByteTimeout, interByteTimeout time.Duration, max int) ([]byte, error) {
+	if max <= 0 {
+		return nil, fmt.Errorf("Invalid maximum size %v", max)
+	}
+	var data []byte
+	read := func(buff *bytes.Buffer) bool {
+		if buff.Len() == 0 {
+			return false
 		}
-	} else {
-		return "", fmt.Errorf("Serial port is not open")
+		data = append(data, buff.Next(max-len(data))...)
+		return true
 	}
-	return "", nil
+	if err := sp.waitBuffer(firstByteTimeout, read); err != nil {
+		return nil, err
+	}
+	for len(data) < max {
+		err := sp.waitBuffer(interByteTimeout, read)
+		if err == errNotOpen || err == ErrPortDisconnected {
+			return data, err
+		} else if err != nil {
+			// Inter-byte gap, end of the burst
+			break
+		}
+	}
+	return data, nil
 }
 
-// Wait for a defined regular expression for a defined amount of time.
-func (sp *SerialPort) WaitForRegexTimeout(exp string, timeout time.Duration) (string, error) {
+// ReadBurstUntil is ReadBurst for devices marking the end of their output, e.g. with EOT
+// (0x04): it reads until terminator is received and returns the data before it, the
+// terminator being consumed. A positive interByteTimeout also ends the read once no byte
+// arrives for that long, as ReadBurst does; with 0 only the terminator does. The read is
+// bounded by timeout in total, and by max bytes.
+//
+// When the timeout expires, the data read so far is returned with the timeout error. Like
+// ReadBurst, the data read so far is returned with the error if the port is closed or
+// disconnected.
+func (sp *SerialPort) ReadBurstUntil(terminator byte, timeout, interByteTimeout time.Duration, max int) ([]byte, error) {
+	if max <= 0 {
+		return nil, fmt.Errorf("Invalid maximum size %v", max)
+	}
+	var data []byte
+	found := false
+	read := func(buff *bytes.Buffer) bool {
+		if buff.Len() == 0 {
+			return false
+		}
+		chunk := buff.Bytes()
+		if n := max - len(data); len(chunk) > n {
+			chunk = chunk[:n]
+		}
+		if i := bytes.IndexByte(chunk, terminator); i >= 0 {
+			data = append(data, chunk[:i]...)
+			buff.Next(i + 1)
+			found = true
+			return true
+		}
+		data = append(data, chunk...)
+		buff.Next(len(chunk))
+		return true
+	}
+	deadline := time.Now().Add(timeout)
+	for !found && len(data) < max {
+		wait := time.Until(deadline)
+		gap := len(data) > 0 && interByteTimeout > 0 && interByteTimeout < wait
+		if gap {
+			wait = interByteTimeout
+		}
+		err := sp.waitBuffer(wait, read)
+		if err == errNotOpen || err == ErrPortDisconnected {
+			return data, err
+		} else if err != nil && gap {
+			// Inter-byte gap, end of the burst
+			break
+		} else if err != nil {
+			return data, err
+		}
+	}
+	return data, nil
+}
+
+// ReadChecked reads n bytes within timeout, calling update with each byte as it arrives,
+// e.g. to compute a checksum of a large frame on the fly. On timeout, the data read so far
+// is consumed and returned with the timeout error.
+func (sp *SerialPort) ReadChecked(n int, update func(b byte), timeout time.Duration) ([]byte, error) {
+	if n < 0 {
+		return nil, fmt.Errorf("Invalid size %v", n)
+	}
+	data := make([]byte, 0, n)
+	deadline := time.Now().Add(timeout)
+	for len(data) < n {
+		var chunk []byte
+		err := sp.waitBuffer(time.Until(deadline), func(buff *bytes.Buffer) bool {
+			if buff.Len() == 0 {
+				return false
+			}
+			chunk = append(chunk, buff.Next(n-len(data))...)
+			return true
+		})
+		if err != nil {
+			return data, err
+		}
+		for _, b := range chunk {
+			if update != nil {
+				update(b)
+			}
+		}
+		data = append(data, chunk...)
+	}
+	return data, nil
+}
 
-	if sp.portIsOpen {
-		//Decode received data
-		timeExpired := false
+// Capture gathers the data received, including the data already buffered, for up to
+// timeout or until maxBytes are gathered, for an ad-hoc grab of what a device sends. When
+// the timeout expires, the data gathered so far is returned without error.
+func (sp *SerialPort) Capture(maxBytes int, timeout time.Duration) ([]byte, error) {
+	if maxBytes < 0 {
+		return nil, fmt.Errorf("Invalid size %v", maxBytes)
+	}
+	var data []byte
+	deadline := time.Now().Add(timeout)
+	for len(data) < maxBytes {
+		err := sp.waitBuffer(time.Until(deadline), func(buff *bytes.Buffer) bool {
+			if buff.Len() == 0 {
+				return false
+			}
+			data = append(data, buff.Next(maxBytes-len(data))...)
+			return true
+		})
+		if err == errNotOpen || err == ErrPortDisconnected {
+			return data, err
+		} else if err != nil {
+			// Timeout, the capture is over
+			break
+		}
+	}
+	return data, nil
+}
 
-		regExpPatttern := regexp.MustCompile(exp)
+// ReadUntil waits up to timeout for delim to be received, and consumes and returns the data
+// up to and including it, like bufio.Reader.ReadString. Unlike ReadLine, the EOL setting
+// doesn't apply and CR and LF are left in the data. On timeout, the data buffered is
+// consumed and returned with the timeout error.
+func (sp *SerialPort) ReadUntil(delim byte, timeout time.Duration) (string, error) {
+	data, err := sp.readUntil([]byte{delim}, timeout)
+	if err != nil {
+		sp.buffMu.Lock()
+		data = append([]byte(nil), sp.buff.Next(sp.buff.Len())...)
+		sp.buffMu.Unlock()
+	}
+	return string(data), err
+}
 
-		//Timeout structure
-		c1 := make(chan string, 1)
-		go func() {
-			result := []string{}
-			for !timeExpired {
-				line, err := sp.ReadLine()
-				if err != nil {
-					// Do nothing
-				} else {
-					result = regExpPatttern.FindAllString(line, -1)
-					if len(result) > 0 {
-						c1 <- result[0]
-						break
-					}
-				}
+// ReadUntilAny waits up to timeout for one of delims to be received, and consumes and
+// returns the data up to and including the first one, together with the delimiter that
+// matched. On timeout, the data buffered is consumed and returned with the timeout error.
+func (sp *SerialPort) ReadUntilAny(delims []byte, timeout time.Duration) ([]byte, byte, error) {
+	if len(delims) == 0 {
+		return nil, 0, fmt.Errorf("Missing delimiters")
+	}
+	var isDelim [256]bool
+	for _, d := range delims {
+		isDelim[d] = true
+	}
+	var data []byte
+	var delim byte
+	err := sp.waitBuffer(timeout, func(buff *bytes.Buffer) bool {
+		for i, b := range buff.Bytes() {
+			if isDelim[b] {
+				data = append([]byte(nil), buff.Next(i+1)...)
+				delim = b
+				return true
 			}
-		}()
-		select {
-		case data := <-c1:
-			return data, nil
-		case <-time.After(timeout):
-			timeExpired = true
-			return "", fmt.Errorf("Timeout expired")
 		}
-	} else {
-		return "", fmt.Errorf("Serial port is not open")
+		return false
+	})
+	if err != nil {
+		sp.buffMu.Lock()
+		data = append([]byte(nil), sp.buff.Next(sp.buff.Len())...)
+		sp.buffMu.Unlock()
+		return data, 0, err
 	}
-	return "", nil
+	return data, delim, nil
 }
 
-// Available return the total number of available unread bytes on the serial buffer.
-func (sp *SerialPort) Available() int {
-	return sp.buff.Len()
+// ReadUntilPrompt waits up to timeout for the literal prompt, e.g. "device> ", to be
+// received, and returns the output before it, typically the response to the last command
+// of an interactive device. The output and the prompt are consumed. The prompt doesn't
+// need to be followed by a line end. On timeout, the data buffered is consumed and
+// returned with the timeout error.
+func (sp *SerialPort) ReadUntilPrompt(prompt string, timeout time.Duration) (string, error) {
+	if prompt == "" {
+		return "", fmt.Errorf("Empty prompt")
+	}
+	var output string
+	err := sp.waitBuffer(timeout, func(buff *bytes.Buffer) bool {
+		i := bytes.Index(buff.Bytes(), []byte(prompt))
+		if i < 0 {
+			return false
+		}
+		output = string(buff.Next(i))
+		buff.Next(len(prompt))
+		return true
+	})
+	if err != nil {
+		sp.buffMu.Lock()
+		output = string(sp.buff.Next(sp.buff.Len()))
+		sp.buffMu.Unlock()
+		return output, err
+	}
+	return output, nil
 }
 
-// Change end of line character (AKA EOL), newline character (ASCII 10, LF, '\n') is used by default.
-func (sp *SerialPort) EOL(c byte) {
-	sp.eol = c
+// Wait for a defined regular expression for a defined amount of time.
+//
+// Lines are consumed up to the end of the match: any data following the match on the
+// same line stays in the buffer for subsequent reads. The lines not matching exp are
+// discarded, and an invalid exp is returned as an error.
+func (sp *SerialPort) WaitForRegexTimeout(exp string, timeout time.Duration) (string, error) {
+	m, err := sp.waitForRegex(exp, timeout)
+	if err != nil {
+		return "", err
+	}
+	return m[0], nil
 }
 
-/*******************************************************************************************
-******************************   PRIVATE FUNCTIONS  ****************************************
-*******************************************************************************************/
+// WaitForRegexSubmatchTimeout is WaitForRegexTimeout returning the submatches, like
+// regexp.FindStringSubmatch: the whole match followed by the capture groups, e.g. "23.5"
+// and "60" for `TEMP=([\d.]+) HUM=(\d+)`. A group not taking part in the match is empty.
+func (sp *SerialPort) WaitForRegexSubmatchTimeout(exp string, timeout time.Duration) ([]string, error) {
+	return sp.waitForRegex(exp, timeout)
+}
 
-func (sp *SerialPort) readSerialPort() {
-	rxBuff := make([]byte, 256)
-	for sp.portIsOpen {
-		n, _ := sp.port.Read(rxBuff)
-		// Write data to serial buffer
-		sp.buff.Write(rxBuff[:n])
-		for _, b := range rxBuff[:n] {
-			if sp.portIsOpen {
-				sp.rxChar <- b
+// waitForRegex waits up to timeout for a line matching exp, discarding the lines that
+// don't, and returns the submatches.
+func (sp *SerialPort) waitForRegex(exp string, timeout time.Duration) ([]string, error) {
+	if !sp.portIsOpen.Load() {
+		return nil, errNotOpen
+	}
+	re, err := regexp.Compile(exp)
+	if err != nil {
+		return nil, err
+	}
+	var match []string
+	err = sp.waitBuffer(timeout, func(buff *bytes.Buffer) bool {
+		for bytes.Contains(buff.Bytes(), sp.eol) {
+			if m, ok := matchBuffLine(buff, sp.eol, re); ok {
+				match = m
+				return true
 			}
 		}
-	}
+		return false
+	})
+	return match, err
 }
 
-func (sp *SerialPort) processSerialPort() {
-	screenBuff := make([]byte, 0)
-	var lastRxByte byte
-	for {
-		if sp.portIsOpen {
-			lastRxByte = <-sp.rxChar
-			// Print received lines
-			switch lastRxByte {
-			case sp.eol:
-				// EOL - Print received data
-				screenBuff = make([]byte, 0) //Clean buffer
-				break
-			default:
-				screenBuff = append(screenBuff, lastRxByte)
+// WaitForRegexContext is WaitForRegexTimeout waiting until ctx is done instead of a
+// timeout, then returning ctx.Err(). The lines not matching exp are discarded.
+func (sp *SerialPort) WaitForRegexContext(ctx context.Context, exp string) (string, error) {
+	if !sp.portIsOpen.Load() {
+		return "", errNotOpen
+	}
+	re, err := regexp.Compile(exp)
+	if err != nil {
+		return "", err
+	}
+	var match string
+	err = sp.waitBufferContext(ctx, math.MaxInt64, func(buff *bytes.Buffer) bool {
+		for bytes.Contains(buff.Bytes(), sp.eol) {
+			if m, ok := matchBuffLine(buff, sp.eol, re); ok {
+				match = m[0]
+				return true
 			}
-		} else {
-			break
 		}
+		return false
+	})
+	return match, err
+}
+
+// TextprotoReader returns a textproto.Reader reading the received data, e.g. for
+// protocols with continuation lines or dot-stuffing. The reader buffers data ahead, which
+// is then no longer available to the other read functions.
+//
+// Each underlying read waits up to the read timeout given to Open, failing with a timeout
+// error if no data arrives (the data already read stays buffered in the reader). In
+// blocking mode reads wait for data until the port is closed, which ends the stream.
+func (sp *SerialPort) TextprotoReader() *textproto.Reader {
+	return textproto.NewReader(bufio.NewReader(sp))
+}
+
+// SkipUntil discards the received lines, e.g. the boot banner of a device, until prompt
+// matches one of them or the line being received (a prompt usually has no line end).
+// The data is consumed up to the end of the match, and the line end if nothing else
+// follows on the line. The lines discarded before a timeout are not kept either.
+func (sp *SerialPort) SkipUntil(prompt *regexp.Regexp, timeout time.Duration) error {
+	return sp.waitBuffer(timeout, func(buff *bytes.Buffer) bool {
+		for {
+			line := buff.Bytes()
+			i := bytes.Index(line, sp.eol)
+			if i >= 0 {
+				line = line[:i]
+			}
+			if loc := prompt.FindIndex(line); loc != nil {
+				if i >= 0 && len(bytes.TrimRight(line[loc[1]:], "\r")) == 0 {
+					buff.Next(i + len(sp.eol))
+				} else {
+					buff.Next(loc[1])
+				}
+				return true
+			}
+			if i < 0 {
+				return false
+			}
+			buff.Next(i + len(sp.eol))
+		}
+	})
+}
+
+// Available return the total number of available unread bytes on the serial buffer.
+//
+// The value is a point-in-time snapshot taken under the buffer lock: the reader thread
+// may buffer more data right after it is taken.
+func (sp *SerialPort) Available() int {
+	sp.buffMu.Lock()
+	defer sp.buffMu.Unlock()
+	return sp.buff.Len()
+}
+
+// SetBufferLimit bounds the data buffered by the port to n bytes, 0 for no limit (the
+// default). Once the limit is reached, the oldest bytes buffered are discarded to make
+// room for the data received, and counted by DroppedBytes. A negative n is an error.
+func (sp *SerialPort) SetBufferLimit(n int) error {
+	if n < 0 {
+		return fmt.Errorf("Invalid buffer limit %v", n)
+	}
+	sp.buffMu.Lock()
+	sp.buffLimit = n
+	sp.buffMu.Unlock()
+	return nil
+}
+
+// DroppedBytes returns the number of bytes discarded by the buffer limit (see
+// SetBufferLimit) since the port was opened, when the application doesn't read the data
+// as fast as it is received. It is counted in software, apart from the overruns of the
+// driver. The count only grows while the port is open, reconnections included, and is
+// reset by Open and OpenStty.
+func (sp *SerialPort) DroppedBytes() uint64 {
+	return sp.dropped.Load()
+}
+
+// AvailableAll returns, in one call, the number of unread bytes on the serial buffer and
+// the number of bytes received by the driver but not yet read by the reader thread. Like
+// Available, it is a point-in-time snapshot.
+func (sp *SerialPort) AvailableAll() (buffered int, queued int, err error) {
+	if !sp.portIsOpen.Load() {
+		return 0, 0, errNotOpen
+	}
+	if p, ok := sp.device().(interface{ inputQueued() (int, error) }); ok {
+		if queued, err = p.inputQueued(); err != nil {
+			return 0, 0, err
+		}
+	}
+	return sp.Available(), queued, nil
+}
+
+// ProbeActivity watches the line for up to d and reports whether it seems active: data
+// is received, or the Data Carrier Detect line is asserted on ports with modem control
+// lines. The data received is left in the buffer.
+func (sp *SerialPort) ProbeActivity(d time.Duration) (bool, error) {
+	if !sp.portIsOpen.Load() {
+		return false, errNotOpen
+	}
+	carrier, _ := sp.device().(interface{ carrierDetect() (bool, error) })
+	sp.buffMu.Lock()
+	start := sp.rxCount
+	sp.buffMu.Unlock()
+	received := func(*bytes.Buffer) bool {
+		return sp.rxCount != start
+	}
+	deadline := time.Now().Add(d)
+	for {
+		if carrier != nil {
+			if dcd, err := carrier.carrierDetect(); err != nil {
+				// No modem control lines
+				carrier = nil
+			} else if dcd {
+				return true, nil
+			}
+		}
+		step := time.Until(deadline)
+		if step <= 0 {
+			return false, nil
+		}
+		if carrier != nil && step > 50*time.Millisecond {
+			// Poll DCD while waiting for data
+			step = 50 * time.Millisecond
+		}
+		err := sp.waitBuffer(step, received)
+		if err == nil {
+			return true, nil
+		} else if err == errNotOpen || err == ErrPortDisconnected {
+			return false, err
+		}
+	}
+}
+
+// SetReadyLines sets the modem control lines Ready requires to be asserted: Data Set
+// Ready and Data Carrier Detect. None is required by default, as many devices don't
+// drive them.
+func (sp *SerialPort) SetReadyLines(dsr, dcd bool) {
+	sp.handlersMu.Lock()
+	sp.readyDSR = dsr
+	sp.readyDCD = dcd
+	sp.handlersMu.Unlock()
+}
+
+// Ready reports whether the device is connected and responsive. The modem control lines
+// set by SetReadyLines must be asserted; then, when probe is not empty, it is sent and a
+// received line must match expect within timeout (with a nil expect, any data received
+// will do). Errors are returned for what prevents the check, like a closed port or
+// missing modem control lines, not for an unresponsive device.
+//
+// With a probe, Ready flushes the input buffer first: the data received and not read yet
+// is discarded, so that a stale response isn't taken for the answer to the probe. Read it
+// before calling Ready to keep it. The lines received up to the match are consumed too.
+func (sp *SerialPort) Ready(probe []byte, expect *regexp.Regexp, timeout time.Duration) (bool, error) {
+	if !sp.portIsOpen.Load() {
+		return false, errNotOpen
+	}
+	sp.handlersMu.Lock()
+	dsr, dcd := sp.readyDSR, sp.readyDCD
+	sp.handlersMu.Unlock()
+	if dsr {
+		lines, ok := sp.device().(interface{ dataSetReady() (bool, error) })
+		if !ok {
+			return false, fmt.Errorf("Modem lines not supported on \"%s\"", sp.Name())
+		}
+		if on, err := lines.dataSetReady(); err != nil || !on {
+			return false, err
+		}
+	}
+	if dcd {
+		lines, ok := sp.device().(interface{ carrierDetect() (bool, error) })
+		if !ok {
+			return false, fmt.Errorf("Modem lines not supported on \"%s\"", sp.Name())
+		}
+		if on, err := lines.carrierDetect(); err != nil || !on {
+			return false, err
+		}
+	}
+	if len(probe) == 0 {
+		return true, nil
+	}
+	// A stale response must not be taken for the answer to the probe
+	sp.buffMu.Lock()
+	sp.buff.Reset()
+	start := sp.rxCount
+	sp.buffMu.Unlock()
+	if _, err := sp.write(probe); err != nil {
+		return false, err
+	}
+	if err := sp.FlushWrites(); err != nil {
+		return false, err
+	}
+	err := sp.waitBuffer(timeout, func(buff *bytes.Buffer) bool {
+		if expect == nil {
+			return sp.rxCount != start
+		}
+		for bytes.Contains(buff.Bytes(), sp.eol) {
+			if _, ok := matchBuffLine(buff, sp.eol, expect); ok {
+				return true
+			}
+		}
+		return false
+	})
+	if err == errNotOpen || err == ErrPortDisconnected {
+		return false, err
+	}
+	return err == nil, nil
+}
+
+// ByteDuration returns the time taken to transmit one character at the baud rate and with
+// the framing of the port: start bit, data bits, parity bit and stop bits, e.g. 10 bits
+// for 8N1.
+func (sp *SerialPort) ByteDuration() time.Duration {
+	return sp.FrameDuration(1)
+}
+
+// FrameDuration returns the time taken to transmit a frame of n bytes back to back, see
+// ByteDuration.
+func (sp *SerialPort) FrameDuration(n int) time.Duration {
+	m := sp.Mode()
+	if m.Baud <= 0 {
+		return 0
+	}
+	// In half bits, for 1.5 stop bits
+	bits := 2 + 2*m.DataBits
+	if m.Parity != ParityNone {
+		bits += 2
+	}
+	switch m.StopBits {
+	case Stop1Half:
+		bits += 3
+	case Stop2:
+		bits += 4
+	default:
+		bits += 2
+	}
+	return time.Duration(n) * time.Duration(bits) * time.Second / time.Duration(2*m.Baud)
+}
+
+// WaitForModbusGap waits until the line has been silent for the inter-frame delay of
+// Modbus RTU, delimiting a frame. As specified by Modbus over serial line, the delay is
+// 3.5 character times (see ByteDuration) up to 19200 baud, and a fixed 1.75 ms above, as
+// the character time gets too short to be timed reliably. It waits as long as data
+// keeps arriving, until the port is closed.
+func (sp *SerialPort) WaitForModbusGap() error {
+	gap := 1750 * time.Microsecond
+	if sp.Baud() <= 19200 {
+		gap = sp.ByteDuration() * 7 / 2
+	}
+	for {
+		sp.buffMu.Lock()
+		silence := time.Since(sp.lastRx)
+		signal := sp.rxSignal
+		disconnected := sp.disconnected
+		sp.buffMu.Unlock()
+		if !sp.portIsOpen.Load() {
+			return errNotOpen
+		}
+		if disconnected {
+			return ErrPortDisconnected
+		}
+		if silence >= gap {
+			return nil
+		}
+		timer := time.NewTimer(gap - silence)
+		select {
+		case <-signal:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// Change end of line character (AKA EOL), newline character (ASCII 10, LF, '\n') is used by default.
+//
+// The change applies to the data already buffered: the next ReadLine splits everything
+// present with the new EOL. The partial line held for the line handlers is re-framed
+// with the new EOL when the next byte is received.
+//
+// Lines are split on the EOL byte wherever it appears, which is safe for UTF-8 text with
+// an ASCII EOL ('\n', '\r', 0x03...): the bytes of a multi-byte character are all above
+// 0x7F, so a character split across reads is returned whole. A non-ASCII EOL (0x80 to
+// 0xFF) is unsafe with UTF-8 text, as it can be a byte of a character and split it.
+func (sp *SerialPort) EOL(c byte) {
+	sp.EOLString(string([]byte{c}))
+}
+
+// EOLString sets a multi-byte end of line sequence, e.g. "\r\n" to split lines only on
+// that exact sequence: a lone '\r' or '\n' is then part of the line. Only the sequence
+// is removed from the lines returned, whereas a single byte EOL set with EOL also removes
+// every CR and LF. A delim of a single byte is the same as EOL; an empty delim is ignored.
+func (sp *SerialPort) EOLString(delim string) {
+	if delim == "" {
+		return
+	}
+	sp.buffMu.Lock()
+	sp.eol = []byte(delim)
+	sp.buffMu.Unlock()
+}
+
+// ReadTimeoutValues returns the VMIN and VTIME values programmed on POSIX systems for the
+// read timeout, as read back from the tty when the port was opened (0, 0 before). VTIME
+// is in deciseconds, at least 1 and capped to 255 (25.5s), so it may differ from the
+// requested timeout. VMIN is 1 for blocking reads. SetReadTimeout doesn't change them
+// until the next open or Reconnect. Where there is no tty to read them from, they are
+// the values computed for the read timeout the port was opened with.
+func (sp *SerialPort) ReadTimeoutValues() (vmin uint8, vtime uint8) {
+	sp.settingsMu.RLock()
+	defer sp.settingsMu.RUnlock()
+	return sp.vmin, sp.vtime
+}
+
+// SetReadTimeout changes the read timeout given to Open, how long Read, FrameReader and
+// the other io.Reader views of the port wait for data, 0 for blocking reads. The data
+// being buffered by the reader thread whatever the timeout, the driver keeps the setting
+// it was opened with until the next open or Reconnect. A read already waiting keeps the
+// timeout it started with.
+func (sp *SerialPort) SetReadTimeout(timeout time.Duration) error {
+	if timeout < 0 {
+		return fmt.Errorf("Invalid read timeout %v", timeout)
+	}
+	sp.settingsMu.Lock()
+	sp.readTimeout = timeout
+	sp.settingsMu.Unlock()
+	return nil
+}
+
+// ReadTimeout returns the read timeout, 0 for blocking reads, see SetReadTimeout.
+func (sp *SerialPort) ReadTimeout() time.Duration {
+	sp.settingsMu.RLock()
+	defer sp.settingsMu.RUnlock()
+	return sp.readTimeout
+}
+
+// WithReadTimeout runs fn with the read timeout set to timeout, e.g. for a command slower
+// than the others, and restores the previous timeout afterwards, even if fn fails or
+// panics. It returns the error of fn.
+func (sp *SerialPort) WithReadTimeout(timeout time.Duration, fn func() error) error {
+	prev := sp.ReadTimeout()
+	if err := sp.SetReadTimeout(timeout); err != nil {
+		return err
+	}
+	defer sp.SetReadTimeout(prev)
+	return fn()
+}
+
+// ClearOnOpen enables discarding the data queued by the driver (e.g. boot chatter) when
+// the port is opened, right after it is configured. Disabled by default, so a banner
+// sent by the device on connect is received.
+func (sp *SerialPort) ClearOnOpen(enable bool) {
+	sp.settingsMu.Lock()
+	sp.clearOnOpen = enable
+	sp.settingsMu.Unlock()
+}
+
+// DTROnOpen sets whether DTR is asserted when the port is opened, the default. Many
+// boards (Arduino, ESP8266) reset on the DTR edge; with assert false, DTR is deasserted
+// right after the port is opened, before any data flows, and HUPCL is cleared so that
+// closing the port doesn't toggle the lines either. The open fails if the driver can't
+// control DTR.
+//
+// Platform limitations: on Linux, macOS and the BSDs the kernel raises DTR while opening the
+// port, before it can be changed, so a board may still see a short pulse when DTR was
+// low. On Windows the DTR control of the DCB is disabled, and whether DTR drops on close
+// is up to the driver.
+func (sp *SerialPort) DTROnOpen(assert bool) {
+	sp.settingsMu.Lock()
+	sp.holdDTR = !assert
+	sp.settingsMu.Unlock()
+}
+
+// SkipPreamble sets a fixed preamble the device sends right after the port is opened,
+// e.g. a sync word or a BOM, to be discarded by Open and Reconnect. They wait for it up to
+// the read timeout (forever in blocking mode) and consume it, so the first read gets the
+// data that follows. If the preamble doesn't arrive in time, or different data does, the
+// open fails with an error. A nil preamble disables it.
+func (sp *SerialPort) SkipPreamble(preamble []byte) {
+	sp.settingsMu.Lock()
+	sp.preamble = append([]byte(nil), preamble...)
+	sp.settingsMu.Unlock()
+}
+
+// SetReadWatchdog enables the read watchdog of the ports opened afterwards. If a read of
+// the port has not returned after interval, e.g. because of a wedged driver, the port is
+// forcibly closed to unblock it: ErrReadStuck is reported on the Errors channel and the
+// port is then handled as disconnected. Close also forcibly closes the port if it does
+// not complete within interval, and returns ErrCloseAbandoned if the driver still hasn't
+// returned after another interval.
+//
+// The watchdog is disabled by default (interval 0). As blocking reads legitimately wait
+// for data, it is meant to be used with a read timeout shorter than interval.
+func (sp *SerialPort) SetReadWatchdog(interval time.Duration) error {
+	if interval < 0 {
+		return fmt.Errorf("Invalid watchdog interval %v", interval)
+	}
+	sp.settingsMu.Lock()
+	sp.watchdog = interval
+	sp.settingsMu.Unlock()
+	return nil
+}
+
+// LazyLineProcessing skips the line processing of the ports opened afterwards until a
+// line handler is registered (OnLine, TailTo), the reader thread then writing the data
+// straight to the buffer. This saves a thread and a per-byte handoff for binary streams.
+// The first line seen by a handler registered later may be partial.
+func (sp *SerialPort) LazyLineProcessing(enable bool) {
+	sp.settingsMu.Lock()
+	sp.lazyLines = enable
+	sp.settingsMu.Unlock()
+}
+
+// NoConfigure makes the next Open share the device with another opener, typically a
+// sniffer observing the traffic of the controlling process: the port is opened read-only
+// and its settings (baud rate, read timeout, break handling...) are left untouched, as
+// is the data queued by the driver (ClearOnOpen is ignored).
+//
+// Caveats of multiple opens on the same tty: the openers share a single driver input
+// queue, so each received byte goes to only one of the readers, whichever reads first.
+// The line settings are shared too, so reads follow the timeout configured by the
+// primary opener, and any setting changed later on either side applies to both. Reads
+// returning at once, with VMIN and VTIME set to 0 by the primary opener, are retried with
+// a pause of up to 10 ms, the data being received that much later.
+// Windows doesn't allow a COM port to be opened twice.
+func (sp *SerialPort) NoConfigure(enable bool) {
+	sp.settingsMu.Lock()
+	sp.noConfigure = enable
+	sp.settingsMu.Unlock()
+}
+
+// SetFlowControl selects the flow control, see FlowControl. It is applied immediately if
+// the port is open, and on every Open.
+func (sp *SerialPort) SetFlowControl(flow FlowControl) error {
+	if flow < FlowNone || flow > FlowXONXOFF {
+		return fmt.Errorf("Invalid flow control %v", flow)
+	}
+	sp.settingsMu.Lock()
+	defer sp.settingsMu.Unlock()
+	if p, ok := sp.port.(*Port); ok && sp.portIsOpen.Load() {
+		if err := p.setFlowControl(flow, sp.xon, sp.xoff); err != nil {
+			return err
+		}
+	}
+	sp.flow = flow
+	return nil
+}
+
+// FlowControl returns the flow control of the port, see SetFlowControl.
+func (sp *SerialPort) FlowControl() FlowControl {
+	sp.settingsMu.RLock()
+	defer sp.settingsMu.RUnlock()
+	return sp.flow
+}
+
+// SetXONXOFFChars sets the characters of the software flow control, XONDefault and
+// XOFFDefault by default. They are applied with FlowXONXOFF, immediately if it is active.
+func (sp *SerialPort) SetXONXOFFChars(xon, xoff byte) error {
+	if xon == xoff {
+		return fmt.Errorf("Identical XON and XOFF characters 0x%02x", xon)
+	}
+	sp.settingsMu.Lock()
+	defer sp.settingsMu.Unlock()
+	if p, ok := sp.port.(*Port); ok && sp.portIsOpen.Load() && sp.flow == FlowXONXOFF {
+		if err := p.setFlowControl(sp.flow, xon, xoff); err != nil {
+			return err
+		}
+	}
+	sp.xon, sp.xoff = xon, xoff
+	return nil
+}
+
+// SetBreakHandling selects how BREAK conditions are received, see BreakHandling. It is
+// applied immediately if the port is open, and on every Open.
+func (sp *SerialPort) SetBreakHandling(mode BreakHandling) error {
+	if p, ok := sp.device().(*Port); ok && sp.portIsOpen.Load() {
+		if err := p.setBreakHandling(mode); err != nil {
+			return err
+		}
+	}
+	sp.handlersMu.Lock()
+	sp.breakMode = mode
+	sp.handlersMu.Unlock()
+	return nil
+}
+
+// SendBreak holds the line in the BREAK condition (continuous space) for d, e.g. to reset
+// a bootloader or frame a protocol. The data written before is transmitted first, and no
+// write gets in until the break is over. The break is set and cleared by the driver
+// (TIOCSBRK and TIOCCBRK on POSIX, SetCommBreak and ClearCommBreak on Windows) around a
+// sleep, so its duration is that of d to the scheduler granularity, rather than the
+// multiples of 250 ms or more of tcsendbreak. USB adapters may round it further.
+func (sp *SerialPort) SendBreak(d time.Duration) error {
+	if d <= 0 {
+		return fmt.Errorf("Invalid break duration %v", d)
+	}
+	sp.writeMu.Lock()
+	defer sp.writeMu.Unlock()
+	if !sp.portIsOpen.Load() {
+		return errNotOpen
+	}
+	p, ok := sp.device().(interface{ sendBreak(d time.Duration) error })
+	if !ok {
+		return fmt.Errorf("Break not supported on \"%s\"", sp.Name())
+	}
+	if len(sp.txBuff) > 0 {
+		n, err := sp.busWrite(sp.txBuff)
+		sp.txBuff = sp.txBuff[n:]
+		if err != nil {
+			return err
+		}
+	}
+	// Let the data written leave the line
+	time.Sleep(time.Until(sp.txEnd))
+	return p.sendBreak(d)
+}
+
+// SetWriteBuffering changes how written data is handed to the port, see WriteBuffering.
+// Switching back to Unbuffered sends any pending data.
+func (sp *SerialPort) SetWriteBuffering(mode WriteBuffering) error {
+	sp.writeMu.Lock()
+	sp.settingsMu.Lock()
+	sp.writeMode = mode
+	sp.settingsMu.Unlock()
+	sp.writeMu.Unlock()
+	if mode == Unbuffered {
+		return sp.FlushWrites()
+	}
+	return nil
+}
+
+// FlushWrites sends the data held by write buffering.
+func (sp *SerialPort) FlushWrites() error {
+	sp.writeMu.Lock()
+	defer sp.writeMu.Unlock()
+	if len(sp.txBuff) == 0 {
+		return nil
+	}
+	if !sp.portIsOpen.Load() {
+		return errNotOpen
+	}
+	n, err := sp.busWrite(sp.txBuff)
+	sp.txBuff = sp.txBuff[n:]
+	return err
+}
+
+// FlushInput discards the data received and not read yet, both queued by the driver
+// (tcflush TCIFLUSH on POSIX, PurgeComm on Windows) and buffered by sp, e.g. to drop the
+// stale bytes after a protocol error before re-synchronizing.
+func (sp *SerialPort) FlushInput() error {
+	if !sp.portIsOpen.Load() {
+		return errNotOpen
+	}
+	p, ok := sp.device().(interface{ flushInput() error })
+	if !ok {
+		return fmt.Errorf("Flush not supported on \"%s\"", sp.Name())
+	}
+	err := p.flushInput()
+	sp.buffMu.Lock()
+	sp.buff.Reset()
+	sp.buffMu.Unlock()
+	return err
+}
+
+// FlushOutput discards the data written and not transmitted yet, both held by write
+// buffering and queued by the driver (tcflush TCOFLUSH on POSIX, PurgeComm on Windows).
+// Unlike FlushWrites, which sends the data held, nothing more is sent.
+func (sp *SerialPort) FlushOutput() error {
+	sp.writeMu.Lock()
+	defer sp.writeMu.Unlock()
+	if !sp.portIsOpen.Load() {
+		return errNotOpen
+	}
+	p, ok := sp.device().(interface{ flushOutput() error })
+	if !ok {
+		return fmt.Errorf("Flush not supported on \"%s\"", sp.Name())
+	}
+	sp.txBuff = nil
+	if err := p.flushOutput(); err != nil {
+		return err
+	}
+	sp.txEnd = time.Now()
+	return nil
+}
+
+// Flush discards the data not read yet and the data not transmitted yet, see FlushInput
+// and FlushOutput.
+func (sp *SerialPort) Flush() error {
+	return errors.Join(sp.FlushOutput(), sp.FlushInput())
+}
+
+// ResetOptions selects the steps of ResetChannel, each one optional.
+type ResetOptions struct {
+	PulseDTR    time.Duration // deassert DTR for this long, then assert it again, 0 for no pulse
+	FlushDriver bool          // discard the data queued by the driver both ways (tcflush TCIOFLUSH)
+	ClearBuffer bool          // discard the data buffered, received or held by write buffering
+}
+
+// ResetChannel brings the port back to a clean baseline, e.g. after a protocol desync: it
+// pulses DTR to reset the device, discards the data queued by the driver and clears the
+// buffers of sp, as selected by opts, in that order. Every step selected is performed
+// even if a previous one fails, the errors being joined.
+func (sp *SerialPort) ResetChannel(opts ResetOptions) error {
+	if !sp.portIsOpen.Load() {
+		return errNotOpen
+	}
+	if opts.PulseDTR < 0 {
+		return fmt.Errorf("Invalid DTR pulse %v", opts.PulseDTR)
+	}
+	var errs []error
+	if opts.PulseDTR > 0 {
+		if err := sp.SetDTR(false); err != nil {
+			errs = append(errs, err)
+		} else {
+			time.Sleep(opts.PulseDTR)
+			if err := sp.SetDTR(true); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	if opts.FlushDriver {
+		if f, ok := sp.device().(interface{ Flush() error }); !ok {
+			errs = append(errs, fmt.Errorf("Flush not supported on \"%s\"", sp.Name()))
+		} else if err := f.Flush(); err != nil {
+			errs = append(errs, fmt.Errorf("Unable to flush port \"%s\" - %s", sp.Name(), err))
+		}
+	}
+	if opts.ClearBuffer {
+		sp.writeMu.Lock()
+		sp.txBuff = nil
+		sp.writeMu.Unlock()
+		sp.buffMu.Lock()
+		sp.buff.Reset()
+		sp.buffMu.Unlock()
+	}
+	return errors.Join(errs...)
+}
+
+// SetRTSTurnaround enables the direction control of half-duplex buses like RS-485 by
+// timing: RTS is asserted before each write of Write and the Print functions, and
+// deasserted once the data is computed to be transmitted, from the number of bytes, the
+// baud rate and the framing (see FrameDuration), plus margin. Unlike waiting on the
+// driver (tcdrain), whose granularity can be coarse at low baud rates, this gives a
+// deterministic turnaround. Writes then return after RTS is deasserted.
+func (sp *SerialPort) SetRTSTurnaround(enable bool, margin time.Duration) error {
+	if margin < 0 {
+		return fmt.Errorf("Invalid RTS margin %v", margin)
+	}
+	sp.writeMu.Lock()
+	defer sp.writeMu.Unlock()
+	if _, ok := sp.device().(interface{ setRTS(on bool) error }); enable && sp.portIsOpen.Load() && !ok {
+		return fmt.Errorf("RTS control not supported on \"%s\"", sp.Name())
+	}
+	sp.rtsTurnaround = enable
+	sp.rtsMargin = margin
+	return nil
+}
+
+// SetWriteChunking splits the data written by Write and the Print functions into port
+// writes of at most size bytes, to protect devices with a small input buffer. Each chunk
+// is written once the previous one is computed to be transmitted, from the baud rate and
+// the framing (see FrameDuration, e.g. 11 bits per byte for 8N2), plus delay: the OS
+// buffer doesn't fill up at low baud rates. A size of 0, the default, disables chunking.
+func (sp *SerialPort) SetWriteChunking(size int, delay time.Duration) error {
+	if size < 0 {
+		return fmt.Errorf("Invalid chunk size %v", size)
+	}
+	if delay < 0 {
+		return fmt.Errorf("Invalid chunk delay %v", delay)
+	}
+	sp.writeMu.Lock()
+	sp.settingsMu.Lock()
+	sp.chunkSize = size
+	sp.chunkDelay = delay
+	sp.settingsMu.Unlock()
+	sp.writeMu.Unlock()
+	return nil
+}
+
+// SetReadBufferSize sets the size of the reads of the reader thread, the most data a read
+// of the port returns at once. A size of 0, the default, selects IdealReadBufferSize for
+// the baud rate. It applies from the next Open.
+func (sp *SerialPort) SetReadBufferSize(size int) error {
+	if size < 0 {
+		return fmt.Errorf("Invalid read buffer size %v", size)
+	}
+	sp.settingsMu.Lock()
+	sp.readBufSize = size
+	sp.settingsMu.Unlock()
+	return nil
+}
+
+// IdealReadBufferSize suggests the size of the reads of a port at baud: the data received
+// in 10 ms, at 10 bits per byte, rounded up to a power of two between 256 bytes and 16 KiB.
+// A read returns what the driver holds without waiting for the buffer to fill, so a larger
+// buffer adds no latency, while it saves reads at high rates: 256 bytes up to 115200 baud,
+// 4 KiB at 3 Mbaud.
+func IdealReadBufferSize(baud int) int {
+	const min, max = 256, 16 << 10
+	want := baud / 10 / 100
+	size := min
+	for size < want && size < max {
+		size *= 2
+	}
+	return size
+}
+
+// SetSendFilePacing sets the delay between the chunks sent by SendFile and its variants,
+// 100 ms by default. With afterLast, the delay also follows the last chunk, e.g. for a
+// device that needs time to process the data before the next command; otherwise the
+// transfer returns as soon as the last chunk is written.
+func (sp *SerialPort) SetSendFilePacing(delay time.Duration, afterLast bool) error {
+	if delay < 0 {
+		return fmt.Errorf("Invalid chunk delay %v", delay)
+	}
+	sp.writeMu.Lock()
+	sp.fileDelay = delay
+	sp.fileDelayLast = afterLast
+	sp.writeMu.Unlock()
+	return nil
+}
+
+// SendFilePacer returns the delay before the next chunk of a file transfer, given the
+// offset reached in the file and its size, 0 for full speed.
+type SendFilePacer func(offset, size int) time.Duration
+
+// SetSendFilePacer sets a pacer consulted after each chunk sent by SendFile and its
+// variants for the delay before the next one, replacing the fixed delay of
+// SetSendFilePacing, whose afterLast still applies. It adapts the throttling to the
+// device during the transfer, e.g. slowing down while ModemStatus shows CTS deasserted by
+// a device falling behind. A nil pacer restores the fixed delay.
+//
+// With hardware (RTS/CTS) or software (XON/XOFF) flow control enabled in the driver, see
+// SetFlowControl, the writes of the chunks already block while the device holds the
+// data off, and the pacer delays add to that: it is meant for devices without flow
+// control, or that signal their state by other means.
+func (sp *SerialPort) SetSendFilePacer(pacer SendFilePacer) {
+	sp.writeMu.Lock()
+	sp.filePacer = pacer
+	sp.writeMu.Unlock()
+}
+
+// WriteAsync queues data to be written, and returns right away. The writes queued are
+// performed in order by a writer thread, like Write, which then calls onDone, if not nil,
+// with the result. Writes still queued when the port is closed complete with an error.
+// data is copied, so the caller can reuse it.
+func (sp *SerialPort) WriteAsync(data []byte, onDone func(n int, err error)) {
+	w := queuedWrite{data: append([]byte(nil), data...), onDone: onDone}
+	sp.handlersMu.Lock()
+	q := sp.writeQueue
+	if q == nil && sp.portIsOpen.Load() {
+		q = &writeQueue{signal: make(chan struct{}, 1)}
+		sp.writeQueue = q
+		done := sp.done
+		sp.goSessionThread(func() { sp.runWriteQueue(q, done) })
+	}
+	sp.handlersMu.Unlock()
+	if q == nil || !q.push(w) {
+		sp.completeWrite(w, 0, errNotOpen)
+	}
+}
+
+// SetWriteTimeout bounds the whole operation of WriteSlow, 0 for no bound.
+func (sp *SerialPort) SetWriteTimeout(timeout time.Duration) error {
+	if timeout < 0 {
+		return fmt.Errorf("Invalid write timeout %v", timeout)
+	}
+	sp.writeMu.Lock()
+	sp.writeTimeout = timeout
+	sp.writeMu.Unlock()
+	return nil
+}
+
+// WriteSlow writes data one byte at a time, leaving perByteDelay of idle line between the
+// bytes (after the transmission time of each byte, see FrameDuration), for receivers like
+// software UARTs that can't take back-to-back bytes. It fails with a
+// timeout error if the write timeout (see SetWriteTimeout) expires before the last byte
+// is written.
+func (sp *SerialPort) WriteSlow(data []byte, perByteDelay time.Duration) error {
+	sp.writeMu.Lock()
+	timeout := sp.writeTimeout
+	sp.writeMu.Unlock()
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	err := sp.WriteSlowContext(ctx, data, perByteDelay)
+	if err == context.DeadlineExceeded {
+		return fmt.Errorf("Timeout expired")
+	}
+	return err
+}
+
+// WriteSlowContext is WriteSlow stopping with ctx.Err() when ctx is done. The data
+// held by write buffering is sent first, and no other write gets in between the bytes.
+func (sp *SerialPort) WriteSlowContext(ctx context.Context, data []byte, perByteDelay time.Duration) error {
+	if perByteDelay < 0 {
+		return fmt.Errorf("Invalid byte delay %v", perByteDelay)
+	}
+	sp.writeMu.Lock()
+	defer sp.writeMu.Unlock()
+	if !sp.portIsOpen.Load() {
+		return errNotOpen
+	}
+	if len(sp.txBuff) > 0 {
+		n, err := sp.busWrite(sp.txBuff)
+		sp.txBuff = sp.txBuff[n:]
+		if err != nil {
+			return err
+		}
+	}
+	for i := range data {
+		if i > 0 {
+			select {
+			case <-time.After(time.Until(sp.txEnd.Add(perByteDelay))):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		} else if err := ctx.Err(); err != nil {
+			return err
+		}
+		if _, err := sp.timedWrite(data[i : i+1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Transaction calls fn with a writer to the port while holding the write lock, so that
+// the writes of fn are contiguous on the wire, no write of another goroutine getting in
+// between, e.g. for a command made of several writes. The writer follows the write
+// buffering mode like Write, and fails once fn has returned. fn must not call the write
+// functions of sp, which would deadlock. Transaction returns the error of fn.
+func (sp *SerialPort) Transaction(fn func(w io.Writer) error) error {
+	sp.writeMu.Lock()
+	defer sp.writeMu.Unlock()
+	if !sp.portIsOpen.Load() {
+		return errNotOpen
+	}
+	w := &txWriter{sp: sp}
+	defer w.ended.Store(true)
+	return fn(w)
+}
+
+// txWriter is the writer of Transaction, writing with the write lock already held.
+type txWriter struct {
+	sp    *SerialPort
+	ended atomic.Bool
+}
+
+func (w *txWriter) Write(data []byte) (int, error) {
+	if w.ended.Load() {
+		return 0, fmt.Errorf("Write after the end of the transaction")
+	}
+	if !w.sp.portIsOpen.Load() {
+		return 0, errNotOpen
+	}
+	return w.sp.bufferedWrite(data)
+}
+
+// WriteCompareEcho writes data on a link that echoes it, e.g. a half-duplex bus, and
+// reads the echo back, returning the index of its first byte differing from data, -1 if
+// they match: a simple check of the line quality during bring-up. No other write gets in
+// between, and only the echo is consumed, the data received before it staying readable.
+// The data held by write buffering is sent first, its echo being discarded. If the echo
+// is short, the index is -1 as long as the bytes echoed match, and the timeout error is
+// returned.
+func (sp *SerialPort) WriteCompareEcho(data []byte, timeout time.Duration) (mismatchIndex int, err error) {
+	sp.writeMu.Lock()
+	defer sp.writeMu.Unlock()
+	if !sp.portIsOpen.Load() {
+		return -1, errNotOpen
+	}
+	sp.buffMu.Lock()
+	start := sp.rxCount
+	sp.buffMu.Unlock()
+	pending := 0
+	if len(sp.txBuff) > 0 {
+		n, err := sp.busWrite(sp.txBuff)
+		sp.txBuff = sp.txBuff[n:]
+		pending = n
+		if err != nil {
+			return -1, err
+		}
+	}
+	if _, err := sp.busWrite(data); err != nil {
+		return -1, err
+	}
+	want := pending + len(data)
+	var echo []byte
+	err = sp.waitBuffer(timeout, func(buff *bytes.Buffer) bool {
+		if int(sp.rxCount-start) < want {
+			return false
+		}
+		echo = sp.takeEcho(start, want)
+		return true
+	})
+	if err != nil {
+		sp.buffMu.Lock()
+		echo = sp.takeEcho(start, want)
+		sp.buffMu.Unlock()
+	}
+	if len(echo) < pending {
+		return -1, err
+	}
+	for i, b := range echo[pending:] {
+		if b != data[i] {
+			return i, err
+		}
+	}
+	return -1, err
+}
+
+// AcquireSync waits up to timeout for preamble to appear in the received data, and
+// discards everything before it, leaving the preamble at the head of the buffer. After
+// it returns, reads are aligned on the frame starting with the preamble.
+func (sp *SerialPort) AcquireSync(preamble []byte, timeout time.Duration) error {
+	if len(preamble) == 0 {
+		return fmt.Errorf("Empty preamble")
+	}
+	return sp.waitBuffer(timeout, func(buff *bytes.Buffer) bool {
+		if i := bytes.Index(buff.Bytes(), preamble); i >= 0 {
+			buff.Next(i)
+			return true
+		}
+		// Only the last bytes can still be the start of the preamble
+		if n := buff.Len() - (len(preamble) - 1); n > 0 {
+			buff.Next(n)
+		}
+		return false
+	})
+}
+
+// OnLine registers a handler called from the reader thread with every line received,
+// without its line end. A nil handler removes the current one. A panic in the handler
+// is recovered and reported on the Errors channel, the port keeps running.
+func (sp *SerialPort) OnLine(handler func(line string)) {
+	sp.handlersMu.Lock()
+	sp.lineHandler = handler
+	if handler != nil {
+		sp.startProcessing()
+	}
+	sp.handlersMu.Unlock()
+}
+
+// TailTo writes every line received to w, like tail -f, until stop is called. Each line
+// keeps a newline end and, with withTimestamps, is prefixed by its reception time. The
+// lines are written from the reader thread, alongside the OnLine handler and without
+// consuming them. A write error is reported on the Errors channel and stops the tail.
+func (sp *SerialPort) TailTo(w io.Writer, withTimestamps bool) (stop func(), err error) {
+	if !sp.portIsOpen.Load() {
+		return nil, errNotOpen
+	}
+	if w == nil {
+		return nil, fmt.Errorf("Missing writer")
+	}
+	sp.handlersMu.Lock()
+	defer sp.handlersMu.Unlock()
+	if sp.lineTaps == nil {
+		sp.lineTaps = make(map[int]func(line string))
+	}
+	id := sp.nextLineTap
+	sp.nextLineTap++
+	sp.startProcessing()
+	var once sync.Once
+	stop = func() {
+		once.Do(func() {
+			sp.handlersMu.Lock()
+			delete(sp.lineTaps, id)
+			sp.handlersMu.Unlock()
+		})
+	}
+	sp.lineTaps[id] = func(line string) {
+		if withTimestamps {
+			line = time.Now().Format("2006-01-02 15:04:05.000 ") + line
+		}
+		if _, err := io.WriteString(w, line+"\n"); err != nil {
+			sp.reportError(fmt.Errorf("Tail write failed - %v", err))
+			stop()
+		}
+	}
+	return stop, nil
+}
+
+// DumpInput writes a hex dump of the received data to w as it arrives, in the format of
+// hexdump -C, without consuming it. A nil w stops the dump, flushing its last line.
+func (sp *SerialPort) DumpInput(w io.Writer) {
+	sp.handlersMu.Lock()
+	defer sp.handlersMu.Unlock()
+	if sp.dumper != nil {
+		sp.dumper.Close()
+		sp.dumper = nil
+		sp.inputTap = nil
+	}
+	if w == nil {
+		return
+	}
+	dumper := &inputDump{w: hex.Dumper(w)}
+	sp.dumper = dumper
+	sp.inputTap = func(data []byte) {
+		dumper.Write(data)
+	}
+}
+
+// inputDump is the hex dumper of DumpInput, called by the reader thread outside
+// handlersMu: it drops the data received once closed.
+type inputDump struct {
+	mu     sync.Mutex
+	w      io.WriteCloser
+	closed bool
+}
+
+func (d *inputDump) Write(data []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return 0, os.ErrClosed
+	}
+	return d.w.Write(data)
+}
+
+func (d *inputDump) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.closed = true
+	return d.w.Close()
+}
+
+// SetInputTransform sets a function decoding the received data before it is buffered and
+// seen by the line handlers and taps, e.g. to descramble the line. It is called by the
+// reader thread with the data of each read of the port, whose boundaries depend on the
+// timing of the reception: a codec working on groups of bytes must keep the bytes of an
+// incomplete group for the next call. A nil transform, the default, removes it.
+func (sp *SerialPort) SetInputTransform(transform func(data []byte) []byte) {
+	sp.handlersMu.Lock()
+	sp.inTransform = transform
+	sp.handlersMu.Unlock()
+}
+
+// SetOutputTransform sets a function encoding the data before it is written to the port,
+// by all the write functions. It is called with the data of each port write, e.g. each
+// chunk with SetWriteChunking, so it must encode them independently, see SetInputTransform.
+// The counts returned by the writes are of the data before the transform. A nil transform,
+// the default, removes it.
+func (sp *SerialPort) SetOutputTransform(transform func(data []byte) []byte) {
+	sp.handlersMu.Lock()
+	sp.outTransform = transform
+	sp.handlersMu.Unlock()
+}
+
+// SetReadErrorClassifier sets the function deciding what the reader thread does after a
+// read error, for platforms whose errors are unusual. EOF is not passed to it: reads
+// without data are part of the timeouts and the hangup detection. A nil classify
+// restores the default, which stops on the errors of a device gone away (ENODEV, ENXIO,
+// EIO) and retries on the others.
+func (sp *SerialPort) SetReadErrorClassifier(classify func(err error) ReadErrorAction) {
+	sp.handlersMu.Lock()
+	sp.classifyRead = classify
+	sp.handlersMu.Unlock()
+}
+
+// LiveThreads returns the number of goroutines started by sp that are still running: the
+// reader threads while the port is open, and the helpers of the calls in progress. Close
+// interrupts the pending read of the port, blocking reads of a tty included, and waits for
+// the reader threads to exit, so it drops to 0 once the port is closed, which makes leaks
+// visible.
+func (sp *SerialPort) LiveThreads() int {
+	return int(sp.threads.Load())
+}
+
+// LastError returns the error that disconnected the port, e.g. the EIO of a read once a
+// USB adapter is unplugged, ErrPortDisconnected for a hangup or ErrReadStuck for the read
+// watchdog. It is nil while the port works, and reset by Open and Reconnect. Unlike the
+// Errors channel, it can't be missed: it is kept until the next open.
+//
+// The reader thread stops and closes the device on such an error, so that it can be
+// opened again (see Reconnect); the data buffered stays readable.
+func (sp *SerialPort) LastError() error {
+	sp.buffMu.Lock()
+	defer sp.buffMu.Unlock()
+	return sp.lastErr
+}
+
+// Errors returns the channel on which errors raised by the reader threads are reported.
+// Errors are dropped when the channel is full, so the reader threads never block on it.
+func (sp *SerialPort) Errors() <-chan error {
+	return sp.errs
+}
+
+// SetBaud changes the baud rate of the open port in place (tcsetattr on POSIX,
+// SetCommState on Windows), e.g. after negotiating a faster speed in-band: the data
+// buffered and the control lines are kept. The data written before is transmitted at the
+// old rate first. See SetBaudPreserving for ports that can't be reconfigured in place.
+func (sp *SerialPort) SetBaud(baud int) error {
+	if baud <= 0 {
+		return fmt.Errorf("Invalid baud rate %v", baud)
+	}
+	sp.writeMu.Lock()
+	defer sp.writeMu.Unlock()
+	p, err := sp.sysPort()
+	if err != nil {
+		return err
+	}
+	// Let the data written leave the line
+	time.Sleep(time.Until(sp.txEnd))
+	if err := p.setBaud(baud); err != nil {
+		return err
+	}
+	sp.settingsMu.Lock()
+	sp.baud = baud
+	sp.settingsMu.Unlock()
+	return nil
+}
+
+// Name returns the name of the port given to Open, e.g. "/dev/ttyUSB0" or "COM3". It is
+// kept once the port is closed, for Reconnect.
+func (sp *SerialPort) Name() string {
+	sp.settingsMu.RLock()
+	defer sp.settingsMu.RUnlock()
+	return sp.name
+}
+
+// Baud returns the baud rate of the port.
+func (sp *SerialPort) Baud() int {
+	sp.settingsMu.RLock()
+	defer sp.settingsMu.RUnlock()
+	return sp.baud
+}
+
+// SetBaudPreserving changes the baud rate of the open port without losing the data
+// already received.
+//
+// Ports opened by this package change speed in place (tcsetattr on POSIX, SetCommState
+// on Windows). Ports that can't be reconfigured in place are opened again at the new rate
+// with all of their settings, as by Reconnect, once the data written has been sent. The
+// port stays open meanwhile: the data buffered at that point is kept and the new data is
+// appended to it.
+func (sp *SerialPort) SetBaudPreserving(baud int) error {
+	if baud <= 0 {
+		return fmt.Errorf("Invalid baud rate %v", baud)
+	}
+	if !sp.portIsOpen.Load() {
+		return errNotOpen
+	}
+	if _, ok := sp.device().(*Port); ok {
+		return sp.SetBaud(baud)
+	}
+	// Reopen path: the port is replaced under lifeMu as by Reconnect, opened again with all
+	// of its settings at the new rate. It stays open meanwhile and the buffer is untouched.
+	sp.lifeMu.Lock()
+	defer sp.lifeMu.Unlock()
+	if !sp.portIsOpen.Load() {
+		return errNotOpen
+	}
+	sp.writeMu.Lock()
+	// Send the data held by write buffering and let the data written leave the line at the
+	// old rate
+	if len(sp.txBuff) > 0 {
+		n, err := sp.busWrite(sp.txBuff)
+		sp.txBuff = sp.txBuff[n:]
+		if err != nil {
+			sp.writeMu.Unlock()
+			return err
+		}
+	}
+	time.Sleep(time.Until(sp.txEnd))
+	old, oldBaud := sp.device(), sp.Baud()
+	sp.settingsMu.Lock()
+	sp.baud = baud
+	sp.settingsMu.Unlock()
+	comPort, err := sp.reopen()
+	sp.settingsMu.Lock()
+	if err != nil {
+		sp.baud = oldBaud
+	} else {
+		// The writes go to the new port from now on
+		sp.port = comPort
+	}
+	sp.settingsMu.Unlock()
+	sp.writeMu.Unlock()
+	if err != nil {
+		return err
+	}
+	// Stop the threads of the old port, without writeMu which the writer thread may wait
+	// for, then start the ones of the new port
+	close(sp.done)
+	unblockPort(old)
+	old.Close()
+	sp.session.Wait()
+	sp.start(sp.Name(), baud, comPort)
+	return nil
+}
+
+// SaveState captures the current line settings (termios on POSIX, DCB on Windows) and
+// the modem control lines of the port, so they can be put back later with RestoreState.
+func (sp *SerialPort) SaveState() (State, error) {
+	p, err := sp.sysPort()
+	if err != nil {
+		return State{}, err
+	}
+	st, err := p.saveState()
+	if err != nil {
+		return State{}, err
+	}
+	return State{state: st, valid: true}, nil
+}
+
+// RestoreState applies a State previously returned by SaveState, leaving the port
+// exactly as it was when the snapshot was taken.
+func (sp *SerialPort) RestoreState(st State) error {
+	if !st.valid {
+		return fmt.Errorf("Invalid port state")
+	}
+	p, err := sp.sysPort()
+	if err != nil {
+		return err
+	}
+	return p.restoreState(st.state)
+}
+
+// SetHardwareLoopback enables or disables the internal loopback of the UART, in which the
+// transmitted data is received back without leaving the adapter. It is done by the driver
+// (TIOCM_LOOP on Linux) and returns ErrLoopbackUnsupported when the driver or the platform
+// doesn't support it. Use it to self-test an adapter without external wiring.
+func (sp *SerialPort) SetHardwareLoopback(enable bool) error {
+	p, err := sp.sysPort()
+	if err != nil {
+		return err
+	}
+	return p.setLoopback(enable)
+}
+
+// ChipType returns the chipset of the USB serial adapter of the port, derived from its
+// USB vendor ID: "FTDI", "CP210x", "CH340" or "PL2303". It is best effort: "unknown" is
+// returned when the chipset can't be determined (other chipsets, native UARTs, platforms
+// other than Linux, where sysfs is used).
+func (sp *SerialPort) ChipType() (string, error) {
+	if !sp.portIsOpen.Load() {
+		return "", errNotOpen
+	}
+	vid, _, err := usbIDs("/sys", sp.Name())
+	if err != nil {
+		return "unknown", nil
+	}
+	return chipName(vid), nil
+}
+
+// DebugTermios returns a human-readable report of the low-level settings of the open
+// port: the termios flags, speeds and VMIN/VTIME on POSIX, the DCB and timeouts on
+// Windows. It doesn't change any setting.
+func (sp *SerialPort) DebugTermios() (string, error) {
+	p, err := sp.sysPort()
+	if err != nil {
+		return "", err
+	}
+	return p.debugTermios()
+}
+
+/*******************************************************************************************
+******************************   PRIVATE FUNCTIONS  ****************************************
+*******************************************************************************************/
+
+// openSharedPort opens a port of the platform read-only, without configuring it.
+func openSharedPort(name string, baud int, readTimeout time.Duration) (io.ReadWriteCloser, error) {
+	p, err := openPortReadOnly(name)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// openSystemPort opens a port of the platform, it is the default openPort of a SerialPort.
+func openSystemPort(name string, baud int, readTimeout time.Duration) (io.ReadWriteCloser, error) {
+	p, err := openPort(name, baud, readTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// write hands data to the port according to the write buffering mode.
+func (sp *SerialPort) write(data []byte) (int, error) {
+	sp.writeMu.Lock()
+	defer sp.writeMu.Unlock()
+	return sp.bufferedWrite(data)
+}
+
+// bufferedWrite is write with writeMu held.
+func (sp *SerialPort) bufferedWrite(data []byte) (int, error) {
+	if sp.writeMode == Unbuffered {
+		return sp.busWrite(data)
+	}
+	sp.txBuff = append(sp.txBuff, data...)
+	// Send everything up to the last newline
+	if i := bytes.LastIndexByte(sp.txBuff, '\n'); i >= 0 {
+		n, err := sp.busWrite(sp.txBuff[:i+1])
+		sp.txBuff = sp.txBuff[n:]
+		if err != nil {
+			return len(data), err
+		}
+	}
+	return len(data), nil
+}
+
+// busWrite writes data with chunkedWrite, driving RTS around the transmission when the
+// RTS turnaround is enabled. It must be called with writeMu held.
+func (sp *SerialPort) busWrite(data []byte) (int, error) {
+	if !sp.rtsTurnaround {
+		return sp.chunkedWrite(data)
+	}
+	rts, ok := sp.device().(interface{ setRTS(on bool) error })
+	if !ok {
+		return 0, fmt.Errorf("RTS control not supported on \"%s\"", sp.Name())
+	}
+	if err := rts.setRTS(true); err != nil {
+		return 0, err
+	}
+	n, err := sp.chunkedWrite(data)
+	// Wait for the last stop bit to leave the line
+	time.Sleep(time.Until(sp.txEnd.Add(sp.rtsMargin)))
+	if rtsErr := rts.setRTS(false); err == nil {
+		err = rtsErr
+	}
+	return n, err
+}
+
+// chunkedWrite writes data to the port in chunks of at most chunkSize bytes, waiting
+// chunkDelay between them. It must be called with writeMu held.
+func (sp *SerialPort) chunkedWrite(data []byte) (int, error) {
+	if sp.chunkSize <= 0 {
+		return sp.timedWrite(data)
+	}
+	sent := 0
+	for sent < len(data) {
+		if sent > 0 {
+			// Let the previous chunk leave the line
+			time.Sleep(time.Until(sp.txEnd.Add(sp.chunkDelay)))
+		}
+		end := sent + sp.chunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+		n, err := sp.timedWrite(data[sent:end])
+		sent += n
+		if err != nil {
+			return sent, err
+		}
+	}
+	return sent, nil
+}
+
+// timedWrite writes data with portWrite and updates txEnd, the time at which the data
+// written is computed to be transmitted. It must be called with writeMu held.
+func (sp *SerialPort) timedWrite(data []byte) (int, error) {
+	start := time.Now()
+	if sp.txEnd.After(start) {
+		// Queued behind the data still being transmitted
+		start = sp.txEnd
+	}
+	n, err := sp.portWrite(data)
+	sp.txEnd = start.Add(sp.FrameDuration(n))
+	return n, err
+}
+
+// portWrite writes all of data to the port, failing fast once the port is disconnected.
+func (sp *SerialPort) portWrite(data []byte) (int, error) {
+	sp.buffMu.Lock()
+	disconnected := sp.disconnected
+	sp.buffMu.Unlock()
+	if disconnected {
+		return 0, ErrPortDisconnected
+	}
+	sp.handlersMu.Lock()
+	transform := sp.outTransform
+	sp.handlersMu.Unlock()
+	if transform == nil {
+		n, err := sp.writeFull(data)
+		if isDisconnectError(err) {
+			sp.markDisconnected(err)
+			return n, ErrPortDisconnected
+		}
+		return n, err
+	}
+	out := transform(data)
+	n, err := sp.writeFull(out)
+	// The count is of the data given, a partial write of a transform changing the length
+	// counting for nothing
+	switch {
+	case n == len(out):
+		n = len(data)
+	case len(out) != len(data):
+		n = 0
+	}
+	if isDisconnectError(err) {
+		sp.markDisconnected(err)
+		return n, ErrPortDisconnected
+	}
+	return n, err
+}
+
+// writeFull writes data to the port until it is all written, the short writes of a line
+// under flow control being continued, or until an error.
+func (sp *SerialPort) writeFull(data []byte) (int, error) {
+	written := 0
+	for written < len(data) {
+		n, err := sp.device().Write(data[written:])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		if n == 0 {
+			return written, io.ErrShortWrite
+		}
+	}
+	return written, nil
+}
+
+// markDisconnected records that the device has gone away, err telling why, and wakes up
+// the waiting reads.
+func (sp *SerialPort) markDisconnected(err error) {
+	sp.buffMu.Lock()
+	first := !sp.disconnected
+	if first {
+		sp.disconnected = true
+		sp.lastErr = err
+	}
+	sp.notifyRx()
+	sp.buffMu.Unlock()
+	if first {
+		sp.setState(StateErrored, err)
+		sp.startAutoReconnect()
+	}
+}
+
+// releasePort closes port once its device is gone, so that the device node is freed for
+// the device coming back (on Linux a USB adapter gets another ttyUSB number otherwise).
+// sp stays open until Close, which ignores the port closed already.
+func releasePort(port io.Reader) {
+	if c, ok := port.(io.Closer); ok {
+		c.Close()
+	}
+}
+
+// classifyReadError is the default classification of the read errors.
+func classifyReadError(err error) ReadErrorAction {
+	if isDisconnectError(err) {
+		return ReadStop
+	}
+	return ReadRetry
+}
+
+// isDisconnectError reports whether err means the device is no longer there.
+func isDisconnectError(err error) bool {
+	return errors.Is(err, syscall.ENODEV) || errors.Is(err, syscall.ENXIO) || errors.Is(err, syscall.EIO)
+}
+
+// start attaches an opened port to sp and launches the reader threads. The buffer is left
+// untouched.
+func (sp *SerialPort) start(name string, baud int, port io.ReadWriteCloser) {
+	sp.settingsMu.Lock()
+	sp.name = name
+	sp.baud = baud
+	sp.port = port
+	readTimeout := sp.readTimeout
+	sp.vmin, sp.vtime = posixTimeoutValues(readTimeout)
+	if p, ok := port.(interface{ timeoutValues() (uint8, uint8, error) }); ok {
+		if vmin, vtime, err := p.timeoutValues(); err == nil {
+			sp.vmin, sp.vtime = vmin, vtime
+		}
+	}
+	noConfigure, lazyLines, watchdog, size := sp.noConfigure, sp.lazyLines, sp.watchdog, sp.readBufSize
+	blocking := sp.vmin > 0
+	sp.settingsMu.Unlock()
+	sp.portIsOpen.Store(true)
+	sp.buffMu.Lock()
+	sp.disconnected = false
+	sp.lastErr = nil
+	sp.buffMu.Unlock()
+	// Open channels
+	sp.rxChar = make(chan byte)
+	sp.done = make(chan struct{})
+	watch := &readWatch{fired: make(chan struct{})}
+	// Enable threads, they only use the port and channels of this session
+	rxChar, done := sp.rxChar, sp.done
+	if size == 0 {
+		size = IdealReadBufferSize(baud)
+	}
+	// Blocking reads only return without data once the tty is hung up (e.g. the modem
+	// dropped carrier), they then keep returning EOF
+	detectHangup := blocking && !noConfigure
+	// The primary opener of a shared port may have set VMIN and VTIME to 0, the reads then
+	// return at once without data
+	sp.goSessionThread(func() { sp.readSerialPort(port, rxChar, done, watch, size, detectHangup, noConfigure) })
+	sp.handlersMu.Lock()
+	sp.processing = false
+	sp.writeQueue = nil
+	if !lazyLines || sp.lineHandler != nil || len(sp.lineTaps) > 0 {
+		sp.startProcessing()
+	}
+	sp.handlersMu.Unlock()
+	if watchdog > 0 {
+		sp.goSessionThread(func() { sp.watchReads(port, watchdog, done, watch) })
+	}
+	sp.setState(StateOpen, nil)
+}
+
+// startProcessing launches the processor thread of the current session if it is not
+// running. It must be called with handlersMu held.
+func (sp *SerialPort) startProcessing() {
+	if sp.processing || !sp.portIsOpen.Load() {
+		return
+	}
+	sp.processing = true
+	rxChar, done := sp.rxChar, sp.done
+	sp.goSessionThread(func() { sp.processSerialPort(rxChar, done) })
+}
+
+// goThread runs f in a new goroutine, accounted in LiveThreads.
+func (sp *SerialPort) goThread(f func()) {
+	sp.threads.Add(1)
+	go func() {
+		defer sp.threads.Add(-1)
+		f()
+	}()
+}
+
+// goSessionThread runs f in a new goroutine like goThread, Close waiting for it to exit.
+func (sp *SerialPort) goSessionThread(f func()) {
+	sp.session.Add(1)
+	sp.threads.Add(1)
+	go func() {
+		defer sp.session.Done()
+		defer sp.threads.Add(-1)
+		f()
+	}()
+}
+
+// queuedWrite is a write queued by WriteAsync.
+type queuedWrite struct {
+	data   []byte
+	onDone func(n int, err error)
+}
+
+// writeQueue holds the writes of WriteAsync waiting for the writer thread.
+type writeQueue struct {
+	mu     sync.Mutex
+	writes []queuedWrite
+	closed bool          // the writer thread has exited
+	signal chan struct{} // has a value when writes were queued
+}
+
+// push queues w, it fails once the writer thread has exited.
+func (q *writeQueue) push(w queuedWrite) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return false
+	}
+	q.writes = append(q.writes, w)
+	select {
+	case q.signal <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// take removes and returns the writes queued, closing q if close is set.
+func (q *writeQueue) take(close bool) []queuedWrite {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	writes := q.writes
+	q.writes = nil
+	q.closed = q.closed || close
+	return writes
+}
+
+// runWriteQueue is the writer thread of WriteAsync, it performs the queued writes until
+// done is closed.
+func (sp *SerialPort) runWriteQueue(q *writeQueue, done <-chan struct{}) {
+	for {
+		select {
+		case <-q.signal:
+			for _, w := range q.take(false) {
+				n, err := sp.write(w.data)
+				sp.completeWrite(w, n, err)
+			}
+		case <-done:
+			for _, w := range q.take(true) {
+				sp.completeWrite(w, 0, errNotOpen)
+			}
+			return
+		}
+	}
+}
+
+// completeWrite calls the callback of w, recovering from a panic in it.
+func (sp *SerialPort) completeWrite(w queuedWrite, n int, err error) {
+	if w.onDone == nil {
+		return
+	}
+	defer sp.recoverPanic("write callback")
+	w.onDone(n, err)
+}
+
+// readWatch tracks the reads of a session for the read watchdog.
+type readWatch struct {
+	started int64         // start of the pending read in Unix nanoseconds, 0 if none (atomic)
+	fired   chan struct{} // closed once the watchdog has closed the port
+}
+
+// watchReads closes port when a read has been pending for more than interval.
+func (sp *SerialPort) watchReads(port io.Closer, interval time.Duration, done <-chan struct{}, watch *readWatch) {
+	defer sp.recoverPanic("watchdog")
+	ticker := time.NewTicker(interval / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		started := atomic.LoadInt64(&watch.started)
+		if started == 0 || time.Since(time.Unix(0, started)) < interval {
+			continue
+		}
+		close(watch.fired)
+		sp.reportError(ErrReadStuck)
+		sp.markDisconnected(ErrReadStuck)
+		unblockPort(port)
+		port.Close()
+		return
+	}
+}
+
+// closePort closes the port of sp and waits for the threads of the session to exit,
+// forcibly if this does not complete within the watchdog interval, giving up after
+// another interval.
+func (sp *SerialPort) closePort() error {
+	sp.settingsMu.RLock()
+	noConfigure, watchdog := sp.noConfigure, sp.watchdog
+	sp.settingsMu.RUnlock()
+	if !noConfigure {
+		// Wake the pending read, the reader thread then sees the port closed
+		unblockPort(sp.device())
+	}
+	if watchdog <= 0 {
+		err := sp.device().Close()
+		sp.session.Wait()
+		return err
+	}
+	result := make(chan error, 1)
+	port := sp.device()
+	sp.threads.Add(1)
+	go func() {
+		err := port.Close()
+		sp.session.Wait()
+		sp.threads.Add(-1)
+		result <- err
+	}()
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(watchdog):
+	}
+	sp.reportError(ErrReadStuck)
+	unblockPort(sp.device())
+	// A driver stuck in close is left behind rather than hanging Close
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(watchdog):
+		return ErrCloseAbandoned
+	}
+}
+
+// unblockPort makes a pending read of port return, if port supports it.
+func unblockPort(port io.Closer) {
+	if p, ok := port.(interface{ unblock() error }); ok {
+		p.unblock()
+	}
+}
+
+func (sp *SerialPort) readSerialPort(port io.Reader, rxChar chan<- byte, done <-chan struct{}, watch *readWatch, size int, detectHangup bool, pollEmpty bool) {
+	defer sp.recoverPanic("reader")
+	rxBuff := make([]byte, size)
+	var marks parmrkDecoder
+	eofs := 0
+	// Backoff of the retries after a read error
+	const minBackoff, maxBackoff = time.Millisecond, 100 * time.Millisecond
+	backoff := minBackoff
+	// Pause between the empty reads with pollEmpty, from the second one in a row
+	const maxPoll = 10 * time.Millisecond
+	poll := time.Duration(0)
+	for {
+		atomic.StoreInt64(&watch.started, time.Now().UnixNano())
+		n, err := port.Read(rxBuff)
+		atomic.StoreInt64(&watch.started, 0)
+		data := rxBuff[:n]
+		processing := false
+		if n > 0 {
+			sp.handlersMu.Lock()
+			processing = sp.processing
+			breakMode, transform, tap := sp.breakMode, sp.inTransform, sp.inputTap
+			sp.handlersMu.Unlock()
+			if breakMode == BreakEvent {
+				data = marks.decode(data, func() {
+					sp.reportError(ErrBreak)
+				})
+			}
+			if transform != nil {
+				data = sp.callInTransform(transform, data)
+			}
+			if tap != nil {
+				sp.callInputTap(tap, data)
+			}
+		}
+		// Hand bytes to the processor before they become readable from the buffer
+		if processing {
+		handoff:
+			for _, b := range data {
+				select {
+				case rxChar <- b:
+				case <-done:
+					break handoff
+				}
+			}
+		}
+		// Write data to serial buffer
+		sp.buffMu.Lock()
+		sp.buff.Write(data)
+		sp.rxCount += uint64(len(data))
+		if over := sp.buff.Len() - sp.buffLimit; sp.buffLimit > 0 && over > 0 {
+			sp.buff.Next(over)
+			sp.dropped.Add(uint64(over))
+		}
+		if len(data) > 0 {
+			sp.lastRx = time.Now()
+			sp.notifyRx()
+		}
+		sp.buffMu.Unlock()
+		select {
+		case <-done:
+			return
+		case <-watch.fired:
+			return
+		default:
+		}
+		if n == 0 && err == io.EOF && detectHangup {
+			eofs++
+		} else {
+			eofs = 0
+		}
+		if eofs >= 3 {
+			sp.markDisconnected(ErrPortDisconnected)
+			sp.reportError(ErrPortDisconnected)
+			releasePort(port)
+			return
+		}
+		if n == 0 && err == io.EOF && pollEmpty {
+			if poll > 0 {
+				select {
+				case <-done:
+					return
+				case <-time.After(poll):
+				}
+			}
+			if poll = 2*poll + minBackoff; poll > maxPoll {
+				poll = maxPoll
+			}
+		} else {
+			poll = 0
+		}
+		if err == nil || err == io.EOF {
+			backoff = minBackoff
+			continue
+		}
+		sp.handlersMu.Lock()
+		classify := sp.classifyRead
+		sp.handlersMu.Unlock()
+		if classify == nil {
+			classify = classifyReadError
+		}
+		if classify(err) == ReadStop {
+			sp.reportError(err)
+			sp.markDisconnected(err)
+			sp.reportError(ErrPortDisconnected)
+			releasePort(port)
+			return
+		}
+		select {
+		case <-done:
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+func (sp *SerialPort) processSerialPort(rxChar <-chan byte, done <-chan struct{}) {
+	defer sp.recoverPanic("processor")
+	screenBuff := make([]byte, 0)
+	var lastRxByte byte
+	eol := sp.currentEOL()
+	for {
+		select {
+		case lastRxByte = <-rxChar:
+			if e := sp.currentEOL(); !bytes.Equal(e, eol) {
+				// EOL changed, re-frame the partial line
+				eol = e
+				for i := bytes.Index(screenBuff, eol); i >= 0; i = bytes.Index(screenBuff, eol) {
+					sp.handleLine(screenBuff[:i], eol)
+					screenBuff = screenBuff[i+len(eol):]
+				}
+			}
+			// Print received lines
+			screenBuff = append(screenBuff, lastRxByte)
+			if bytes.HasSuffix(screenBuff, eol) {
+				// EOL - Print received data
+				sp.handleLine(screenBuff[:len(screenBuff)-len(eol)], eol)
+				screenBuff = make([]byte, 0) //Clean buffer
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// currentEOL returns the end of line sequence.
+func (sp *SerialPort) currentEOL() []byte {
+	sp.buffMu.Lock()
+	defer sp.buffMu.Unlock()
+	return sp.eol
+}
+
+// handleLine calls the line handlers with a line received, without its EOL.
+func (sp *SerialPort) handleLine(raw []byte, eol []byte) {
+	sp.handlersMu.Lock()
+	handlers := make([]func(line string), 0, len(sp.lineTaps)+1)
+	if sp.lineHandler != nil {
+		handlers = append(handlers, sp.lineHandler)
+	}
+	for _, tap := range sp.lineTaps {
+		handlers = append(handlers, tap)
+	}
+	sp.handlersMu.Unlock()
+	line := trimEOL(raw, eol)
+	for _, handler := range handlers {
+		sp.callLineHandler(handler, line)
+	}
+}
+
+// parmrkDecoder removes the marks inserted by PARMRK in the received data, a mark being
+// split across reads.
+type parmrkDecoder struct {
+	state int // bytes of the current mark seen so far
+}
+
+// decode returns data without marks, calling onBreak for each BREAK mark (\377 \0 \0).
+// The character of a parity or framing error mark (\377 \0 x) is kept, and an escaped
+// \377 \377 is received as a single 0xFF.
+func (d *parmrkDecoder) decode(data []byte, onBreak func()) []byte {
+	out := make([]byte, 0, len(data)+1)
+	for _, b := range data {
+		switch d.state {
+		case 0:
+			if b == 0xFF {
+				d.state = 1
+			} else {
+				out = append(out, b)
+			}
+		case 1:
+			switch b {
+			case 0xFF:
+				out = append(out, 0xFF)
+				d.state = 0
+			case 0x00:
+				d.state = 2
+			default:
+				out = append(out, 0xFF, b)
+				d.state = 0
+			}
+		case 2:
+			if b == 0x00 {
+				onBreak()
+			} else {
+				out = append(out, b)
+			}
+			d.state = 0
+		}
+	}
+	return out
+}
+
+// notifyRx wakes up the goroutines waiting in waitBuffer. buffMu must be held.
+func (sp *SerialPort) notifyRx() {
+	close(sp.rxSignal)
+	sp.rxSignal = make(chan struct{})
+}
+
+// waitBuffer calls check with the buffer locked, then again every time data is buffered,
+// until check returns true or the timeout expires. It fails as soon as the port is closed.
+func (sp *SerialPort) waitBuffer(timeout time.Duration, check func(buff *bytes.Buffer) bool) error {
+	return sp.waitBufferContext(context.Background(), timeout, check)
+}
+
+// waitBufferContext is waitBuffer also stopping with ctx.Err() when ctx is done.
+func (sp *SerialPort) waitBufferContext(ctx context.Context, timeout time.Duration, check func(buff *bytes.Buffer) bool) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		sp.buffMu.Lock()
+		if check(sp.buff) {
+			sp.buffMu.Unlock()
+			return nil
+		}
+		signal := sp.rxSignal
+		disconnected := sp.disconnected
+		sp.buffMu.Unlock()
+		if !sp.portIsOpen.Load() {
+			return errNotOpen
+		}
+		if disconnected {
+			return ErrPortDisconnected
+		}
+		select {
+		case <-signal:
+		case <-timer.C:
+			return fmt.Errorf("Timeout expired")
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// readUntil waits up to timeout for delim to be received, and consumes and returns the
+// data up to and including it. Nothing is consumed on error.
+func (sp *SerialPort) readUntil(delim []byte, timeout time.Duration) ([]byte, error) {
+	var data []byte
+	err := sp.waitBuffer(timeout, func(buff *bytes.Buffer) bool {
+		i := bytes.Index(buff.Bytes(), delim)
+		if i < 0 {
+			return false
+		}
+		data = append([]byte(nil), buff.Next(i+len(delim))...)
+		return true
+	})
+	return data, err
+}
+
+// takeEcho removes from the buffer, and returns, up to n bytes of the data received since
+// the count of received bytes was start, leaving the data around them. Bytes of it read
+// by someone else are missing. It must be called with buffMu held.
+func (sp *SerialPort) takeEcho(start uint64, n int) []byte {
+	received := int(sp.rxCount - start)
+	pos := sp.buff.Len() - received
+	if pos < 0 {
+		n += pos
+		pos = 0
+	}
+	b := sp.buff.Bytes()
+	end := pos + n
+	if end > len(b) {
+		end = len(b)
+	} else if end < pos {
+		end = pos
+	}
+	echo := append([]byte(nil), b[pos:end]...)
+	copy(b[pos:], b[end:])
+	sp.buff.Truncate(len(b) - (end - pos))
+	return echo
+}
+
+// streamTimeout returns how long Read and the io.Reader views of the port wait for
+// data: the read timeout, or forever in blocking mode.
+func (sp *SerialPort) streamTimeout() time.Duration {
+	if timeout := sp.ReadTimeout(); timeout != 0 {
+		return timeout
+	}
+	return math.MaxInt64
+}
+
+// readByteTimeout waits up to timeout for a byte to be received and consumes it.
+func (sp *SerialPort) readByteTimeout(timeout time.Duration) (byte, error) {
+	var b byte
+	err := sp.waitBuffer(timeout, func(buff *bytes.Buffer) bool {
+		if buff.Len() == 0 {
+			return false
+		}
+		b, _ = buff.ReadByte()
+		return true
+	})
+	return b, err
+}
+
+// writeRaw writes data to the port right away, whatever the write buffering mode.
+func (sp *SerialPort) writeRaw(data []byte) error {
+	sp.writeMu.Lock()
+	defer sp.writeMu.Unlock()
+	_, err := sp.portWrite(data)
+	return err
+}
+
+// callLineHandler calls handler with line, recovering from a panic in the handler.
+func (sp *SerialPort) callLineHandler(handler func(line string), line string) {
+	defer sp.recoverPanic("line handler")
+	handler(line)
+}
+
+// callInTransform returns the data decoded by transform, or none if it panics, reporting
+// the panic so the reader thread keeps running.
+func (sp *SerialPort) callInTransform(transform func(data []byte) []byte, data []byte) (decoded []byte) {
+	defer sp.recoverPanic("input transform")
+	return transform(data)
+}
+
+// callInputTap calls tap with the data received, reporting a panic of it so the reader
+// thread keeps running.
+func (sp *SerialPort) callInputTap(tap func(data []byte), data []byte) {
+	defer sp.recoverPanic("input tap")
+	tap(data)
+}
+
+// recoverPanic recovers from a panic in the calling goroutine and reports it on the
+// Errors channel. It must be deferred.
+func (sp *SerialPort) recoverPanic(where string) {
+	if r := recover(); r != nil {
+		sp.reportError(fmt.Errorf("Panic in %s - %v", where, r))
+	}
+}
+
+// reportError sends err on the Errors channel, dropping it if the channel is full.
+func (sp *SerialPort) reportError(err error) {
+	select {
+	case sp.errs <- err:
+	default:
+	}
+}
+
+// sendFile writes the file from offset in chunks of q bytes, each one passed through
+// encode if not nil, adding the bytes written to sent and reporting the offset reached to
+// progress if not nil. The transfer stops between chunks as soon as done is closed.
+func (sp *SerialPort) sendFile(filepath string, offset int, q int, encode func([]byte) []byte, done <-chan struct{}, sent *int64, progress func(offset, size int)) error {
+	sp.writeMu.Lock()
+	delay, delayLast, pacer := sp.fileDelay, sp.fileDelayLast, sp.filePacer
+	sp.writeMu.Unlock()
+	// Read file
+	file, err := ioutil.ReadFile(filepath)
+	if err != nil {
+		return err
+	}
+	fileSize := len(file)
+	if offset < 0 || offset > fileSize {
+		return fmt.Errorf("Invalid offset %v for \"%s\" of %v bytes", offset, filepath, fileSize)
+	}
+	for sentBytes := offset; sentBytes < fileSize; {
+		//Try sending slices of less or equal than q bytes at time
+		end := sentBytes + q
+		if end > fileSize {
+			end = fileSize
+		}
+		data := file[sentBytes:end]
+		if encode != nil {
+			data = encode(data)
+		}
+		// Write binaries, all of the chunk or an error, through the write path of Write
+		// so that the chunks don't interleave with concurrent writes
+		sp.writeMu.Lock()
+		n, err := sp.busWrite(data)
+		sp.writeMu.Unlock()
+		atomic.AddInt64(sent, int64(n))
+		if err != nil {
+			return err
+		}
+		sentBytes = end
+		if progress != nil {
+			progress(sentBytes, fileSize)
+		}
+		if sentBytes == fileSize && !delayLast {
+			// No need to wait after the last chunk
+			break
+		}
+		if pacer != nil {
+			if delay = pacer(sentBytes, fileSize); delay < 0 {
+				delay = 0
+			}
+		}
+		select {
+		case <-done:
+		case <-time.After(delay):
+		}
+		// Checked again, done wins over a delay expired too, e.g. without pacing
+		select {
+		case <-done:
+			return fmt.Errorf("Transfer cancelled")
+		default:
+		}
+	}
+	return nil
+}
+
+// matchBuffLine looks for re in the first line of buff, whose lines end with eol, and
+// returns the submatches. On a match, the line is consumed up to the end of the match so
+// the data following it stays buffered; otherwise the whole line is discarded.
+func matchBuffLine(buff *bytes.Buffer, eol []byte, re *regexp.Regexp) ([]string, bool) {
+	raw := buff.Bytes()
+	i := bytes.Index(raw, eol)
+	if i < 0 {
+		return nil, false
+	}
+	end := i + len(eol)
+	line := raw[:i]
+	if len(eol) == 1 {
+		line = bytes.TrimRight(raw[:end], "\r\n")
+	}
+	loc := re.FindSubmatchIndex(line)
+	if loc == nil {
+		buff.Next(end)
+		return nil, false
+	}
+	match := make([]string, len(loc)/2)
+	for g := range match {
+		if loc[2*g] >= 0 {
+			match[g] = string(line[loc[2*g]:loc[2*g+1]])
+		}
+	}
+	if loc[1] == len(line) {
+		// Nothing but the line end follows the match
+		buff.Next(end)
+	} else {
+		buff.Next(loc[1])
+	}
+	return match, true
+}
+
+// sysPort returns the platform port backing sp.
+func (sp *SerialPort) sysPort() (*Port, error) {
+	if !sp.portIsOpen.Load() {
+		return nil, errNotOpen
+	}
+	p, ok := sp.device().(*Port)
+	if !ok {
+		return nil, fmt.Errorf("Operation not supported on \"%s\"", sp.Name())
+	}
+	return p, nil
+}
+
+// device returns the port backing sp, replaced by the reconnections.
+func (sp *SerialPort) device() io.ReadWriteCloser {
+	sp.settingsMu.RLock()
+	defer sp.settingsMu.RUnlock()
+	return sp.port
+}
+
+// chipName returns the chipset of the USB serial adapters of a vendor.
+func chipName(vid uint16) string {
+	switch vid {
+	case 0x0403:
+		return "FTDI"
+	case 0x10c4:
+		return "CP210x"
+	case 0x1a86:
+		return "CH340"
+	case 0x067b:
+		return "PL2303"
+	}
+	return "unknown"
+}
+
+// flagName associates a bit mask with its name, used to format termios flags.
+type flagName struct {
+	mask uint64
+	name string
+}
+
+// formatFlags formats v in hexadecimal followed by the names of the flags set in v.
+func formatFlags(v uint64, names []flagName) string {
+	str := fmt.Sprintf("%#08x", v)
+	for _, f := range names {
+		if v&f.mask == f.mask {
+			str += " " + f.name
+		}
+	}
+	return str
+}
+
+// nextLine consumes and returns, without its EOL, the first line of buff ending with
+// eol, if there is one.
+func nextLine(buff *bytes.Buffer, eol []byte) (string, bool) {
+	i := bytes.Index(buff.Bytes(), eol)
+	if i < 0 {
+		return "", false
+	}
+	return trimEOL(buff.Next(i+len(eol)), eol), true
+}
+
+// trimEOL returns line without its line end: every CR and LF for a single byte eol, as
+// removeEOL, or only the trailing eol sequence for a multi-byte one.
+func trimEOL(line []byte, eol []byte) string {
+	if len(eol) == 1 {
+		return removeEOL(string(line))
 	}
+	return string(bytes.TrimSuffix(line, eol))
 }
 
 func removeEOL(line string) string {
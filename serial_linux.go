@@ -3,50 +3,61 @@
 package serial
 
 import (
+	"fmt"
 	"os"
 	"syscall"
 	"time"
 	"unsafe"
 )
 
-func openPort(name string, baud int, readTimeout time.Duration) (p *Port, err error) {
-	var bauds = map[int]uint32{
-		50:      syscall.B50,
-		75:      syscall.B75,
-		110:     syscall.B110,
-		134:     syscall.B134,
-		150:     syscall.B150,
-		200:     syscall.B200,
-		300:     syscall.B300,
-		600:     syscall.B600,
-		1200:    syscall.B1200,
-		1800:    syscall.B1800,
-		2400:    syscall.B2400,
-		4800:    syscall.B4800,
-		9600:    syscall.B9600,
-		19200:   syscall.B19200,
-		38400:   syscall.B38400,
-		57600:   syscall.B57600,
-		115200:  syscall.B115200,
-		230400:  syscall.B230400,
-		460800:  syscall.B460800,
-		500000:  syscall.B500000,
-		576000:  syscall.B576000,
-		921600:  syscall.B921600,
-		1000000: syscall.B1000000,
-		1152000: syscall.B1152000,
-		1500000: syscall.B1500000,
-		2000000: syscall.B2000000,
-		2500000: syscall.B2500000,
-		3000000: syscall.B3000000,
-		3500000: syscall.B3500000,
-		4000000: syscall.B4000000,
-	}
+// Termios and ioctl constants missing from the syscall package
+const (
+	cbaud     = 0x100f
+	crtscts   = 0x80000000
+	tiocmLoop = 0x8000
+	cmspar    = 0x40000000
+	tcflsh    = 0x540B
+)
+
+// Termios speeds of the supported baud rates
+var bauds = map[int]uint32{
+	50:      syscall.B50,
+	75:      syscall.B75,
+	110:     syscall.B110,
+	134:     syscall.B134,
+	150:     syscall.B150,
+	200:     syscall.B200,
+	300:     syscall.B300,
+	600:     syscall.B600,
+	1200:    syscall.B1200,
+	1800:    syscall.B1800,
+	2400:    syscall.B2400,
+	4800:    syscall.B4800,
+	9600:    syscall.B9600,
+	19200:   syscall.B19200,
+	38400:   syscall.B38400,
+	57600:   syscall.B57600,
+	115200:  syscall.B115200,
+	230400:  syscall.B230400,
+	460800:  syscall.B460800,
+	500000:  syscall.B500000,
+	576000:  syscall.B576000,
+	921600:  syscall.B921600,
+	1000000: syscall.B1000000,
+	1152000: syscall.B1152000,
+	1500000: syscall.B1500000,
+	2000000: syscall.B2000000,
+	2500000: syscall.B2500000,
+	3000000: syscall.B3000000,
+	3500000: syscall.B3500000,
+	4000000: syscall.B4000000,
+}
 
+func openPort(name string, baud int, readTimeout time.Duration) (p *Port, err error) {
 	rate := bauds[baud]
 
 	if rate == 0 {
-		return
+		return nil, fmt.Errorf("Unknown baud rate %v", baud)
 	}
 
 	f, err := os.OpenFile(name, syscall.O_RDWR|syscall.O_NOCTTY|syscall.O_NONBLOCK, 0666)
@@ -60,7 +71,11 @@ func openPort(name string, baud int, readTimeout time.Duration) (p *Port, err er
 		}
 	}()
 
-	fd := f.Fd()
+	port, err := newPort(f, readTimeout)
+	if err != nil {
+		return nil, err
+	}
+	fd := port.fd
 	vmin, vtime := posixTimeoutValues(readTimeout)
 	t := syscall.Termios{
 		Iflag:  syscall.IGNPAR,
@@ -82,21 +97,20 @@ func openPort(name string, baud int, readTimeout time.Duration) (p *Port, err er
 		return nil, errno
 	}
 
-	if err = syscall.SetNonblock(int(fd), false); err != nil {
-		return
-	}
-
-	return &Port{f: f}, nil
+	return port, nil
 }
 
-type Port struct {
-	// We intentionly do not use an "embedded" struct so that we
-	// don't export File
-	f *os.File
-}
-
-func (p *Port) Read(b []byte) (n int, err error) {
-	return p.f.Read(b)
+// Opens the tty for reading only, without changing the settings of the other openers
+func openPortReadOnly(name string) (p *Port, err error) {
+	f, err := os.OpenFile(name, syscall.O_RDONLY|syscall.O_NOCTTY|syscall.O_NONBLOCK, 0666)
+	if err != nil {
+		return nil, err
+	}
+	if p, err = newPort(f, 0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return p, nil
 }
 
 func (p *Port) Write(b []byte) (n int, err error) {
@@ -106,16 +120,472 @@ func (p *Port) Write(b []byte) (n int, err error) {
 // Discards data written to the port but not transmitted,
 // or data received but not read
 func (p *Port) Flush() error {
-	const TCFLSH = 0x540B
-	_, _, err := syscall.Syscall(
-		syscall.SYS_IOCTL,
-		uintptr(p.f.Fd()),
-		uintptr(TCFLSH),
-		uintptr(syscall.TCIOFLUSH),
-	)
+	return ioctl(p.fd, tcflsh, syscall.TCIOFLUSH)
+}
+
+// Discards data received but not read
+func (p *Port) flushInput() error {
+	return ioctl(p.fd, tcflsh, syscall.TCIFLUSH)
+}
+
+// Discards data written to the port but not transmitted
+func (p *Port) flushOutput() error {
+	return ioctl(p.fd, tcflsh, syscall.TCOFLUSH)
+}
+
+// Low-level settings saved by saveState
+type portState struct {
+	termios  syscall.Termios
+	modem    int32
+	hasModem bool // false for devices without modem control lines (e.g. ptys)
+}
+
+func (p *Port) saveState() (s portState, err error) {
+	fd := p.fd
+	if err = ioctl(fd, syscall.TCGETS, uintptr(unsafe.Pointer(&s.termios))); err != nil {
+		return
+	}
+	err = ioctl(fd, syscall.TIOCMGET, uintptr(unsafe.Pointer(&s.modem)))
+	s.hasModem = err == nil
+	if err == syscall.ENOTTY || err == syscall.EINVAL {
+		err = nil
+	}
+	return
+}
+
+func (p *Port) restoreState(s portState) (err error) {
+	fd := p.fd
+	if err = ioctl(fd, syscall.TCSETS, uintptr(unsafe.Pointer(&s.termios))); err != nil {
+		return
+	}
+	if s.hasModem {
+		err = ioctl(fd, syscall.TIOCMSET, uintptr(unsafe.Pointer(&s.modem)))
+	}
+	return
+}
+
+// Returns the VMIN and VTIME values of the tty
+func (p *Port) timeoutValues() (vmin uint8, vtime uint8, err error) {
+	var t syscall.Termios
+	if err = ioctl(p.fd, syscall.TCGETS, uintptr(unsafe.Pointer(&t))); err != nil {
+		return
+	}
+	return t.Cc[syscall.VMIN], t.Cc[syscall.VTIME], nil
+}
+
+// Returns the baud rate of a CBAUD value, or the value itself if it is not known
+func speedName(speed uint32) string {
+	for baud, rate := range bauds {
+		if rate == speed {
+			return fmt.Sprint(baud)
+		}
+	}
+	return fmt.Sprintf("%#o", speed)
+}
+
+func (p *Port) debugTermios() (string, error) {
+	s, err := p.saveState()
+	if err != nil {
+		return "", err
+	}
+	t := &s.termios
+	var size string
+	switch t.Cflag & syscall.CSIZE {
+	case syscall.CS5:
+		size = "CS5"
+	case syscall.CS6:
+		size = "CS6"
+	case syscall.CS7:
+		size = "CS7"
+	default:
+		size = "CS8"
+	}
+	iflags := []flagName{
+		{syscall.IGNBRK, "IGNBRK"}, {syscall.BRKINT, "BRKINT"}, {syscall.IGNPAR, "IGNPAR"},
+		{syscall.PARMRK, "PARMRK"}, {syscall.INPCK, "INPCK"}, {syscall.ISTRIP, "ISTRIP"},
+		{syscall.INLCR, "INLCR"}, {syscall.IGNCR, "IGNCR"}, {syscall.ICRNL, "ICRNL"},
+		{syscall.IXON, "IXON"}, {syscall.IXOFF, "IXOFF"}, {syscall.IXANY, "IXANY"},
+	}
+	oflags := []flagName{{syscall.OPOST, "OPOST"}, {syscall.ONLCR, "ONLCR"}}
+	cflags := []flagName{
+		{syscall.CSTOPB, "CSTOPB"}, {syscall.CREAD, "CREAD"}, {syscall.PARENB, "PARENB"},
+		{syscall.PARODD, "PARODD"}, {syscall.HUPCL, "HUPCL"}, {syscall.CLOCAL, "CLOCAL"},
+		{crtscts, "CRTSCTS"},
+	}
+	lflags := []flagName{
+		{syscall.ISIG, "ISIG"}, {syscall.ICANON, "ICANON"}, {syscall.ECHO, "ECHO"},
+		{syscall.ECHOE, "ECHOE"}, {syscall.IEXTEN, "IEXTEN"},
+	}
+	report := fmt.Sprintf("c_iflag: %s\n", formatFlags(uint64(t.Iflag), iflags))
+	report += fmt.Sprintf("c_oflag: %s\n", formatFlags(uint64(t.Oflag), oflags))
+	report += fmt.Sprintf("c_cflag: %s %s\n", formatFlags(uint64(t.Cflag), cflags), size)
+	report += fmt.Sprintf("c_lflag: %s\n", formatFlags(uint64(t.Lflag), lflags))
+	// The input speed is in the CIBAUD bits, 0 for the output speed
+	ospeed, ispeed := t.Cflag&cbaud, t.Cflag>>16&cbaud
+	if ispeed == 0 {
+		ispeed = ospeed
+	}
+	report += fmt.Sprintf("ispeed: %s ospeed: %s\n", speedName(ispeed), speedName(ospeed))
+	report += fmt.Sprintf("VMIN: %v VTIME: %v\n", t.Cc[syscall.VMIN], t.Cc[syscall.VTIME])
+	if s.hasModem {
+		report += fmt.Sprintf("modem: %#04x", s.modem)
+	} else {
+		report += "modem: unavailable"
+	}
+	return report, nil
+}
+
+// Changes the line speed in place, data buffered by the driver is kept
+func (p *Port) setBaud(baud int) error {
+	rate := bauds[baud]
+	if rate == 0 {
+		return fmt.Errorf("Unknown baud rate %v", baud)
+	}
+	fd := p.fd
+	var t syscall.Termios
+	if err := ioctl(fd, syscall.TCGETS, uintptr(unsafe.Pointer(&t))); err != nil {
+		return err
+	}
+	t.Cflag = t.Cflag&^cbaud | rate
+	t.Ispeed = rate
+	t.Ospeed = rate
+	return ioctl(fd, syscall.TCSETS, uintptr(unsafe.Pointer(&t)))
+}
+
+// Changes the data bits, parity and stop bits in place
+func (p *Port) setFraming(dataBits int, parity Parity, stopBits StopBits) error {
+	sizes := map[int]uint32{5: syscall.CS5, 6: syscall.CS6, 7: syscall.CS7, 8: syscall.CS8}
+	size, ok := sizes[dataBits]
+	if !ok {
+		return fmt.Errorf("Invalid data bits %v", dataBits)
+	}
+	fd := p.fd
+	var t syscall.Termios
+	if err := ioctl(fd, syscall.TCGETS, uintptr(unsafe.Pointer(&t))); err != nil {
+		return err
+	}
+	t.Cflag &^= syscall.CSIZE | syscall.PARENB | syscall.PARODD | cmspar | syscall.CSTOPB
+	t.Cflag |= size
+	t.Iflag &^= syscall.INPCK
+	switch parity {
+	case ParityNone:
+	case ParityOdd:
+		t.Cflag |= syscall.PARENB | syscall.PARODD
+	case ParityEven:
+		t.Cflag |= syscall.PARENB
+	case ParityMark:
+		t.Cflag |= syscall.PARENB | cmspar | syscall.PARODD
+	case ParitySpace:
+		t.Cflag |= syscall.PARENB | cmspar
+	default:
+		return fmt.Errorf("Invalid parity %q", byte(parity))
+	}
+	if parity != ParityNone {
+		t.Iflag |= syscall.INPCK
+	}
+	switch stopBits {
+	case Stop1:
+	case Stop2:
+		t.Cflag |= syscall.CSTOPB
+	case Stop1Half:
+		// CSTOPB gives 1.5 stop bits with 5 data bits
+		if dataBits != 5 {
+			return fmt.Errorf("Stop bits 1.5 not supported with %v data bits", dataBits)
+		}
+		t.Cflag |= syscall.CSTOPB
+	default:
+		return fmt.Errorf("Stop bits %v not supported", stopBits)
+	}
+	return ioctl(fd, syscall.TCSETS, uintptr(unsafe.Pointer(&t)))
+}
+
+// Configures the hardware (CRTSCTS) or software (IXON/IXOFF) flow control
+func (p *Port) setFlowControl(flow FlowControl, xon, xoff byte) error {
+	fd := p.fd
+	var t syscall.Termios
+	if err := ioctl(fd, syscall.TCGETS, uintptr(unsafe.Pointer(&t))); err != nil {
+		return err
+	}
+	t.Cflag &^= crtscts
+	t.Iflag &^= syscall.IXON | syscall.IXOFF | syscall.IXANY
+	switch flow {
+	case FlowNone:
+	case FlowRTSCTS:
+		t.Cflag |= crtscts
+	case FlowXONXOFF:
+		t.Iflag |= syscall.IXON | syscall.IXOFF
+		t.Cc[syscall.VSTART] = xon
+		t.Cc[syscall.VSTOP] = xoff
+	default:
+		return fmt.Errorf("Invalid flow control %v", flow)
+	}
+	return ioctl(fd, syscall.TCSETS, uintptr(unsafe.Pointer(&t)))
+}
+
+// Applies the termios settings of OpenStty verbatim, and returns the baud rate they select
+func (p *Port) applyStty(st sttySettings) (int, error) {
+	var t syscall.Termios
+	if len(st.cc) > len(t.Cc) {
+		return 0, fmt.Errorf("%v control characters, at most %v supported", len(st.cc), len(t.Cc))
+	}
+	baud := 0
+	for b, rate := range bauds {
+		if rate == st.cflag&cbaud {
+			baud = b
+		}
+	}
+	if baud == 0 {
+		return 0, fmt.Errorf("Unknown speed %#x", st.cflag&cbaud)
+	}
+	copy(t.Cc[:], st.cc)
+	vmin, vtime := t.Cc[syscall.VMIN], t.Cc[syscall.VTIME]
+	if vmin == 0 && vtime == 0 {
+		return 0, fmt.Errorf("VMIN and VTIME 0 not supported - the reads would never wait")
+	}
+	t.Iflag, t.Oflag, t.Cflag, t.Lflag = st.iflag, st.oflag, st.cflag, st.lflag
+	t.Ispeed = st.cflag & cbaud
+	t.Ospeed = st.cflag & cbaud
+	fd := p.fd
+	if err := ioctl(fd, syscall.TCSETS, uintptr(unsafe.Pointer(&t))); err != nil {
+		return 0, err
+	}
+	var got syscall.Termios
+	if err := ioctl(fd, syscall.TCGETS, uintptr(unsafe.Pointer(&got))); err != nil {
+		return 0, err
+	}
+	if got.Iflag != t.Iflag || got.Oflag != t.Oflag || got.Cflag != t.Cflag || got.Lflag != t.Lflag {
+		return 0, fmt.Errorf("Flags not applied - set %x:%x:%x:%x, got %x:%x:%x:%x",
+			t.Iflag, t.Oflag, t.Cflag, t.Lflag, got.Iflag, got.Oflag, got.Cflag, got.Lflag)
+	}
+	for i, c := range st.cc {
+		if got.Cc[i] != c {
+			return 0, fmt.Errorf("Control character %v not applied - set %#x, got %#x", i, c, got.Cc[i])
+		}
+	}
+	p.emulateTimeouts(vmin, vtime)
+	return baud, nil
+}
+
+// Holds the line in the BREAK condition for d (TIOCSBRK / TIOCCBRK)
+func (p *Port) sendBreak(d time.Duration) error {
+	fd := p.fd
+	if err := ioctl(fd, syscall.TIOCSBRK, 0); err != nil {
+		return err
+	}
+	time.Sleep(d)
+	return ioctl(fd, syscall.TIOCCBRK, 0)
+}
+
+// Configures how BREAK conditions are received
+func (p *Port) setBreakHandling(mode BreakHandling) error {
+	fd := p.fd
+	var t syscall.Termios
+	if err := ioctl(fd, syscall.TCGETS, uintptr(unsafe.Pointer(&t))); err != nil {
+		return err
+	}
+	t.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK
+	switch mode {
+	case BreakIgnore:
+		t.Iflag |= syscall.IGNBRK
+	case BreakEvent:
+		t.Iflag |= syscall.PARMRK
+	}
+	return ioctl(fd, syscall.TCSETS, uintptr(unsafe.Pointer(&t)))
+}
+
+// Number of bytes received by the driver and not read yet
+func (p *Port) inputQueued() (int, error) {
+	var n int32
+	if err := ioctl(p.fd, syscall.TIOCINQ, uintptr(unsafe.Pointer(&n))); err != nil {
+		return 0, err
+	}
+	return int(n), nil
+}
+
+// Asserts or deasserts the Request To Send line
+func (p *Port) setRTS(on bool) error {
+	return p.setModemBit(syscall.TIOCM_RTS, on)
+}
+
+// Asserts or deasserts the Data Terminal Ready line
+func (p *Port) setDTR(on bool) error {
+	return p.setModemBit(syscall.TIOCM_DTR, on)
+}
+
+// Deasserts DTR and clears HUPCL, so that closing the port leaves the lines as they are
+func (p *Port) holdDTR() error {
+	fd := p.fd
+	var t syscall.Termios
+	if err := ioctl(fd, syscall.TCGETS, uintptr(unsafe.Pointer(&t))); err != nil {
+		return err
+	}
+	t.Cflag &^= syscall.HUPCL
+	if err := ioctl(fd, syscall.TCSETS, uintptr(unsafe.Pointer(&t))); err != nil {
+		return err
+	}
+	return p.setDTR(false)
+}
+
+// Sets or clears an output modem line alone (TIOCMBIS / TIOCMBIC)
+func (p *Port) setModemBit(bit int32, on bool) error {
+	req := uintptr(syscall.TIOCMBIC)
+	if on {
+		req = syscall.TIOCMBIS
+	}
+	return ioctl(p.fd, req, uintptr(unsafe.Pointer(&bit)))
+}
+
+// Reports whether the Data Carrier Detect line is asserted
+func (p *Port) carrierDetect() (bool, error) {
+	var bits int32
+	if err := ioctl(p.fd, syscall.TIOCMGET, uintptr(unsafe.Pointer(&bits))); err != nil {
+		return false, err
+	}
+	return bits&syscall.TIOCM_CAR != 0, nil
+}
+
+// Reports whether the Data Set Ready line is asserted
+func (p *Port) dataSetReady() (bool, error) {
+	var bits int32
+	if err := ioctl(p.fd, syscall.TIOCMGET, uintptr(unsafe.Pointer(&bits))); err != nil {
+		return false, err
+	}
+	return bits&syscall.TIOCM_DSR != 0, nil
+}
+
+// modemBits maps the modem control lines to their TIOCM bits
+var modemBits = map[ModemLine]int32{
+	LineCTS: syscall.TIOCM_CTS,
+	LineDSR: syscall.TIOCM_DSR,
+	LineDCD: syscall.TIOCM_CAR,
+	LineRI:  syscall.TIOCM_RNG,
+}
+
+// Returns the input modem control lines asserted
+func (p *Port) modemLines() (ModemLine, error) {
+	var bits int32
+	if err := ioctl(p.fd, syscall.TIOCMGET, uintptr(unsafe.Pointer(&bits))); err != nil {
+		return 0, err
+	}
+	var lines ModemLine
+	for line, bit := range modemBits {
+		if bits&bit != 0 {
+			lines |= line
+		}
+	}
+	return lines, nil
+}
+
+// Waits until one of the lines changes (TIOCMIWAIT)
+func (p *Port) waitModemChange(lines ModemLine) error {
+	var mask int32
+	for line, bit := range modemBits {
+		if lines&line != 0 {
+			mask |= bit
+		}
+	}
+	err := ioctl(p.fd, syscall.TIOCMIWAIT, uintptr(mask))
+	if err == syscall.ENOTTY || err == syscall.EINVAL {
+		return errModemWaitUnsupported
+	}
 	return err
 }
 
+// serialStruct is the serial_struct of linux/serial.h
+type serialStruct struct {
+	Type          int32
+	Line          int32
+	Port          uint32
+	Irq           int32
+	Flags         int32
+	XmitFifoSize  int32
+	CustomDivisor int32
+	BaudBase      int32
+	CloseDelay    uint16
+	IoType        int8
+	Reserved      int8
+	Hub6          int32
+	ClosingWait   uint16
+	ClosingWait2  uint16
+	IomemBase     uintptr
+	IomemRegShift uint16
+	PortHigh      uint32
+	IomapBase     uintptr
+}
+
+// Reads the serial_struct of the driver (TIOCGSERIAL)
+func (p *Port) serialInfo() (SerialInfo, error) {
+	var ss serialStruct
+	if err := ioctl(p.fd, syscall.TIOCGSERIAL, uintptr(unsafe.Pointer(&ss))); err != nil {
+		if err == syscall.ENOTTY || err == syscall.EINVAL {
+			return SerialInfo{}, ErrSerialInfoUnsupported
+		}
+		return SerialInfo{}, err
+	}
+	return SerialInfo{
+		Type:          int(ss.Type),
+		Line:          int(ss.Line),
+		Port:          uint(ss.Port),
+		IRQ:           int(ss.Irq),
+		Flags:         int(ss.Flags),
+		XmitFIFOSize:  int(ss.XmitFifoSize),
+		CustomDivisor: int(ss.CustomDivisor),
+		BaudBase:      int(ss.BaudBase),
+		CloseDelay:    int(ss.CloseDelay),
+		ClosingWait:   int(ss.ClosingWait),
+		IOType:        int(ss.IoType),
+		MemBase:       uint64(ss.IomemBase),
+	}, nil
+}
+
+// Enables the internal loopback of the UART (TIOCM_LOOP), where the driver supports it
+func (p *Port) setLoopback(enable bool) error {
+	fd := p.fd
+	var bits int32
+	if err := ioctl(fd, syscall.TIOCMGET, uintptr(unsafe.Pointer(&bits))); err != nil {
+		if err == syscall.ENOTTY || err == syscall.EINVAL {
+			return ErrLoopbackUnsupported
+		}
+		return err
+	}
+	if enable {
+		bits |= tiocmLoop
+	} else {
+		bits &^= tiocmLoop
+	}
+	if err := ioctl(fd, syscall.TIOCMSET, uintptr(unsafe.Pointer(&bits))); err != nil {
+		return err
+	}
+	// Drivers without loopback ignore the bit
+	if err := ioctl(fd, syscall.TIOCMGET, uintptr(unsafe.Pointer(&bits))); err != nil {
+		return err
+	}
+	if (bits&tiocmLoop != 0) != enable {
+		return ErrLoopbackUnsupported
+	}
+	return nil
+}
+
+// Makes a pending read return by switching the port to non-blocking reads, for the
+// files the runtime poller doesn't handle
+func (p *Port) unblockTermios() error {
+	fd := p.fd
+	var t syscall.Termios
+	if err := ioctl(fd, syscall.TCGETS, uintptr(unsafe.Pointer(&t))); err != nil {
+		return err
+	}
+	t.Cc[syscall.VMIN] = 0
+	t.Cc[syscall.VTIME] = 0
+	return ioctl(fd, syscall.TCSETS, uintptr(unsafe.Pointer(&t)))
+}
+
 func (p *Port) Close() (err error) {
 	return p.f.Close()
 }
+
+func ioctl(fd uintptr, req uintptr, arg uintptr) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, arg); errno != 0 {
+		return errno
+	}
+	return nil
+}
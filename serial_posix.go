@@ -2,8 +2,114 @@
 
 package serial
 
+// #include <errno.h>
 // #include <termios.h>
 // #include <unistd.h>
+// #include <sys/ioctl.h>
+//
+// static int get_modem_bits(int fd, int *bits) { return ioctl(fd, TIOCMGET, bits); }
+// static int set_modem_bits(int fd, int *bits) { return ioctl(fd, TIOCMSET, bits); }
+// static int set_modem_bit(int fd, int bit) { return ioctl(fd, TIOCMBIS, &bit); }
+// static int clear_modem_bit(int fd, int bit) { return ioctl(fd, TIOCMBIC, &bit); }
+// static int set_break(int fd) { return ioctl(fd, TIOCSBRK); }
+// static int clear_break(int fd) { return ioctl(fd, TIOCCBRK); }
+// static int get_input_queued(int fd, int *n) { return ioctl(fd, FIONREAD, n); }
+// static int wait_modem_change(int fd, int mask) {
+// #ifdef TIOCMIWAIT
+// 	return ioctl(fd, TIOCMIWAIT, mask);
+// #else
+// 	errno = ENOTTY;
+// 	return -1;
+// #endif
+// }
+//
+// static speed_t high_speed(int baud) {
+// 	switch (baud) {
+// #ifdef B230400
+// 	case 230400: return B230400;
+// #endif
+// #ifdef B460800
+// 	case 460800: return B460800;
+// #endif
+// #ifdef B500000
+// 	case 500000: return B500000;
+// #endif
+// #ifdef B576000
+// 	case 576000: return B576000;
+// #endif
+// #ifdef B921600
+// 	case 921600: return B921600;
+// #endif
+// #ifdef B1000000
+// 	case 1000000: return B1000000;
+// #endif
+// #ifdef B1152000
+// 	case 1152000: return B1152000;
+// #endif
+// #ifdef B1500000
+// 	case 1500000: return B1500000;
+// #endif
+// #ifdef B2000000
+// 	case 2000000: return B2000000;
+// #endif
+// #ifdef B2500000
+// 	case 2500000: return B2500000;
+// #endif
+// #ifdef B3000000
+// 	case 3000000: return B3000000;
+// #endif
+// #ifdef B3500000
+// 	case 3500000: return B3500000;
+// #endif
+// #ifdef B4000000
+// 	case 4000000: return B4000000;
+// #endif
+// 	}
+// 	return B0;
+// }
+//
+// #ifdef __linux__
+// #include <linux/serial.h>
+// #endif
+//
+// struct serial_info {
+// 	int type, line, irq, flags, xmit_fifo_size, custom_divisor, baud_base;
+// 	int close_delay, closing_wait, io_type;
+// 	unsigned int port;
+// 	unsigned long long mem_base;
+// };
+//
+// static int get_serial_info(int fd, struct serial_info *si) {
+// #ifdef TIOCGSERIAL
+// 	struct serial_struct ss;
+// 	if (ioctl(fd, TIOCGSERIAL, &ss) < 0) {
+// 		return -1;
+// 	}
+// 	si->type = ss.type;
+// 	si->line = ss.line;
+// 	si->port = ss.port;
+// 	si->irq = ss.irq;
+// 	si->flags = ss.flags;
+// 	si->xmit_fifo_size = ss.xmit_fifo_size;
+// 	si->custom_divisor = ss.custom_divisor;
+// 	si->baud_base = ss.baud_base;
+// 	si->close_delay = ss.close_delay;
+// 	si->closing_wait = ss.closing_wait;
+// 	si->io_type = ss.io_type;
+// 	si->mem_base = (unsigned long long)(unsigned long)ss.iomem_base;
+// 	return 0;
+// #else
+// 	errno = ENOTTY;
+// 	return -1;
+// #endif
+// }
+//
+// #ifndef TIOCM_LOOP
+// #define TIOCM_LOOP 0
+// #endif
+// #ifndef CMSPAR
+// #define CMSPAR 0
+// #endif
 import "C"
 
 // TODO: Maybe change to using syscall package + ioctl instead of cgo
@@ -23,7 +129,13 @@ func openPort(name string, baud int, readTimeout time.Duration) (p *Port, err er
 		return
 	}
 
-	fd := C.int(f.Fd())
+	// f is left non-blocking, see newPort
+	port, err := newPort(f, readTimeout)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	fd := C.int(port.fd)
 	if C.isatty(fd) != 1 {
 		f.Close()
 		return nil, errors.New("File is not a tty")
@@ -35,25 +147,10 @@ func openPort(name string, baud int, readTimeout time.Duration) (p *Port, err er
 		f.Close()
 		return nil, err
 	}
-	var speed C.speed_t
-	switch baud {
-	case 115200:
-		speed = C.B115200
-	case 57600:
-		speed = C.B57600
-	case 38400:
-		speed = C.B38400
-	case 19200:
-		speed = C.B19200
-	case 9600:
-		speed = C.B9600
-	case 4800:
-		speed = C.B4800
-	case 2400:
-		speed = C.B2400
-	default:
+	speed, err := baudSpeed(baud)
+	if err != nil {
 		f.Close()
-		return nil, fmt.Errorf("Unknown baud rate %v", baud)
+		return nil, err
 	}
 
 	_, err = C.cfsetispeed(&st, speed)
@@ -93,17 +190,6 @@ func openPort(name string, baud int, readTimeout time.Duration) (p *Port, err er
 		return nil, err
 	}
 
-	//fmt.Println("Tweaking", name)
-	r1, _, e := syscall.Syscall(syscall.SYS_FCNTL,
-		uintptr(f.Fd()),
-		uintptr(syscall.F_SETFL),
-		uintptr(0))
-	if e != 0 || r1 != 0 {
-		s := fmt.Sprint("Clearing NONBLOCK syscall error:", e, r1)
-		f.Close()
-		return nil, errors.New(s)
-	}
-
 	/*
 				r1, _, e = syscall.Syscall(syscall.SYS_IOCTL,
 			                uintptr(f.Fd()),
@@ -116,17 +202,84 @@ func openPort(name string, baud int, readTimeout time.Duration) (p *Port, err er
 				}
 	*/
 
-	return &Port{f: f}, nil
+	return port, nil
 }
 
-type Port struct {
-	// We intentionly do not use an "embedded" struct so that we
-	// don't export File
-	f *os.File
+// Opens the tty for reading only, without changing the settings of the other openers
+func openPortReadOnly(name string) (p *Port, err error) {
+	f, err := os.OpenFile(name, syscall.O_RDONLY|syscall.O_NOCTTY|syscall.O_NONBLOCK, 0666)
+	if err != nil {
+		return
+	}
+	if p, err = newPort(f, 0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if C.isatty(C.int(p.fd)) != 1 {
+		f.Close()
+		return nil, errors.New("File is not a tty")
+	}
+	return p, nil
 }
 
-func (p *Port) Read(b []byte) (n int, err error) {
-	return p.f.Read(b)
+// baudSpeed converts a baud rate to its termios speed
+func baudSpeed(baud int) (C.speed_t, error) {
+	switch baud {
+	case 115200:
+		return C.B115200, nil
+	case 57600:
+		return C.B57600, nil
+	case 38400:
+		return C.B38400, nil
+	case 19200:
+		return C.B19200, nil
+	case 9600:
+		return C.B9600, nil
+	case 4800:
+		return C.B4800, nil
+	case 2400:
+		return C.B2400, nil
+	case 1800:
+		return C.B1800, nil
+	case 1200:
+		return C.B1200, nil
+	case 600:
+		return C.B600, nil
+	case 300:
+		return C.B300, nil
+	case 200:
+		return C.B200, nil
+	case 150:
+		return C.B150, nil
+	case 134:
+		return C.B134, nil
+	case 110:
+		return C.B110, nil
+	case 75:
+		return C.B75, nil
+	case 50:
+		return C.B50, nil
+	}
+	// Rates above 115200, as far as the platform defines them
+	if speed := C.high_speed(C.int(baud)); speed != C.B0 {
+		return speed, nil
+	}
+	return 0, fmt.Errorf("Unknown baud rate %v", baud)
+}
+
+// Rates known by baudSpeed, as far as the platform defines them
+var posixRates = []int{50, 75, 110, 134, 150, 200, 300, 600, 1200, 1800, 2400, 4800, 9600, 19200, 38400,
+	57600, 115200, 230400, 460800, 500000, 576000, 921600, 1000000, 1152000, 1500000, 2000000, 2500000,
+	3000000, 3500000, 4000000}
+
+// Returns the baud rate of a speed_t value, or the value itself if it is not known
+func speedName(speed C.speed_t) string {
+	for _, baud := range posixRates {
+		if s, err := baudSpeed(baud); err == nil && s == speed {
+			return fmt.Sprint(baud)
+		}
+	}
+	return fmt.Sprintf("%#o", speed)
 }
 
 func (p *Port) Write(b []byte) (n int, err error) {
@@ -136,7 +289,450 @@ func (p *Port) Write(b []byte) (n int, err error) {
 // Discards data written to the port but not transmitted,
 // or data received but not read
 func (p *Port) Flush() error {
-	_, err := C.tcflush(C.int(p.f.Fd()), C.TCIOFLUSH)
+	_, err := C.tcflush(C.int(p.fd), C.TCIOFLUSH)
+	return err
+}
+
+// Discards data received but not read
+func (p *Port) flushInput() error {
+	_, err := C.tcflush(C.int(p.fd), C.TCIFLUSH)
+	return err
+}
+
+// Discards data written to the port but not transmitted
+func (p *Port) flushOutput() error {
+	_, err := C.tcflush(C.int(p.fd), C.TCOFLUSH)
+	return err
+}
+
+// Low-level settings saved by saveState
+type portState struct {
+	termios  C.struct_termios
+	modem    C.int
+	hasModem bool // false for devices without modem control lines (e.g. ptys)
+}
+
+func (p *Port) saveState() (s portState, err error) {
+	fd := C.int(p.fd)
+	if _, err = C.tcgetattr(fd, &s.termios); err != nil {
+		return
+	}
+	_, err = C.get_modem_bits(fd, &s.modem)
+	s.hasModem = err == nil
+	if err == syscall.ENOTTY || err == syscall.EINVAL {
+		err = nil
+	}
+	return
+}
+
+func (p *Port) restoreState(s portState) (err error) {
+	fd := C.int(p.fd)
+	if _, err = C.tcsetattr(fd, C.TCSANOW, &s.termios); err != nil {
+		return
+	}
+	if s.hasModem {
+		_, err = C.set_modem_bits(fd, &s.modem)
+	}
+	return
+}
+
+// Returns the VMIN and VTIME values of the tty
+func (p *Port) timeoutValues() (vmin uint8, vtime uint8, err error) {
+	var st C.struct_termios
+	if _, err = C.tcgetattr(C.int(p.fd), &st); err != nil {
+		return
+	}
+	return uint8(st.c_cc[C.VMIN]), uint8(st.c_cc[C.VTIME]), nil
+}
+
+func (p *Port) debugTermios() (string, error) {
+	s, err := p.saveState()
+	if err != nil {
+		return "", err
+	}
+	t := &s.termios
+	var size string
+	switch t.c_cflag & C.CSIZE {
+	case C.CS5:
+		size = "CS5"
+	case C.CS6:
+		size = "CS6"
+	case C.CS7:
+		size = "CS7"
+	default:
+		size = "CS8"
+	}
+	iflags := []flagName{
+		{uint64(C.IGNBRK), "IGNBRK"}, {uint64(C.BRKINT), "BRKINT"}, {uint64(C.IGNPAR), "IGNPAR"},
+		{uint64(C.PARMRK), "PARMRK"}, {uint64(C.INPCK), "INPCK"}, {uint64(C.ISTRIP), "ISTRIP"},
+		{uint64(C.INLCR), "INLCR"}, {uint64(C.IGNCR), "IGNCR"}, {uint64(C.ICRNL), "ICRNL"},
+		{uint64(C.IXON), "IXON"}, {uint64(C.IXOFF), "IXOFF"}, {uint64(C.IXANY), "IXANY"},
+	}
+	oflags := []flagName{{uint64(C.OPOST), "OPOST"}, {uint64(C.ONLCR), "ONLCR"}}
+	cflags := []flagName{
+		{uint64(C.CSTOPB), "CSTOPB"}, {uint64(C.CREAD), "CREAD"}, {uint64(C.PARENB), "PARENB"},
+		{uint64(C.PARODD), "PARODD"}, {uint64(C.HUPCL), "HUPCL"}, {uint64(C.CLOCAL), "CLOCAL"},
+		{uint64(C.CRTSCTS), "CRTSCTS"},
+	}
+	lflags := []flagName{
+		{uint64(C.ISIG), "ISIG"}, {uint64(C.ICANON), "ICANON"}, {uint64(C.ECHO), "ECHO"},
+		{uint64(C.ECHOE), "ECHOE"}, {uint64(C.IEXTEN), "IEXTEN"},
+	}
+	report := fmt.Sprintf("c_iflag: %s\n", formatFlags(uint64(t.c_iflag), iflags))
+	report += fmt.Sprintf("c_oflag: %s\n", formatFlags(uint64(t.c_oflag), oflags))
+	report += fmt.Sprintf("c_cflag: %s %s\n", formatFlags(uint64(t.c_cflag), cflags), size)
+	report += fmt.Sprintf("c_lflag: %s\n", formatFlags(uint64(t.c_lflag), lflags))
+	report += fmt.Sprintf("ispeed: %s ospeed: %s\n", speedName(C.cfgetispeed(t)), speedName(C.cfgetospeed(t)))
+	report += fmt.Sprintf("VMIN: %v VTIME: %v\n", t.c_cc[C.VMIN], t.c_cc[C.VTIME])
+	if s.hasModem {
+		report += fmt.Sprintf("modem: %#04x", s.modem)
+	} else {
+		report += "modem: unavailable"
+	}
+	return report, nil
+}
+
+// Changes the line speed in place, data buffered by the driver is kept
+func (p *Port) setBaud(baud int) error {
+	speed, err := baudSpeed(baud)
+	if err != nil {
+		return err
+	}
+	fd := C.int(p.fd)
+	var st C.struct_termios
+	if _, err = C.tcgetattr(fd, &st); err != nil {
+		return err
+	}
+	if _, err = C.cfsetispeed(&st, speed); err != nil {
+		return err
+	}
+	if _, err = C.cfsetospeed(&st, speed); err != nil {
+		return err
+	}
+	_, err = C.tcsetattr(fd, C.TCSANOW, &st)
+	return err
+}
+
+// Changes the data bits, parity and stop bits in place
+func (p *Port) setFraming(dataBits int, parity Parity, stopBits StopBits) error {
+	fd := C.int(p.fd)
+	var st C.struct_termios
+	if _, err := C.tcgetattr(fd, &st); err != nil {
+		return err
+	}
+	st.c_cflag &= ^C.tcflag_t(C.CSIZE | C.PARENB | C.PARODD | C.CMSPAR | C.CSTOPB)
+	st.c_iflag &= ^C.tcflag_t(C.INPCK)
+	switch dataBits {
+	case 5:
+		st.c_cflag |= C.CS5
+	case 6:
+		st.c_cflag |= C.CS6
+	case 7:
+		st.c_cflag |= C.CS7
+	case 8:
+		st.c_cflag |= C.CS8
+	default:
+		return fmt.Errorf("Invalid data bits %v", dataBits)
+	}
+	switch parity {
+	case ParityNone:
+	case ParityOdd:
+		st.c_cflag |= C.PARENB | C.PARODD
+	case ParityEven:
+		st.c_cflag |= C.PARENB
+	case ParityMark, ParitySpace:
+		if C.CMSPAR == 0 {
+			return fmt.Errorf("Parity %c not supported", parity)
+		}
+		st.c_cflag |= C.PARENB | C.CMSPAR
+		if parity == ParityMark {
+			st.c_cflag |= C.PARODD
+		}
+	default:
+		return fmt.Errorf("Invalid parity %q", byte(parity))
+	}
+	if parity != ParityNone {
+		st.c_iflag |= C.INPCK
+	}
+	switch stopBits {
+	case Stop1:
+	case Stop2:
+		st.c_cflag |= C.CSTOPB
+	case Stop1Half:
+		// CSTOPB gives 1.5 stop bits with 5 data bits
+		if dataBits != 5 {
+			return fmt.Errorf("Stop bits 1.5 not supported with %v data bits", dataBits)
+		}
+		st.c_cflag |= C.CSTOPB
+	default:
+		return fmt.Errorf("Stop bits %v not supported", stopBits)
+	}
+	_, err := C.tcsetattr(fd, C.TCSANOW, &st)
+	return err
+}
+
+// Configures the hardware (CRTSCTS) or software (IXON/IXOFF) flow control
+func (p *Port) setFlowControl(flow FlowControl, xon, xoff byte) error {
+	fd := C.int(p.fd)
+	var st C.struct_termios
+	if _, err := C.tcgetattr(fd, &st); err != nil {
+		return err
+	}
+	st.c_cflag &= ^C.tcflag_t(C.CRTSCTS)
+	st.c_iflag &= ^C.tcflag_t(C.IXON | C.IXOFF | C.IXANY)
+	switch flow {
+	case FlowNone:
+	case FlowRTSCTS:
+		st.c_cflag |= C.CRTSCTS
+	case FlowXONXOFF:
+		st.c_iflag |= C.IXON | C.IXOFF
+		st.c_cc[C.VSTART] = C.cc_t(xon)
+		st.c_cc[C.VSTOP] = C.cc_t(xoff)
+	default:
+		return fmt.Errorf("Invalid flow control %v", flow)
+	}
+	_, err := C.tcsetattr(fd, C.TCSANOW, &st)
+	return err
+}
+
+// Applies the termios settings of OpenStty verbatim, and returns the baud rate they select
+func (p *Port) applyStty(st sttySettings) (int, error) {
+	var t C.struct_termios
+	if len(st.cc) > len(t.c_cc) {
+		return 0, fmt.Errorf("%v control characters, at most %v supported", len(st.cc), len(t.c_cc))
+	}
+	fd := C.int(p.fd)
+	// Start from the current settings, for the fields stty -g doesn't tell
+	if _, err := C.tcgetattr(fd, &t); err != nil {
+		return 0, err
+	}
+	t.c_iflag, t.c_oflag = C.tcflag_t(st.iflag), C.tcflag_t(st.oflag)
+	t.c_cflag, t.c_lflag = C.tcflag_t(st.cflag), C.tcflag_t(st.lflag)
+	for i := range t.c_cc {
+		t.c_cc[i] = 0
+	}
+	for i, c := range st.cc {
+		t.c_cc[i] = C.cc_t(c)
+	}
+	vmin, vtime := uint8(t.c_cc[C.VMIN]), uint8(t.c_cc[C.VTIME])
+	if vmin == 0 && vtime == 0 {
+		return 0, fmt.Errorf("VMIN and VTIME 0 not supported - the reads would never wait")
+	}
+	if _, err := C.tcsetattr(fd, C.TCSANOW, &t); err != nil {
+		return 0, err
+	}
+	var got C.struct_termios
+	if _, err := C.tcgetattr(fd, &got); err != nil {
+		return 0, err
+	}
+	if got.c_iflag != t.c_iflag || got.c_oflag != t.c_oflag || got.c_cflag != t.c_cflag || got.c_lflag != t.c_lflag {
+		return 0, fmt.Errorf("Flags not applied - set %x:%x:%x:%x, got %x:%x:%x:%x",
+			t.c_iflag, t.c_oflag, t.c_cflag, t.c_lflag, got.c_iflag, got.c_oflag, got.c_cflag, got.c_lflag)
+	}
+	for i, c := range st.cc {
+		if got.c_cc[i] != C.cc_t(c) {
+			return 0, fmt.Errorf("Control character %v not applied - set %#x, got %#x", i, c, got.c_cc[i])
+		}
+	}
+	speed := C.cfgetospeed(&got)
+	for _, baud := range posixRates {
+		if s, err := baudSpeed(baud); err == nil && s == speed {
+			p.emulateTimeouts(vmin, vtime)
+			return baud, nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown speed %#x", speed)
+}
+
+// Holds the line in the BREAK condition for d (TIOCSBRK / TIOCCBRK)
+func (p *Port) sendBreak(d time.Duration) error {
+	fd := C.int(p.fd)
+	if _, err := C.set_break(fd); err != nil {
+		return err
+	}
+	time.Sleep(d)
+	_, err := C.clear_break(fd)
+	return err
+}
+
+// Configures how BREAK conditions are received
+func (p *Port) setBreakHandling(mode BreakHandling) error {
+	fd := C.int(p.fd)
+	var st C.struct_termios
+	if _, err := C.tcgetattr(fd, &st); err != nil {
+		return err
+	}
+	st.c_iflag &= ^C.tcflag_t(C.IGNBRK | C.BRKINT | C.PARMRK)
+	switch mode {
+	case BreakIgnore:
+		st.c_iflag |= C.IGNBRK
+	case BreakEvent:
+		st.c_iflag |= C.PARMRK
+	}
+	_, err := C.tcsetattr(fd, C.TCSANOW, &st)
+	return err
+}
+
+// Number of bytes received by the driver and not read yet
+func (p *Port) inputQueued() (int, error) {
+	var n C.int
+	if _, err := C.get_input_queued(C.int(p.fd), &n); err != nil {
+		return 0, err
+	}
+	return int(n), nil
+}
+
+// Asserts or deasserts the Request To Send line
+func (p *Port) setRTS(on bool) error {
+	return p.setModemBit(C.TIOCM_RTS, on)
+}
+
+// Asserts or deasserts the Data Terminal Ready line
+func (p *Port) setDTR(on bool) error {
+	return p.setModemBit(C.TIOCM_DTR, on)
+}
+
+// Deasserts DTR and clears HUPCL, so that closing the port leaves the lines as they are
+func (p *Port) holdDTR() error {
+	fd := C.int(p.fd)
+	var st C.struct_termios
+	if _, err := C.tcgetattr(fd, &st); err != nil {
+		return err
+	}
+	st.c_cflag &= ^C.tcflag_t(C.HUPCL)
+	if _, err := C.tcsetattr(fd, C.TCSANOW, &st); err != nil {
+		return err
+	}
+	return p.setDTR(false)
+}
+
+// Sets or clears an output modem line alone (TIOCMBIS / TIOCMBIC)
+func (p *Port) setModemBit(bit C.int, on bool) error {
+	fd := C.int(p.fd)
+	var err error
+	if on {
+		_, err = C.set_modem_bit(fd, bit)
+	} else {
+		_, err = C.clear_modem_bit(fd, bit)
+	}
+	return err
+}
+
+// Reports whether the Data Carrier Detect line is asserted
+func (p *Port) carrierDetect() (bool, error) {
+	var bits C.int
+	if _, err := C.get_modem_bits(C.int(p.fd), &bits); err != nil {
+		return false, err
+	}
+	return bits&C.TIOCM_CAR != 0, nil
+}
+
+// Reports whether the Data Set Ready line is asserted
+func (p *Port) dataSetReady() (bool, error) {
+	var bits C.int
+	if _, err := C.get_modem_bits(C.int(p.fd), &bits); err != nil {
+		return false, err
+	}
+	return bits&C.TIOCM_DSR != 0, nil
+}
+
+// Returns the input modem control lines asserted
+func (p *Port) modemLines() (ModemLine, error) {
+	var bits C.int
+	if _, err := C.get_modem_bits(C.int(p.fd), &bits); err != nil {
+		return 0, err
+	}
+	var lines ModemLine
+	for line, bit := range map[ModemLine]C.int{LineCTS: C.TIOCM_CTS, LineDSR: C.TIOCM_DSR, LineDCD: C.TIOCM_CAR, LineRI: C.TIOCM_RNG} {
+		if bits&bit != 0 {
+			lines |= line
+		}
+	}
+	return lines, nil
+}
+
+// Waits until one of the lines changes (TIOCMIWAIT, Linux only)
+func (p *Port) waitModemChange(lines ModemLine) error {
+	var mask C.int
+	for line, bit := range map[ModemLine]C.int{LineCTS: C.TIOCM_CTS, LineDSR: C.TIOCM_DSR, LineDCD: C.TIOCM_CAR, LineRI: C.TIOCM_RNG} {
+		if lines&line != 0 {
+			mask |= bit
+		}
+	}
+	_, err := C.wait_modem_change(C.int(p.fd), mask)
+	if err == syscall.ENOTTY || err == syscall.EINVAL {
+		return errModemWaitUnsupported
+	}
+	return err
+}
+
+// Reads the serial_struct of the driver (TIOCGSERIAL), Linux only
+func (p *Port) serialInfo() (SerialInfo, error) {
+	var si C.struct_serial_info
+	if _, err := C.get_serial_info(C.int(p.fd), &si); err != nil {
+		if err == syscall.ENOTTY || err == syscall.EINVAL {
+			return SerialInfo{}, ErrSerialInfoUnsupported
+		}
+		return SerialInfo{}, err
+	}
+	return SerialInfo{
+		Type:          int(si._type),
+		Line:          int(si.line),
+		Port:          uint(si.port),
+		IRQ:           int(si.irq),
+		Flags:         int(si.flags),
+		XmitFIFOSize:  int(si.xmit_fifo_size),
+		CustomDivisor: int(si.custom_divisor),
+		BaudBase:      int(si.baud_base),
+		CloseDelay:    int(si.close_delay),
+		ClosingWait:   int(si.closing_wait),
+		IOType:        int(si.io_type),
+		MemBase:       uint64(si.mem_base),
+	}, nil
+}
+
+// Enables the internal loopback of the UART (TIOCM_LOOP), where the driver supports it
+func (p *Port) setLoopback(enable bool) error {
+	if C.TIOCM_LOOP == 0 {
+		return ErrLoopbackUnsupported
+	}
+	fd := C.int(p.fd)
+	var bits C.int
+	if _, err := C.get_modem_bits(fd, &bits); err != nil {
+		if err == syscall.ENOTTY || err == syscall.EINVAL {
+			return ErrLoopbackUnsupported
+		}
+		return err
+	}
+	if enable {
+		bits |= C.TIOCM_LOOP
+	} else {
+		bits &^= C.TIOCM_LOOP
+	}
+	if _, err := C.set_modem_bits(fd, &bits); err != nil {
+		return err
+	}
+	// Drivers without loopback ignore the bit
+	if _, err := C.get_modem_bits(fd, &bits); err != nil {
+		return err
+	}
+	if (bits&C.TIOCM_LOOP != 0) != enable {
+		return ErrLoopbackUnsupported
+	}
+	return nil
+}
+
+// Makes a pending read return by switching the port to non-blocking reads, for the
+// files the runtime poller doesn't handle
+func (p *Port) unblockTermios() error {
+	fd := C.int(p.fd)
+	var st C.struct_termios
+	if _, err := C.tcgetattr(fd, &st); err != nil {
+		return err
+	}
+	st.c_cc[C.VMIN] = 0
+	st.c_cc[C.VTIME] = 0
+	_, err := C.tcsetattr(fd, C.TCSANOW, &st)
 	return err
 }
 
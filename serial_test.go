@@ -0,0 +1,2600 @@
+package serial
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// fakePort is an in-memory io.ReadWriteCloser standing in for a serial device.
+// Data written to dev is received by the port; data written by the port is
+// collected in tx.
+type fakePort struct {
+	rx  *io.PipeReader
+	dev *io.PipeWriter
+	mu  sync.Mutex
+	tx  bytes.Buffer
+	// writeErr, when set, is returned by Write
+	writeErr error
+	// onWrite, when set, is called with the data of each Write
+	onWrite func(b []byte)
+	rts     []rtsChange
+	dtr     []bool
+	breaks  []time.Duration
+	// readErrs are returned by the next reads, one each, before reading the pipe
+	readErrs []error
+	// maxWrite, when set, limits the bytes taken by each Write, like a line under flow control
+	maxWrite int
+	// modem holds the input modem lines, changes are signaled on modemChanges if not nil
+	modem        ModemLine
+	modemChanges chan struct{}
+	// queued is the number of bytes reported waiting in the driver
+	queued int
+}
+
+func (f *fakePort) inputQueued() (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.queued, nil
+}
+
+func (f *fakePort) modemLines() (ModemLine, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.modem, nil
+}
+
+func (f *fakePort) waitModemChange(lines ModemLine) error {
+	if f.modemChanges == nil {
+		return errModemWaitUnsupported
+	}
+	<-f.modemChanges
+	return nil
+}
+
+// setModem changes the input modem lines to lines.
+func (f *fakePort) setModem(lines ModemLine) {
+	f.mu.Lock()
+	f.modem = lines
+	f.mu.Unlock()
+	if f.modemChanges != nil {
+		f.modemChanges <- struct{}{}
+	}
+}
+
+type rtsChange struct {
+	on bool
+	at time.Time
+}
+
+func newFakePort() *fakePort {
+	r, w := io.Pipe()
+	return &fakePort{rx: r, dev: w}
+}
+
+func (f *fakePort) Read(b []byte) (int, error) {
+	f.mu.Lock()
+	if len(f.readErrs) > 0 {
+		err := f.readErrs[0]
+		f.readErrs = f.readErrs[1:]
+		f.mu.Unlock()
+		return 0, err
+	}
+	f.mu.Unlock()
+	return f.rx.Read(b)
+}
+
+func (f *fakePort) Write(b []byte) (int, error) {
+	f.mu.Lock()
+	if f.writeErr != nil {
+		f.mu.Unlock()
+		return 0, f.writeErr
+	}
+	if f.maxWrite > 0 && len(b) > f.maxWrite {
+		b = b[:f.maxWrite]
+	}
+	n, err := f.tx.Write(b)
+	onWrite := f.onWrite
+	f.mu.Unlock()
+	if onWrite != nil {
+		onWrite(append([]byte(nil), b...))
+	}
+	return n, err
+}
+
+func (f *fakePort) Close() error {
+	return f.rx.Close()
+}
+
+// setRTS records the RTS changes in rts, with their time.
+func (f *fakePort) setRTS(on bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rts = append(f.rts, rtsChange{on, time.Now()})
+	return nil
+}
+
+// setDTR records the DTR changes in dtr.
+func (f *fakePort) setDTR(on bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.dtr = append(f.dtr, on)
+	return nil
+}
+
+// sendBreak records the duration of the breaks in breaks, along with the data written
+// before them.
+func (f *fakePort) sendBreak(d time.Duration) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.breaks = append(f.breaks, d)
+	f.tx.WriteString("<break>")
+	return nil
+}
+
+// flushInput discards the data written to dev and not read by the reader thread yet,
+// which the fake has none of.
+func (f *fakePort) flushInput() error {
+	return nil
+}
+
+// flushOutput discards the data written, as if it wasn't transmitted.
+func (f *fakePort) flushOutput() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.tx.Reset()
+	return nil
+}
+
+// holdDTR records DTR deasserted, as DTROnOpen(false) does on open.
+func (f *fakePort) holdDTR() error {
+	return f.setDTR(false)
+}
+
+func (f *fakePort) written() []byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]byte(nil), f.tx.Bytes()...)
+}
+
+// openFake returns a SerialPort attached to a fakePort.
+func openFake(t testing.TB) (*SerialPort, *fakePort) {
+	t.Helper()
+	sp := New()
+	f := newFakePort()
+	sp.start("fake", 9600, f)
+	return sp, f
+}
+
+// waitAvailable waits until at least n bytes are buffered in sp.
+func waitAvailable(t *testing.T, sp *SerialPort, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for sp.Available() < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %v bytes available, got %v", n, sp.Available())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestReadLineIncomplete(t *testing.T) {
+	sp, f := openFake(t)
+	f.dev.Write([]byte("OK\r\npart"))
+	waitAvailable(t, sp, 8)
+
+	line, err := sp.ReadLine()
+	if err != nil || line != "OK" {
+		t.Fatalf("Expected \"OK\", got %q (%v)", line, err)
+	}
+	// The partial line stays buffered while the port is open
+	if line, err = sp.ReadLine(); err != io.EOF || line != "" {
+		t.Fatalf("Expected io.EOF, got %q (%v)", line, err)
+	}
+	if sp.Available() != 4 {
+		t.Fatalf("Expected partial line to be kept, %v bytes available", sp.Available())
+	}
+
+	sp.Close()
+	if line, err = sp.ReadLine(); err != ErrIncompleteLine || line != "part" {
+		t.Fatalf("Expected \"part\" with ErrIncompleteLine, got %q (%v)", line, err)
+	}
+	if _, err = sp.ReadLine(); err != errNotOpen {
+		t.Fatalf("Expected errNotOpen, got %v", err)
+	}
+}
+
+func TestWaitForRegexKeepsTail(t *testing.T) {
+	sp, f := openFake(t)
+	defer sp.Close()
+	f.dev.Write([]byte("boot\r\nlogin> ready\r\nnext\r\n"))
+
+	match, err := sp.WaitForRegexTimeout("login>", time.Second)
+	if err != nil || match != "login>" {
+		t.Fatalf("Expected \"login>\", got %q (%v)", match, err)
+	}
+	for _, exp := range []string{" ready", "next"} {
+		line, err := sp.ReadLine()
+		if err != nil || line != exp {
+			t.Fatalf("Expected %q, got %q (%v)", exp, line, err)
+		}
+	}
+}
+
+func TestWaitForRegexSubmatch(t *testing.T) {
+	sp, f := openFake(t)
+	defer sp.Close()
+	go func() {
+		f.dev.Write([]byte("boot\r\n"))
+		time.Sleep(10 * time.Millisecond)
+		f.dev.Write([]byte("TEMP=23.5 HUM=60\r\n"))
+	}()
+	m, err := sp.WaitForRegexSubmatchTimeout(`TEMP=([\d.]+) HUM=(\d+)( BAT=(\d+))?`, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"TEMP=23.5 HUM=60", "23.5", "60", "", ""}; strings.Join(m, "|") != strings.Join(want, "|") {
+		t.Fatalf("Expected %q, got %q", want, m)
+	}
+	if _, err := sp.WaitForRegexSubmatchTimeout(`TEMP=(\d+)`, 20*time.Millisecond); err == nil || err.Error() != "Timeout expired" {
+		t.Fatalf("Expected a timeout, got %v", err)
+	}
+	if _, err := sp.WaitForRegexSubmatchTimeout(`(`, time.Second); err == nil {
+		t.Fatal("Expected an invalid expression to be rejected")
+	}
+	sp.Close()
+	if _, err := sp.WaitForRegexSubmatchTimeout(`TEMP`, time.Second); err != errNotOpen {
+		t.Fatalf("Expected %v, got %v", errNotOpen, err)
+	}
+}
+
+func TestLineHandlerPanic(t *testing.T) {
+	sp, f := openFake(t)
+	defer sp.Close()
+	lines := make(chan string, 1)
+	sp.OnLine(func(line string) {
+		if line == "bad" {
+			panic("buggy handler")
+		}
+		lines <- line
+	})
+	f.dev.Write([]byte("bad\r\ngood\r\n"))
+
+	select {
+	case err := <-sp.Errors():
+		t.Log(err)
+	case <-time.After(time.Second):
+		t.Fatal("Expected the panic to be reported")
+	}
+	select {
+	case line := <-lines:
+		if line != "good" {
+			t.Fatalf("Expected \"good\", got %q", line)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the port to keep running after the panic")
+	}
+}
+
+func TestDTROnOpen(t *testing.T) {
+	sp := New()
+	var f *fakePort
+	sp.openPort = func(name string, baud int, readTimeout time.Duration) (io.ReadWriteCloser, error) {
+		f = newFakePort()
+		return f, nil
+	}
+	if err := sp.Open("fake", 9600); err != nil {
+		t.Fatal(err)
+	}
+	sp.Close()
+	if len(f.dtr) != 0 {
+		t.Fatalf("Expected DTR untouched by default, got %v", f.dtr)
+	}
+	sp.DTROnOpen(false)
+	for i := 0; i < 2; i++ {
+		// Open and Reconnect
+		var err error
+		if i == 0 {
+			err = sp.Open("fake", 9600)
+		} else {
+			err = sp.Reconnect()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(f.dtr) != "[false]" {
+			t.Fatalf("Expected DTR deasserted on open, got %v", f.dtr)
+		}
+	}
+	sp.Close()
+}
+
+func TestIsOpen(t *testing.T) {
+	sp := New()
+	if sp.IsOpen() {
+		t.Fatal("Expected a new port to be closed")
+	}
+	sp, f := openFake(t)
+	if !sp.IsOpen() {
+		t.Fatal("Expected the port to be open")
+	}
+	// Still open once disconnected, until closed
+	f.mu.Lock()
+	f.writeErr = &os.PathError{Op: "write", Path: "fake", Err: syscall.ENODEV}
+	f.mu.Unlock()
+	if _, err := sp.Write([]byte("AT")); err != ErrPortDisconnected {
+		t.Fatalf("Expected ErrPortDisconnected, got %v", err)
+	}
+	if !sp.IsOpen() {
+		t.Fatal("Expected a disconnected port to stay open")
+	}
+	sp.Close()
+	if sp.IsOpen() {
+		t.Fatal("Expected the port to be closed")
+	}
+}
+
+func TestOpenCloseLoop(t *testing.T) {
+	sp := New()
+	sp.OnLine(func(line string) {})
+	sp.openPort = func(name string, baud int, readTimeout time.Duration) (io.ReadWriteCloser, error) {
+		f := newFakePort()
+		// Data keeps arriving until the port is closed
+		go func() {
+			for {
+				if _, err := f.dev.Write([]byte("data\n")); err != nil {
+					return
+				}
+			}
+		}()
+		return f, nil
+	}
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			sp.ReadByte()
+			sp.Available()
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		if err := sp.Open("fake", 9600); err != nil {
+			t.Fatal(err)
+		}
+		// Concurrent closes, only one of them closes the port
+		closed := make(chan error, 1)
+		go func() { closed <- sp.Close() }()
+		if err := sp.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := <-closed; err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(stop)
+	wg.Wait()
+	if n := sp.LiveThreads(); n != 0 {
+		t.Fatalf("Expected no thread left, got %v", n)
+	}
+}
+
+func TestOpenEmptyBuffer(t *testing.T) {
+	sp := New()
+	if n := sp.Available(); n != 0 {
+		t.Fatalf("Expected no data before Open, got %v bytes", n)
+	}
+	sp.openPort = func(name string, baud int, readTimeout time.Duration) (io.ReadWriteCloser, error) {
+		return newFakePort(), nil
+	}
+	if err := sp.Open("fake", 9600); err != nil {
+		t.Fatal(err)
+	}
+	defer sp.Close()
+	if n := sp.Available(); n != 0 {
+		t.Fatalf("Expected no data after Open, got %v bytes", n)
+	}
+	if b, err := sp.ReadByte(); err != io.EOF {
+		t.Fatalf("Expected io.EOF, got %#02x (%v)", b, err)
+	}
+}
+
+func TestReadByteAfterClose(t *testing.T) {
+	sp, f := openFake(t)
+	f.dev.Write([]byte("ok"))
+	waitAvailable(t, sp, 2)
+	sp.Close()
+	for _, exp := range []byte("ok") {
+		if b, err := sp.ReadByte(); err != nil || b != exp {
+			t.Fatalf("Expected %q, got %q (%v)", exp, b, err)
+		}
+	}
+	if _, err := sp.ReadByte(); err != errNotOpen {
+		t.Fatalf("Expected errNotOpen once drained, got %v", err)
+	}
+}
+
+func TestBufferLimit(t *testing.T) {
+	sp := New()
+	var f *fakePort
+	sp.openPort = func(name string, baud int, readTimeout time.Duration) (io.ReadWriteCloser, error) {
+		f = newFakePort()
+		return f, nil
+	}
+	if err := sp.SetBufferLimit(-1); err == nil {
+		t.Fatal("Expected a negative limit to fail")
+	}
+	if err := sp.SetBufferLimit(8); err != nil {
+		t.Fatal(err)
+	}
+	if err := sp.Open("fake", 9600); err != nil {
+		t.Fatal(err)
+	}
+	defer sp.Close()
+	f.dev.Write([]byte("0123456789"))
+	f.dev.Write([]byte("ABCDEF"))
+	deadline := time.Now().Add(time.Second)
+	for sp.DroppedBytes() < 8 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected 8 bytes dropped, got %v", sp.DroppedBytes())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	// The oldest bytes are the ones discarded
+	data := make([]byte, 16)
+	if n, err := sp.Read(data); err != nil || string(data[:n]) != "89ABCDEF" {
+		t.Fatalf("Expected the newest bytes, got %q (%v)", data[:n], err)
+	}
+	if n := sp.DroppedBytes(); n != 8 {
+		t.Fatalf("Expected 8 bytes dropped, got %v", n)
+	}
+	// The count is reset by the next open
+	sp.Close()
+	if err := sp.Open("fake", 9600); err != nil {
+		t.Fatal(err)
+	}
+	if n := sp.DroppedBytes(); n != 0 {
+		t.Fatalf("Expected the count reset by Open, got %v", n)
+	}
+}
+
+func TestSetBaudPreservingReopen(t *testing.T) {
+	sp, f := openFake(t)
+	defer sp.Close()
+	var reopened *fakePort
+	sp.openPort = func(name string, baud int, readTimeout time.Duration) (io.ReadWriteCloser, error) {
+		reopened = newFakePort()
+		return reopened, nil
+	}
+	sp.DTROnOpen(false)
+	var states []PortState
+	var statesMu sync.Mutex
+	sp.SetStateHandler(func(old, new PortState, err error) {
+		statesMu.Lock()
+		states = append(states, new)
+		statesMu.Unlock()
+	})
+	sp.SetWriteBuffering(LineBuffered)
+	sp.Print("pending")
+	f.dev.Write([]byte("first\r\nsec"))
+	waitAvailable(t, sp, 10)
+
+	if err := sp.SetBaudPreserving(115200); err != nil {
+		t.Fatal(err)
+	}
+	if reopened == nil || sp.Baud() != 115200 || !sp.IsOpen() {
+		t.Fatal("Expected the port to be reopened at 115200")
+	}
+	if tx := string(f.written()); tx != "pending" {
+		t.Fatalf("Expected the pending write sent to the old port, got %q", tx)
+	}
+	if len(reopened.dtr) != 1 || reopened.dtr[0] {
+		t.Fatalf("Expected the reopened port configured like on open, got DTR %v", reopened.dtr)
+	}
+	time.Sleep(10 * time.Millisecond)
+	statesMu.Lock()
+	if len(states) != 0 {
+		t.Fatalf("Expected no state transition, got %v", states)
+	}
+	statesMu.Unlock()
+	reopened.dev.Write([]byte("ond\r\n"))
+	waitAvailable(t, sp, 15)
+	for _, exp := range []string{"first", "second"} {
+		line, err := sp.ReadLine()
+		if err != nil || line != exp {
+			t.Fatalf("Expected %q, got %q (%v)", exp, line, err)
+		}
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	sp := New()
+	var opened []*fakePort
+	var opens []string
+	sp.openPort = func(name string, baud int, readTimeout time.Duration) (io.ReadWriteCloser, error) {
+		opened = append(opened, newFakePort())
+		opens = append(opens, fmt.Sprintf("%s %d %v", name, baud, readTimeout))
+		return opened[len(opened)-1], nil
+	}
+	sp.EOL('\r')
+	sp.SetWriteChunking(2, 0)
+	if err := sp.Open("fake", 9600, 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	defer sp.Close()
+	lines := make(chan string, 4)
+	sp.OnLine(func(line string) { lines <- line })
+	ready := make(chan struct{}, 1)
+	sp.OnReconnect(func() { ready <- struct{}{} })
+	if err := sp.SetBaudPreserving(115200); err != nil {
+		t.Fatal(err)
+	}
+
+	// The device goes away
+	opened[1].dev.CloseWithError(&os.PathError{Op: "read", Path: "fake", Err: syscall.ENODEV})
+	for err := range sp.Errors() {
+		if err == ErrPortDisconnected {
+			break
+		}
+	}
+	if err := sp.Reconnect(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-ready:
+	default:
+		t.Fatal("Expected OnReconnect to be called")
+	}
+	if len(opens) != 3 || opens[2] != "fake 115200 50ms" {
+		t.Fatalf("Expected a reopen at 115200 with a 50ms timeout, got %v", opens)
+	}
+	dev := opened[2]
+	var sizes []int
+	dev.onWrite = func(b []byte) { sizes = append(sizes, len(b)) }
+	if _, err := sp.Write([]byte("ATZ\r")); err != nil {
+		t.Fatal(err)
+	}
+	if len(sizes) != 2 {
+		t.Fatalf("Expected the write chunking to be kept, got writes of %v", sizes)
+	}
+	dev.dev.Write([]byte("OK\r"))
+	select {
+	case line := <-lines:
+		if line != "OK" {
+			t.Fatalf("Expected \"OK\" split on the CR EOL, got %q", line)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the line handler to be kept")
+	}
+}
+
+func TestAutoReconnect(t *testing.T) {
+	sp := New()
+	var mu sync.Mutex
+	var opened []*fakePort
+	attempts := 0
+	sp.openPort = func(name string, baud int, readTimeout time.Duration) (io.ReadWriteCloser, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+		// The device node is back on the fourth attempt
+		if attempts == 2 || attempts == 3 {
+			return nil, &os.PathError{Op: "open", Path: name, Err: syscall.ENOENT}
+		}
+		opened = append(opened, newFakePort())
+		return opened[len(opened)-1], nil
+	}
+	device := func(i int) *fakePort {
+		mu.Lock()
+		defer mu.Unlock()
+		return opened[i]
+	}
+	if err := sp.SetAutoReconnect(5*time.Millisecond, 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	sp.EOL('\r')
+	if err := sp.Open("fake", 115200); err != nil {
+		t.Fatal(err)
+	}
+	defer sp.Close()
+	ready := make(chan struct{}, 1)
+	sp.OnReconnect(func() { ready <- struct{}{} })
+	device(0).dev.Write([]byte("before\r"))
+	waitAvailable(t, sp, 7)
+	// The settings and the port are read while the reconnection replaces them
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			sp.Name()
+			sp.Baud()
+			sp.Mode()
+			sp.ModemStatus()
+			time.Sleep(100 * time.Microsecond)
+		}
+	}()
+
+	// The adapter re-enumerates
+	device(0).dev.CloseWithError(&os.PathError{Op: "read", Path: "fake", Err: syscall.EIO})
+	for err := range sp.Errors() {
+		if err == ErrPortDisconnected {
+			break
+		}
+	}
+	if _, err := sp.Write([]byte("x")); err != ErrPortDisconnected && err != nil {
+		t.Fatalf("Expected ErrPortDisconnected while reconnecting, got %v", err)
+	}
+	select {
+	case <-ready:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the port to reconnect")
+	}
+	mu.Lock()
+	if attempts != 4 {
+		t.Errorf("Expected 2 failed attempts before the reconnection, got %v attempts", attempts)
+	}
+	mu.Unlock()
+	if !sp.IsOpen() || sp.Baud() != 115200 {
+		t.Fatalf("Expected the port open again at 115200, got %v at %v", sp.IsOpen(), sp.Baud())
+	}
+	// The data buffered and the EOL are kept
+	device(1).dev.Write([]byte("after\r"))
+	waitAvailable(t, sp, 13)
+	for _, exp := range []string{"before", "after"} {
+		if line, err := sp.ReadLine(); err != nil || line != exp {
+			t.Fatalf("Expected %q, got %q (%v)", exp, line, err)
+		}
+	}
+	if _, err := sp.Write([]byte("AT\r")); err != nil {
+		t.Fatal(err)
+	}
+	if got := device(1).written(); string(got) != "AT\r" {
+		t.Fatalf("Expected the write on the new device, got %q", got)
+	}
+}
+
+func TestAutoReconnectStoppedByClose(t *testing.T) {
+	sp := New()
+	var mu sync.Mutex
+	attempts := 0
+	sp.openPort = func(name string, baud int, readTimeout time.Duration) (io.ReadWriteCloser, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if attempts++; attempts > 1 {
+			return nil, &os.PathError{Op: "open", Path: name, Err: syscall.ENOENT}
+		}
+		f := newFakePort()
+		f.readErrs = []error{syscall.ENODEV}
+		return f, nil
+	}
+	sp.SetAutoReconnect(time.Millisecond, time.Millisecond)
+	if err := sp.Open("fake", 9600); err != nil {
+		t.Fatal(err)
+	}
+	// Let the attempts fail a few times
+	deadline := time.Now().Add(time.Second)
+	for {
+		mu.Lock()
+		n := attempts
+		mu.Unlock()
+		if n >= 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the port to be reopened, got %v attempts", n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := sp.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for sp.LiveThreads() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the auto-reconnect to stop, %v threads left", sp.LiveThreads())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	mu.Lock()
+	n := attempts
+	mu.Unlock()
+	time.Sleep(10 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	if attempts != n || sp.IsOpen() {
+		t.Fatalf("Expected no attempt after Close, got %v more", attempts-n)
+	}
+}
+
+func TestSkipPreamble(t *testing.T) {
+	sp := New()
+	var f *fakePort
+	banner := "\xef\xbb\xbfhello\n"
+	sp.openPort = func(name string, baud int, readTimeout time.Duration) (io.ReadWriteCloser, error) {
+		f = newFakePort()
+		go f.dev.Write([]byte(banner))
+		return f, nil
+	}
+	sp.SkipPreamble([]byte("\xef\xbb\xbf"))
+	if err := sp.Open("fake", 9600, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	waitAvailable(t, sp, 6)
+	if line, err := sp.ReadLine(); err != nil || line != "hello" {
+		t.Fatalf("Expected \"hello\" without the BOM, got %q (%v)", line, err)
+	}
+	sp.Close()
+
+	banner = "hello\n"
+	if err := sp.Open("fake", 9600, time.Second); err == nil {
+		t.Fatal("Expected a missing preamble to fail the open")
+	}
+	if sp.portIsOpen.Load() {
+		t.Fatal("Expected the port to be closed")
+	}
+}
+
+func TestLineBufferedWrites(t *testing.T) {
+	sp, f := openFake(t)
+	defer sp.Close()
+	sp.SetWriteBuffering(LineBuffered)
+
+	sp.Print("abc")
+	if tx := f.written(); len(tx) != 0 {
+		t.Fatalf("Expected nothing sent before the newline, got %q", tx)
+	}
+	sp.Print("d\nef")
+	if tx := string(f.written()); tx != "abcd\n" {
+		t.Fatalf("Expected \"abcd\\n\" sent, got %q", tx)
+	}
+	if err := sp.FlushWrites(); err != nil {
+		t.Fatal(err)
+	}
+	if tx := string(f.written()); tx != "abcd\nef" {
+		t.Fatalf("Expected \"abcd\\nef\" sent, got %q", tx)
+	}
+}
+
+func TestWriteChunking(t *testing.T) {
+	sp, f := openFake(t)
+	defer sp.Close()
+	var sizes []int
+	f.onWrite = func(b []byte) { sizes = append(sizes, len(b)) }
+	sp.SetWriteChunking(4, 5*time.Millisecond)
+
+	start := time.Now()
+	if n, err := sp.Write([]byte("0123456789")); err != nil || n != 10 {
+		t.Fatalf("Expected 10 bytes written, got %v (%v)", n, err)
+	}
+	if elapsed := time.Since(start); elapsed < 10*time.Millisecond {
+		t.Fatalf("Expected the chunks to be paced, took %v", elapsed)
+	}
+	if len(sizes) != 3 || sizes[0] != 4 || sizes[1] != 4 || sizes[2] != 2 {
+		t.Fatalf("Expected writes of 4, 4 and 2 bytes, got %v", sizes)
+	}
+	if tx := string(f.written()); tx != "0123456789" {
+		t.Fatalf("Expected \"0123456789\" sent, got %q", tx)
+	}
+}
+
+func TestWriteSlow(t *testing.T) {
+	sp, f := openFake(t)
+	defer sp.Close()
+	var sizes []int
+	f.onWrite = func(b []byte) { sizes = append(sizes, len(b)) }
+
+	start := time.Now()
+	if err := sp.WriteSlow([]byte("abcd"), 5*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 15*time.Millisecond {
+		t.Fatalf("Expected the bytes to be paced, took %v", elapsed)
+	}
+	if len(sizes) != 4 || string(f.written()) != "abcd" {
+		t.Fatalf("Expected 4 single byte writes of \"abcd\", got %v", sizes)
+	}
+
+	sp.SetWriteTimeout(20 * time.Millisecond)
+	if err := sp.WriteSlow(make([]byte, 10), 10*time.Millisecond); err == nil || err.Error() != "Timeout expired" {
+		t.Fatalf("Expected a timeout, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := sp.WriteSlowContext(ctx, []byte("x"), 0); err != context.Canceled {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+}
+
+func TestWriteAsync(t *testing.T) {
+	sp, f := openFake(t)
+	results := make(chan string, 10)
+	for i := 0; i < 10; i++ {
+		frame := fmt.Sprintf("<%d>", i)
+		sp.WriteAsync([]byte(frame), func(n int, err error) {
+			if err != nil || n != len(frame) {
+				t.Errorf("Expected %q written, got %v bytes (%v)", frame, n, err)
+			}
+			results <- frame
+		})
+	}
+	for i := 0; i < 10; i++ {
+		select {
+		case frame := <-results:
+			if want := fmt.Sprintf("<%d>", i); frame != want {
+				t.Fatalf("Expected %q completed, got %q", want, frame)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Expected the writes to complete")
+		}
+	}
+	if tx := string(f.written()); tx != "<0><1><2><3><4><5><6><7><8><9>" {
+		t.Fatalf("Expected the frames in order, got %q", tx)
+	}
+
+	sp.Close()
+	failed := make(chan error, 1)
+	sp.WriteAsync([]byte("late"), func(n int, err error) { failed <- err })
+	if err := <-failed; err != errNotOpen {
+		t.Fatalf("Expected errNotOpen once closed, got %v", err)
+	}
+	if n := sp.LiveThreads(); n != 0 {
+		t.Fatalf("Expected the writer thread to exit on Close, %v threads left", n)
+	}
+}
+
+func TestRTSTurnaround(t *testing.T) {
+	sp, f := openFake(t)
+	defer sp.Close()
+	if err := sp.SetRTSTurnaround(true, 5*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	// 96 bytes take 100 ms at 9600 baud 8N1
+	if _, err := sp.Write(make([]byte, 96)); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.rts) != 2 || !f.rts[0].on || f.rts[1].on {
+		t.Fatalf("Expected RTS to be asserted then deasserted, got %v", f.rts)
+	}
+	if hold := f.rts[1].at.Sub(f.rts[0].at); hold < 105*time.Millisecond || hold > 150*time.Millisecond {
+		t.Fatalf("Expected RTS to be held for 105 ms, got %v", hold)
+	}
+}
+
+func TestReadBufferSize(t *testing.T) {
+	for baud, want := range map[int]int{0: 256, 9600: 256, 115200: 256, 2000000: 2048, 3000000: 4096, 100000000: 16384} {
+		if size := IdealReadBufferSize(baud); size != want {
+			t.Errorf("Expected %v bytes at %v baud, got %v", want, baud, size)
+		}
+	}
+
+	sp := New()
+	if err := sp.SetReadBufferSize(-1); err == nil {
+		t.Fatal("Expected a negative size to be rejected")
+	}
+	if err := sp.SetReadBufferSize(4); err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	largest := 0
+	sp.inputTap = func(data []byte) {
+		mu.Lock()
+		if len(data) > largest {
+			largest = len(data)
+		}
+		mu.Unlock()
+	}
+	f := newFakePort()
+	sp.start("fake", 9600, f)
+	defer sp.Close()
+	f.dev.Write([]byte("0123456789"))
+	waitAvailable(t, sp, 10)
+	mu.Lock()
+	defer mu.Unlock()
+	if largest != 4 {
+		t.Fatalf("Expected reads of 4 bytes, got %v", largest)
+	}
+}
+
+func TestFrameDuration(t *testing.T) {
+	sp := New()
+	sp.baud = 9600
+	if d := sp.FrameDuration(96); d != 100*time.Millisecond {
+		t.Fatalf("Expected 100ms for 96 bytes 8N1, got %v", d)
+	}
+	sp.dataBits, sp.parity, sp.stopBits = 7, ParityEven, Stop1Half
+	if d := sp.ByteDuration(); d != 105*time.Second/96000 {
+		t.Fatalf("Expected 10.5 bits for 7E1.5, got %v", d)
+	}
+	sp.baud = 300
+	sp.dataBits, sp.parity, sp.stopBits = 8, ParityNone, Stop1
+	if d := sp.ByteDuration(); d != time.Second/30 {
+		t.Fatalf("Expected 10 bits for 8N1, got %v", d)
+	}
+	sp.stopBits = Stop2
+	if d := sp.ByteDuration(); d != 11*time.Second/300 {
+		t.Fatalf("Expected 11 bits for 8N2, got %v", d)
+	}
+}
+
+func TestWriteChunkingPacing(t *testing.T) {
+	sp, f := openFake(t)
+	defer sp.Close()
+	sp.dataBits, sp.parity, sp.stopBits = 8, ParityNone, Stop2
+	sp.baud = 1200
+	var at []time.Time
+	f.onWrite = func(b []byte) { at = append(at, time.Now()) }
+	sp.SetWriteChunking(12, 0)
+	if _, err := sp.Write(make([]byte, 24)); err != nil {
+		t.Fatal(err)
+	}
+	// 12 bytes of 11 bits take 110 ms at 1200 baud, 100 ms if assuming 10 bits
+	if len(at) != 2 {
+		t.Fatalf("Expected 2 chunks, got %v", len(at))
+	}
+	if gap := at[1].Sub(at[0]); gap < 110*time.Millisecond || gap > 150*time.Millisecond {
+		t.Fatalf("Expected the second chunk 110 ms after the first, got %v", gap)
+	}
+}
+
+func TestAcquireSync(t *testing.T) {
+	sp, f := openFake(t)
+	defer sp.Close()
+	go func() {
+		f.dev.Write([]byte{0x01, 0xAA, 0x02})
+		time.Sleep(10 * time.Millisecond)
+		f.dev.Write([]byte{0xAA})
+		time.Sleep(10 * time.Millisecond)
+		f.dev.Write([]byte{0x55, 0x10, 0x20})
+	}()
+
+	if err := sp.AcquireSync([]byte{0xAA, 0x55}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	waitAvailable(t, sp, 4)
+	for _, exp := range []byte{0xAA, 0x55, 0x10, 0x20} {
+		if b, err := sp.ReadByte(); err != nil || b != exp {
+			t.Fatalf("Expected %#02x, got %#02x (%v)", exp, b, err)
+		}
+	}
+	if err := sp.AcquireSync([]byte{0xAA, 0x55}, 10*time.Millisecond); err == nil {
+		t.Fatal("Expected a timeout")
+	}
+}
+
+func TestReadIOReader(t *testing.T) {
+	sp, f := openFake(t)
+	defer sp.Close()
+	var _ io.ReadWriteCloser = sp
+	go func() {
+		f.dev.Write([]byte("first\nsec"))
+		time.Sleep(10 * time.Millisecond)
+		f.dev.Write([]byte("ond\nlast"))
+		f.dev.Close()
+	}()
+
+	scanner := bufio.NewScanner(sp)
+	var lines []string
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	// The data buffered is returned before the disconnection
+	if fmt.Sprint(lines) != "[first second last]" {
+		t.Fatalf("Unexpected lines %q", lines)
+	}
+	if err := scanner.Err(); err != ErrPortDisconnected {
+		t.Fatalf("Expected ErrPortDisconnected, got %v", err)
+	}
+}
+
+func TestWriteDisconnected(t *testing.T) {
+	sp, f := openFake(t)
+	defer sp.Close()
+	f.dev.Write([]byte("last\r\n"))
+	waitAvailable(t, sp, 6)
+
+	f.mu.Lock()
+	f.writeErr = &os.PathError{Op: "write", Path: "fake", Err: syscall.ENODEV}
+	f.mu.Unlock()
+	if _, err := sp.Write([]byte("AT")); err != ErrPortDisconnected {
+		t.Fatalf("Expected ErrPortDisconnected, got %v", err)
+	}
+	// Subsequent writes fail fast
+	f.mu.Lock()
+	f.writeErr = nil
+	f.mu.Unlock()
+	if _, err := sp.Write([]byte("AT")); err != ErrPortDisconnected {
+		t.Fatalf("Expected ErrPortDisconnected, got %v", err)
+	}
+	if tx := f.written(); len(tx) != 0 {
+		t.Fatalf("Expected nothing written, got %q", tx)
+	}
+	// Data received before the disconnection is still readable
+	if line, err := sp.ReadLine(); err != nil || line != "last" {
+		t.Fatalf("Expected \"last\", got %q (%v)", line, err)
+	}
+	if _, err := sp.ReadLine(); err != ErrPortDisconnected {
+		t.Fatalf("Expected ErrPortDisconnected, got %v", err)
+	}
+	if _, err := sp.ReadByte(); err != ErrPortDisconnected {
+		t.Fatalf("Expected ErrPortDisconnected, got %v", err)
+	}
+}
+
+func TestReadTimeoutValues(t *testing.T) {
+	tests := []struct {
+		timeout     time.Duration
+		vmin, vtime uint8
+	}{
+		{0, 1, 0},
+		{10 * time.Millisecond, 0, 1},
+		{1250 * time.Millisecond, 0, 12},
+		{30 * time.Second, 0, 255},
+	}
+	for _, test := range tests {
+		sp := New()
+		sp.readTimeout = test.timeout
+		sp.start("fake", 9600, newFakePort())
+		// The values applied on open stay until the next one
+		sp.SetReadTimeout(time.Minute)
+		vmin, vtime := sp.ReadTimeoutValues()
+		if vmin != test.vmin || vtime != test.vtime {
+			t.Errorf("%v: expected VMIN %v VTIME %v, got %v %v", test.timeout, test.vmin, test.vtime, vmin, vtime)
+		}
+		sp.Close()
+	}
+}
+
+func TestWithReadTimeout(t *testing.T) {
+	sp, f := New(), newFakePort()
+	sp.readTimeout = 20 * time.Millisecond
+	sp.start("fake", 9600, f)
+	defer sp.Close()
+	b := make([]byte, 4)
+	err := sp.WithReadTimeout(time.Second, func() error {
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			f.dev.Write([]byte("slow"))
+		}()
+		_, err := io.ReadFull(sp, b)
+		return err
+	})
+	if err != nil || string(b) != "slow" {
+		t.Fatalf("Expected \"slow\" within the longer timeout, got %q (%v)", b, err)
+	}
+	if d := sp.ReadTimeout(); d != 20*time.Millisecond {
+		t.Fatalf("Expected the timeout to be restored, got %v", d)
+	}
+	failure := errors.New("No reply")
+	if err := sp.WithReadTimeout(time.Second, func() error { return failure }); err != failure {
+		t.Fatalf("Expected the error of fn, got %v", err)
+	}
+	if err := sp.WithReadTimeout(-time.Second, func() error { return nil }); err == nil {
+		t.Fatal("Expected a negative timeout to be rejected")
+	}
+
+	// Restored after a panic too
+	func() {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Fatal("Expected the panic to go through")
+			}
+		}()
+		sp.WithReadTimeout(0, func() error { panic("bug") })
+	}()
+	if d := sp.ReadTimeout(); d != 20*time.Millisecond {
+		t.Fatalf("Expected the timeout to be restored after a panic, got %v", d)
+	}
+
+	// Changed while a read is waiting, the read keeps its own timeout
+	sp.SetReadTimeout(200 * time.Millisecond)
+	start := time.Now()
+	errc := make(chan error, 1)
+	go func() {
+		_, err := sp.Read(b)
+		errc <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	sp.WithReadTimeout(time.Millisecond, func() error { return nil })
+	if err := <-errc; err == nil || time.Since(start) < 150*time.Millisecond {
+		t.Fatalf("Expected the read to time out after 200ms, got %v after %v", err, time.Since(start))
+	}
+}
+
+func TestDumpInput(t *testing.T) {
+	sp, f := openFake(t)
+	defer sp.Close()
+	var dump bytes.Buffer
+	sp.DumpInput(&dump)
+	data := []byte("hello, serial port\r\n")
+	f.dev.Write(data)
+	waitAvailable(t, sp, len(data))
+	sp.DumpInput(nil)
+
+	if dump.String() != hex.Dump(data) {
+		t.Fatalf("Unexpected dump:\n%s", dump.String())
+	}
+	if line, _ := sp.ReadLine(); line != "hello, serial port" {
+		t.Fatalf("Expected the data to stay readable, got %q", line)
+	}
+}
+
+// panicWriter panics on every write.
+type panicWriter struct{}
+
+func (panicWriter) Write(b []byte) (int, error) {
+	panic("buggy writer")
+}
+
+func TestDumpInputPanic(t *testing.T) {
+	sp, f := openFake(t)
+	defer sp.Close()
+	sp.DumpInput(panicWriter{})
+	f.dev.Write(bytes.Repeat([]byte("x"), 16))
+	select {
+	case err := <-sp.Errors():
+		t.Log(err)
+	case <-time.After(time.Second):
+		t.Fatal("Expected the panic to be reported")
+	}
+	waitAvailable(t, sp, 16)
+	sp.DumpInput(nil)
+	f.dev.Write([]byte("good\n"))
+	waitAvailable(t, sp, 21)
+}
+
+// lineWriter sends every write on a channel.
+type lineWriter chan string
+
+func (w lineWriter) Write(b []byte) (int, error) {
+	w <- string(b)
+	return len(b), nil
+}
+
+func TestTailTo(t *testing.T) {
+	sp, f := openFake(t)
+	defer sp.Close()
+	handled := make(chan string, 4)
+	sp.OnLine(func(line string) { handled <- line })
+	w := make(lineWriter, 4)
+	stop, err := sp.TailTo(w, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.dev.Write([]byte("first\r\n"))
+	select {
+	case line := <-w:
+		if !regexp.MustCompile(`^\d{4}-\d\d-\d\d \d\d:\d\d:\d\d\.\d{3} first\n$`).MatchString(line) {
+			t.Fatalf("Unexpected tail line %q", line)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Line not tailed")
+	}
+	if line := <-handled; line != "first" {
+		t.Fatalf("Expected the line handler to get \"first\", got %q", line)
+	}
+
+	stop()
+	stop()
+	f.dev.Write([]byte("second\r\n"))
+	<-handled
+	select {
+	case line := <-w:
+		t.Fatalf("Unexpected line %q after stop", line)
+	default:
+	}
+	if line, _ := sp.ReadLine(); line != "first" {
+		t.Fatalf("Expected the lines to stay readable, got %q", line)
+	}
+}
+
+func TestReadBurst(t *testing.T) {
+	sp, f := openFake(t)
+	defer sp.Close()
+
+	if data, err := sp.ReadBurst(20*time.Millisecond, 20*time.Millisecond, 16); err == nil || data != nil {
+		t.Fatalf("Expected a first byte timeout, got %q (%v)", data, err)
+	}
+
+	go func() {
+		f.dev.Write([]byte("ab"))
+		time.Sleep(5 * time.Millisecond)
+		f.dev.Write([]byte("cd"))
+		time.Sleep(200 * time.Millisecond)
+		f.dev.Write([]byte("next"))
+	}()
+	data, err := sp.ReadBurst(time.Second, 100*time.Millisecond, 16)
+	if err != nil || string(data) != "abcd" {
+		t.Fatalf("Expected \"abcd\", got %q (%v)", data, err)
+	}
+	// max limits the burst, the rest stays buffered
+	data, err = sp.ReadBurst(time.Second, 100*time.Millisecond, 3)
+	if err != nil || string(data) != "nex" {
+		t.Fatalf("Expected \"nex\", got %q (%v)", data, err)
+	}
+	if sp.Available() != 1 {
+		t.Fatalf("Expected 1 byte left, %v available", sp.Available())
+	}
+}
+
+func TestReadBurstUntil(t *testing.T) {
+	sp, f := openFake(t)
+	defer sp.Close()
+
+	go func() {
+		f.dev.Write([]byte("line 1\r\n"))
+		time.Sleep(50 * time.Millisecond)
+		f.dev.Write([]byte("line 2\r\n\x04next"))
+	}()
+	// The pause doesn't end the output when only the terminator does
+	data, err := sp.ReadBurstUntil(0x04, time.Second, 0, 64)
+	if err != nil || string(data) != "line 1\r\nline 2\r\n" {
+		t.Fatalf("Expected the data up to EOT, got %q (%v)", data, err)
+	}
+	waitAvailable(t, sp, 4)
+	if rest, _ := sp.ReadBurst(time.Second, 0, 16); string(rest) != "next" {
+		t.Fatalf("Expected the data after EOT left buffered, got %q", rest)
+	}
+
+	// With an inter-byte timeout, the quiet period ends it too
+	go func() {
+		f.dev.Write([]byte("ab"))
+		time.Sleep(200 * time.Millisecond)
+		f.dev.Write([]byte("cd\x04"))
+	}()
+	data, err = sp.ReadBurstUntil(0x04, time.Second, 50*time.Millisecond, 64)
+	if err != nil || string(data) != "ab" {
+		t.Fatalf("Expected \"ab\" ended by the gap, got %q (%v)", data, err)
+	}
+	data, err = sp.ReadBurstUntil(0x04, time.Second, 50*time.Millisecond, 64)
+	if err != nil || string(data) != "cd" {
+		t.Fatalf("Expected \"cd\", got %q (%v)", data, err)
+	}
+
+	// The partial data is returned on timeout
+	f.dev.Write([]byte("partial"))
+	data, err = sp.ReadBurstUntil(0x04, 50*time.Millisecond, 0, 64)
+	if err == nil || string(data) != "partial" {
+		t.Fatalf("Expected the partial data with a timeout, got %q (%v)", data, err)
+	}
+	f.dev.Write([]byte("0123\x04"))
+	data, err = sp.ReadBurstUntil(0x04, time.Second, 0, 3)
+	if err != nil || string(data) != "012" {
+		t.Fatalf("Expected max to limit the read, got %q (%v)", data, err)
+	}
+}
+
+func TestReadWatchdog(t *testing.T) {
+	sp := New()
+	sp.SetReadWatchdog(50 * time.Millisecond)
+	f := newFakePort()
+	sp.start("fake", 9600, f)
+	defer sp.Close()
+	f.dev.Write([]byte("OK\n"))
+
+	// The fake read blocks until the port is closed
+	select {
+	case err := <-sp.Errors():
+		if err != ErrReadStuck {
+			t.Fatalf("Expected ErrReadStuck, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Watchdog did not fire")
+	}
+	if line, err := sp.ReadLine(); err != nil || line != "OK" {
+		t.Fatalf("Expected \"OK\", got %q (%v)", line, err)
+	}
+	if _, err := sp.ReadLine(); err != ErrPortDisconnected {
+		t.Fatalf("Expected ErrPortDisconnected, got %v", err)
+	}
+}
+
+func TestSendFileEncoded(t *testing.T) {
+	sp, f := openFake(t)
+	defer sp.Close()
+	path := filepath.Join(t.TempDir(), "payload")
+	if err := os.WriteFile(path, []byte("\x01\x02\xff"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := sp.SendFileEncoded(path, hexEncode); err != nil {
+		t.Fatal(err)
+	}
+	if string(f.written()) != "0102ff" {
+		t.Fatalf("Expected \"0102ff\", got %q", f.written())
+	}
+
+	f.writeErr = syscall.EBADF
+	if err := sp.SendFileEncoded(path, hexEncode); err != syscall.EBADF {
+		t.Fatalf("Expected the write error, got %v", err)
+	}
+}
+
+func TestSendFileNoTrailingDelay(t *testing.T) {
+	sp, f := openFake(t)
+	defer sp.Close()
+	path := filepath.Join(t.TempDir(), "payload")
+	if err := os.WriteFile(path, make([]byte, 513), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sp.SetSendFilePacing(50*time.Millisecond, false)
+	start := time.Now()
+	if err := sp.SendFile(path); err != nil {
+		t.Fatal(err)
+	}
+	// Two chunks, a single delay between them
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond || elapsed >= 100*time.Millisecond {
+		t.Fatalf("Expected one delay of 50 ms, took %v", elapsed)
+	}
+	if n := len(f.written()); n != 513 {
+		t.Fatalf("Expected 513 bytes sent, got %v", n)
+	}
+
+	sp.SetSendFilePacing(50*time.Millisecond, true)
+	start = time.Now()
+	if err := sp.SendFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Fatalf("Expected a delay after the last chunk too, took %v", elapsed)
+	}
+}
+
+func TestSendFileTimeout(t *testing.T) {
+	sp, f := openFake(t)
+	defer sp.Close()
+	path := filepath.Join(t.TempDir(), "payload")
+	if err := os.WriteFile(path, make([]byte, 2048), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// The second chunk stalls, like a link paused by flow control
+	release := make(chan struct{})
+	f.mu.Lock()
+	f.onWrite = func(b []byte) {
+		if len(f.written()) > 512 {
+			<-release
+		}
+	}
+	f.mu.Unlock()
+	sp.SetSendFilePacing(0, false)
+	start := time.Now()
+	n, err := sp.SendFileTimeout(path, 50*time.Millisecond)
+	if err == nil || err.Error() != "Timeout expired" {
+		t.Fatalf("Expected a timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("Expected the stalled write not to be waited for, took %v", elapsed)
+	}
+	if n != 512 {
+		t.Fatalf("Expected the first chunk counted, got %v bytes", n)
+	}
+	// The stalled chunk completes, no other one is started
+	f.mu.Lock()
+	f.onWrite = nil
+	f.mu.Unlock()
+	close(release)
+	time.Sleep(20 * time.Millisecond)
+	if n := len(f.written()); n != 1024 {
+		t.Fatalf("Expected nothing sent after the stalled chunk, got %v bytes", n)
+	}
+
+	if n, err := sp.SendFileTimeout(path, time.Second); err != nil || n != 2048 {
+		t.Fatalf("Expected 2048 bytes sent, got %v (%v)", n, err)
+	}
+}
+
+func TestSendFileWritePath(t *testing.T) {
+	sp, f := openFake(t)
+	defer sp.Close()
+	path := filepath.Join(t.TempDir(), "payload")
+	if err := os.WriteFile(path, make([]byte, 250), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var sizes []int
+	f.onWrite = func(b []byte) { sizes = append(sizes, len(b)) }
+	sp.SetSendFilePacing(0, false)
+	sp.SetWriteChunking(100, 0)
+	if err := sp.SendFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if len(sizes) != 3 || sizes[0] != 100 || sizes[1] != 100 || sizes[2] != 50 {
+		t.Fatalf("Expected the file written in chunks of 100, 100 and 50 bytes, got %v", sizes)
+	}
+}
+
+func TestSendBreak(t *testing.T) {
+	sp, f := openFake(t)
+	defer sp.Close()
+	sp.SetWriteBuffering(LineBuffered)
+	sp.Print("pending")
+	if err := sp.SendBreak(250 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	sp.Print("sync\n")
+	// The data buffered goes out before the break
+	if tx := string(f.written()); tx != "pending<break>sync\n" {
+		t.Fatalf("Expected \"pending<break>sync\\n\", got %q", tx)
+	}
+	if fmt.Sprint(f.breaks) != "[250ms]" {
+		t.Fatalf("Expected a break of 250ms, got %v", f.breaks)
+	}
+	if err := sp.SendBreak(0); err == nil {
+		t.Fatal("Expected a zero duration to be rejected")
+	}
+	sp.Close()
+	if err := sp.SendBreak(time.Millisecond); err != errNotOpen {
+		t.Fatalf("Expected %v, got %v", errNotOpen, err)
+	}
+}
+
+func TestReadLineUTF8(t *testing.T) {
+	sp, f := openFake(t)
+	defer sp.Close()
+	lines := make(chan string, 2)
+	sp.OnLine(func(line string) { lines <- line })
+	text := "température 21°C, 湿度 40%"
+	data := []byte(text + "\r\n" + "naïve ☃\n")
+	// Split the writes inside the multi-byte characters
+	for _, cut := range [][]byte{data[:5], data[5:25], data[25 : len(text)+5], data[len(text)+5:]} {
+		f.dev.Write(cut)
+		time.Sleep(5 * time.Millisecond)
+	}
+	waitAvailable(t, sp, len(data))
+	for _, want := range []string{text, "naïve ☃"} {
+		line, err := sp.ReadLine()
+		if err != nil || line != want || !utf8.ValidString(line) {
+			t.Fatalf("Expected %q, got %q (%v)", want, line, err)
+		}
+		select {
+		case line = <-lines:
+		case <-time.After(time.Second):
+			t.Fatal("Expected the line handler to be called")
+		}
+		if line != want {
+			t.Fatalf("Expected %q in the line handler, got %q", want, line)
+		}
+	}
+}
+
+func TestReadLineDelim(t *testing.T) {
+	sp, f := openFake(t)
+	defer sp.Close()
+	f.dev.Write([]byte("ver 1.2\r>OK\n"))
+	line, err := sp.ReadLineDelim('>', time.Second)
+	if err != nil || line != "ver 1.2\r" {
+		t.Fatalf("Expected \"ver 1.2\\r\", got %q (%v)", line, err)
+	}
+	// The EOL of the port still applies to ReadLine
+	waitAvailable(t, sp, 3)
+	if line, err := sp.ReadLine(); err != nil || line != "OK" {
+		t.Fatalf("Expected \"OK\", got %q (%v)", line, err)
+	}
+	f.dev.Write([]byte("part"))
+	if _, err := sp.ReadLineDelim('>', 20*time.Millisecond); err == nil {
+		t.Fatal("Expected a timeout")
+	}
+	if n := sp.Available(); n != 4 {
+		t.Fatalf("Expected the partial line to stay buffered, got %v bytes", n)
+	}
+}
+
+func TestReadLineTimeout(t *testing.T) {
+	sp, f := openFake(t)
+	defer sp.Close()
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		f.dev.Write([]byte("first\r\nsecond\nthi"))
+	}()
+	line, err := sp.ReadLineTimeout(time.Second)
+	if err != nil || line != "first" {
+		t.Fatalf("Expected \"first\", got %q (%v)", line, err)
+	}
+	if n := sp.Available(); n != 10 {
+		t.Fatalf("Expected the rest to stay buffered, got %v bytes", n)
+	}
+	if line, err := sp.ReadLineTimeout(time.Second); err != nil || line != "second" {
+		t.Fatalf("Expected \"second\", got %q (%v)", line, err)
+	}
+	if _, err := sp.ReadLineTimeout(20 * time.Millisecond); err == nil || err.Error() != "Timeout expired" {
+		t.Fatalf("Expected a timeout, got %v", err)
+	}
+	if n := sp.Available(); n != 3 {
+		t.Fatalf("Expected the partial line to stay buffered, got %v bytes", n)
+	}
+	sp.Close()
+	if line, err := sp.ReadLineTimeout(time.Second); err != ErrIncompleteLine || line != "thi" {
+		t.Fatalf("Expected the partial line after close, got %q (%v)", line, err)
+	}
+}
+
+func TestResetChannel(t *testing.T) {
+	sp, f := openFake(t)
+	defer sp.Close()
+	sp.SetWriteBuffering(LineBuffered)
+	sp.Print("partial command")
+	f.dev.Write([]byte("garbage"))
+	waitAvailable(t, sp, 7)
+	// The fake port has no driver queues to flush, the other steps are still done
+	err := sp.ResetChannel(ResetOptions{PulseDTR: 10 * time.Millisecond, FlushDriver: true, ClearBuffer: true})
+	if err == nil || !strings.Contains(err.Error(), "Flush not supported") {
+		t.Fatalf("Expected the flush to fail, got %v", err)
+	}
+	if n := sp.Available(); n != 0 {
+		t.Fatalf("Expected the buffer to be cleared, got %v bytes", n)
+	}
+	sp.FlushWrites()
+	if tx := f.written(); len(tx) != 0 {
+		t.Fatalf("Expected the buffered writes to be discarded, got %q", tx)
+	}
+	if fmt.Sprint(f.dtr) != "[false true]" {
+		t.Fatalf("Expected a DTR pulse, got %v", f.dtr)
+	}
+	if err := sp.ResetChannel(ResetOptions{ClearBuffer: true}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSetBaud(t *testing.T) {
+	sp, _ := openFake(t)
+	defer sp.Close()
+	if sp.Baud() != 9600 {
+		t.Fatalf("Expected 9600 baud, got %v", sp.Baud())
+	}
+	if err := sp.SetBaud(0); err == nil {
+		t.Fatal("Expected a zero baud rate to be rejected")
+	}
+	// Only the ports of the platform change speed in place
+	if err := sp.SetBaud(115200); err == nil || sp.Baud() != 9600 {
+		t.Fatalf("Expected the fake port to keep 9600 baud, got %v (%v)", sp.Baud(), err)
+	}
+}
+
+func TestFlush(t *testing.T) {
+	sp, f := openFake(t)
+	defer sp.Close()
+	f.dev.Write([]byte("junk\nmore junk"))
+	waitAvailable(t, sp, 14)
+	if err := sp.FlushInput(); err != nil {
+		t.Fatal(err)
+	}
+	if line, err := sp.ReadLine(); err != io.EOF || sp.Available() != 0 {
+		t.Fatalf("Expected an empty buffer, got %q (%v) and %v bytes", line, err, sp.Available())
+	}
+	f.dev.Write([]byte("OK\n"))
+	waitAvailable(t, sp, 3)
+	if line, err := sp.ReadLine(); err != nil || line != "OK" {
+		t.Fatalf("Expected \"OK\" after the flush, got %q (%v)", line, err)
+	}
+
+	sp.SetWriteBuffering(LineBuffered)
+	sp.Print("sent\npending")
+	f.dev.Write([]byte("junk"))
+	waitAvailable(t, sp, 4)
+	if err := sp.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	sp.FlushWrites()
+	if tx := f.written(); len(tx) != 0 || sp.Available() != 0 {
+		t.Fatalf("Expected both ways to be flushed, got %q written and %v bytes buffered", tx, sp.Available())
+	}
+	sp.Close()
+	if err := sp.FlushInput(); err != errNotOpen {
+		t.Fatalf("Expected %v, got %v", errNotOpen, err)
+	}
+}
+
+func TestCapture(t *testing.T) {
+	sp, f := openFake(t)
+	defer sp.Close()
+	f.dev.Write([]byte("abc"))
+	waitAvailable(t, sp, 3)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		f.dev.Write([]byte("defgh"))
+	}()
+	data, err := sp.Capture(6, time.Second)
+	if err != nil || string(data) != "abcdef" {
+		t.Fatalf("Expected \"abcdef\", got %q (%v)", data, err)
+	}
+	// Up to the timeout, without error
+	start := time.Now()
+	data, err = sp.Capture(100, 50*time.Millisecond)
+	if err != nil || string(data) != "gh" {
+		t.Fatalf("Expected \"gh\", got %q (%v)", data, err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("Expected to capture for 50 ms, returned after %v", elapsed)
+	}
+	sp.Close()
+	if _, err := sp.Capture(1, time.Second); err != errNotOpen {
+		t.Fatalf("Expected %v, got %v", errNotOpen, err)
+	}
+}
+
+func TestSendFileWithCRC(t *testing.T) {
+	sp, f := openFake(t)
+	defer sp.Close()
+	sp.SetSendFilePacing(0, false)
+	path := filepath.Join(t.TempDir(), "firmware")
+	payload := bytes.Repeat([]byte("123456789"), 100)
+	if err := os.WriteFile(path, payload, 0644); err != nil {
+		t.Fatal(err)
+	}
+	sum, err := sp.SendFileWithCRC(path)
+	if err != nil || sum != crc32.ChecksumIEEE(payload) {
+		t.Fatalf("Expected the CRC-32 0x%08x, got 0x%08x (%v)", crc32.ChecksumIEEE(payload), sum, err)
+	}
+	if !bytes.Equal(f.written(), payload) {
+		t.Fatalf("Expected the file to be sent unchanged, got %v bytes", len(f.written()))
+	}
+	castagnoli := crc32.MakeTable(crc32.Castagnoli)
+	sum, err = sp.SendFileWithCRC(path, crc32.New(castagnoli))
+	if want := crc32.Checksum(payload, castagnoli); err != nil || sum != want {
+		t.Fatalf("Expected the CRC-32C 0x%08x, got 0x%08x (%v)", want, sum, err)
+	}
+	if _, err := sp.SendFileWithCRC(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Fatal("Expected an error for a missing file")
+	}
+}
+
+func TestSendFileRoundTrip(t *testing.T) {
+	for _, size := range []int{1, 1024, 1337} {
+		sp, f := openFake(t)
+		// Short writes, as under flow control
+		f.mu.Lock()
+		f.maxWrite = 100
+		f.mu.Unlock()
+		path := filepath.Join(t.TempDir(), "payload")
+		payload := make([]byte, size)
+		for i := range payload {
+			payload[i] = byte(i * 7)
+		}
+		if err := os.WriteFile(path, payload, 0644); err != nil {
+			t.Fatal(err)
+		}
+		sp.SetSendFilePacing(0, false)
+		var progress []int
+		offset, err := sp.SendFileFrom(path, 0, func(offset, size int) { progress = append(progress, offset) })
+		if err != nil || offset != size {
+			t.Fatalf("Expected to reach offset %v, got %v (%v)", size, offset, err)
+		}
+		if !bytes.Equal(f.written(), payload) {
+			t.Fatalf("Expected the %v bytes of the file, got %v bytes not matching", size, len(f.written()))
+		}
+		// One chunk per 512 bytes, the last one ending at the size
+		if want := (size + 511) / 512; len(progress) != want || progress[len(progress)-1] != size {
+			t.Fatalf("Expected %v chunks up to %v, got %v", want, size, progress)
+		}
+		sp.Close()
+	}
+}
+
+func TestSendFilePacer(t *testing.T) {
+	sp, f := openFake(t)
+	defer sp.Close()
+	path := filepath.Join(t.TempDir(), "payload")
+	if err := os.WriteFile(path, make([]byte, 1500), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sp.SetSendFilePacing(time.Hour, false)
+	var offsets []int
+	sp.SetSendFilePacer(func(offset, size int) time.Duration {
+		offsets = append(offsets, offset)
+		if offset < 1024 {
+			// Falling behind, slow down
+			return 50 * time.Millisecond
+		}
+		return 0
+	})
+	start := time.Now()
+	if err := sp.SendFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond || elapsed >= time.Second {
+		t.Fatalf("Expected a single delay of 50 ms, took %v", elapsed)
+	}
+	// Consulted between the chunks only, afterLast being false
+	if fmt.Sprint(offsets) != "[512 1024]" {
+		t.Fatalf("Unexpected pacer calls %v", offsets)
+	}
+	if n := len(f.written()); n != 1500 {
+		t.Fatalf("Expected 1500 bytes sent, got %v", n)
+	}
+}
+
+func TestSendFileFrom(t *testing.T) {
+	sp, f := openFake(t)
+	defer sp.Close()
+	path := filepath.Join(t.TempDir(), "firmware")
+	payload := make([]byte, 1200)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+	if err := os.WriteFile(path, payload, 0644); err != nil {
+		t.Fatal(err)
+	}
+	sp.SetSendFilePacing(0, false)
+	var progress []int
+	offset, err := sp.SendFileFrom(path, 100, func(offset, size int) {
+		if size != len(payload) {
+			t.Errorf("Expected a size of %v, got %v", len(payload), size)
+		}
+		progress = append(progress, offset)
+	})
+	if err != nil || offset != 1200 {
+		t.Fatalf("Expected to reach offset 1200, got %v (%v)", offset, err)
+	}
+	if !bytes.Equal(f.written(), payload[100:]) {
+		t.Fatalf("Expected the file from offset 100, got %v bytes", len(f.written()))
+	}
+	if fmt.Sprint(progress) != "[612 1124 1200]" {
+		t.Fatalf("Unexpected progress %v", progress)
+	}
+	for _, bad := range []int{-1, 1201} {
+		if _, err := sp.SendFileFrom(path, bad); err == nil {
+			t.Errorf("Expected offset %v to be rejected", bad)
+		}
+	}
+
+	// Cancelled after the first chunk, resumed from the offset reached
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	sp.SetSendFilePacing(time.Hour, false)
+	offset, err = sp.SendFileFromContext(ctx, path, 0)
+	if err != context.Canceled || offset != 512 {
+		t.Fatalf("Expected to be cancelled at offset 512, got %v (%v)", offset, err)
+	}
+	sp.SetSendFilePacing(0, false)
+	if offset, err = sp.SendFileFrom(path, offset); err != nil || offset != 1200 {
+		t.Fatalf("Expected to resume up to offset 1200, got %v (%v)", offset, err)
+	}
+	if tx := f.written(); !bytes.Equal(tx[len(tx)-1200:], payload) {
+		t.Fatal("Expected the resumed transfer to complete the file")
+	}
+}
+
+func hexEncode(data []byte) []byte {
+	return []byte(hex.EncodeToString(data))
+}
+
+func TestProbeActivity(t *testing.T) {
+	sp, f := openFake(t)
+	if active, err := sp.ProbeActivity(20 * time.Millisecond); err != nil || active {
+		t.Fatalf("Expected an idle line, got %v (%v)", active, err)
+	}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		f.dev.Write([]byte("boot"))
+	}()
+	if active, err := sp.ProbeActivity(time.Second); err != nil || !active {
+		t.Fatalf("Expected an active line, got %v (%v)", active, err)
+	}
+	// Data already buffered is not new activity, and is not consumed
+	if active, _ := sp.ProbeActivity(20 * time.Millisecond); active {
+		t.Fatal("Expected buffered data not to count as activity")
+	}
+	if sp.Available() != 4 {
+		t.Fatalf("Expected the data to stay buffered, %v bytes available", sp.Available())
+	}
+
+	sp.Close()
+	if _, err := sp.ProbeActivity(time.Millisecond); err != errNotOpen {
+		t.Fatalf("Expected errNotOpen, got %v", err)
+	}
+}
+
+func TestAvailableAll(t *testing.T) {
+	sp, f := openFake(t)
+	f.dev.Write([]byte("0123456789"))
+	waitAvailable(t, sp, 10)
+	f.mu.Lock()
+	f.queued = 32
+	f.mu.Unlock()
+	if buffered, queued, err := sp.AvailableAll(); err != nil || buffered != 10 || queued != 32 {
+		t.Fatalf("Expected 10 bytes buffered and 32 queued, got %v and %v (%v)", buffered, queued, err)
+	}
+	sp.ReadByte()
+	if buffered, queued, err := sp.AvailableAll(); err != nil || buffered != 9 || queued != 32 {
+		t.Fatalf("Expected 9 bytes buffered and 32 queued, got %v and %v (%v)", buffered, queued, err)
+	}
+	sp.Close()
+	if _, _, err := sp.AvailableAll(); err != errNotOpen {
+		t.Fatalf("Expected errNotOpen once closed, got %v", err)
+	}
+}
+
+func TestReady(t *testing.T) {
+	sp, f := openFake(t)
+	defer sp.Close()
+	f.dev.Write([]byte("OK\r\n"))
+	waitAvailable(t, sp, 4)
+	ok := regexp.MustCompile(`^OK$`)
+	if ready, err := sp.Ready([]byte("AT\r"), ok, 20*time.Millisecond); err != nil || ready {
+		t.Fatalf("Expected a stale response not to count, got %v (%v)", ready, err)
+	}
+	if n := sp.Available(); n != 0 {
+		t.Fatalf("Expected the stale response flushed, got %v bytes", n)
+	}
+
+	f.onWrite = func(b []byte) {
+		if string(b) == "AT\r" {
+			f.dev.Write([]byte("AT\r\nOK\r\n"))
+		}
+	}
+	if ready, err := sp.Ready([]byte("AT\r"), ok, time.Second); err != nil || !ready {
+		t.Fatalf("Expected the device to be ready, got %v (%v)", ready, err)
+	}
+	if ready, err := sp.Ready(nil, nil, 0); err != nil || !ready {
+		t.Fatalf("Expected no check to succeed, got %v (%v)", ready, err)
+	}
+
+	sp.SetReadyLines(true, false)
+	if _, err := sp.Ready(nil, nil, 0); err == nil {
+		t.Fatal("Expected an error without modem lines")
+	}
+}
+
+func TestTextprotoReader(t *testing.T) {
+	sp, f := openFake(t)
+	go f.dev.Write([]byte("Subject: a long\r\n  header\r\n\r\nbody\r\n..dot\r\n.\r\n"))
+
+	r := sp.TextprotoReader()
+	header, err := r.ReadMIMEHeader()
+	if err != nil || header.Get("Subject") != "a long header" {
+		t.Fatalf("Unexpected header %v (%v)", header, err)
+	}
+	lines, err := r.ReadDotLines()
+	if err != nil || len(lines) != 2 || lines[1] != ".dot" {
+		t.Fatalf("Unexpected lines %q (%v)", lines, err)
+	}
+
+	// Closing the port ends the stream
+	sp.Close()
+	if _, err := r.ReadLine(); err != io.EOF {
+		t.Fatalf("Expected io.EOF, got %v", err)
+	}
+}
+
+func TestHardwareLoopbackUnsupported(t *testing.T) {
+	sp, _ := openFake(t)
+	if err := sp.SetHardwareLoopback(true); err == nil {
+		t.Fatal("Expected an error for a port without driver")
+	}
+	sp.Close()
+	if err := sp.SetHardwareLoopback(true); err != errNotOpen {
+		t.Fatalf("Expected errNotOpen, got %v", err)
+	}
+}
+
+func TestReadChecked(t *testing.T) {
+	sp, f := openFake(t)
+	defer sp.Close()
+	go func() {
+		f.dev.Write([]byte{1, 2})
+		time.Sleep(5 * time.Millisecond)
+		f.dev.Write([]byte{3, 4, 5})
+	}()
+	var sum byte
+	data, err := sp.ReadChecked(4, func(b byte) { sum += b }, time.Second)
+	if err != nil || !bytes.Equal(data, []byte{1, 2, 3, 4}) || sum != 10 {
+		t.Fatalf("Unexpected data % x, sum %v (%v)", data, sum, err)
+	}
+
+	// The remaining byte is returned with the timeout error
+	sum = 0
+	data, err = sp.ReadChecked(4, func(b byte) { sum += b }, 20*time.Millisecond)
+	if err == nil || !bytes.Equal(data, []byte{5}) || sum != 5 {
+		t.Fatalf("Expected partial data with a timeout, got % x, sum %v (%v)", data, sum, err)
+	}
+}
+
+func TestWaitForModbusGap(t *testing.T) {
+	sp, f := openFake(t)
+	defer sp.Close()
+	if d := sp.ByteDuration(); d != 10*time.Second/9600 {
+		t.Fatalf("Unexpected character time %v at 9600 baud", d)
+	}
+
+	// 3.5 characters at 1200 baud are about 29 ms
+	sp.baud = 1200
+	stop := make(chan struct{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			f.dev.Write([]byte{byte(i)})
+			time.Sleep(2 * time.Millisecond)
+		}
+		close(stop)
+	}()
+	time.Sleep(2 * time.Millisecond)
+	if err := sp.WaitForModbusGap(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-stop:
+	default:
+		t.Fatal("Expected the gap to be found after the frame")
+	}
+
+	sp.baud = 115200
+	start := time.Now()
+	if err := sp.WaitForModbusGap(); err != nil || time.Since(start) > 10*time.Millisecond {
+		t.Fatalf("Expected the line to be silent already (%v)", err)
+	}
+}
+
+func TestLazyLineProcessing(t *testing.T) {
+	sp := New()
+	sp.LazyLineProcessing(true)
+	f := newFakePort()
+	sp.start("fake", 9600, f)
+	defer sp.Close()
+	if sp.processing {
+		t.Fatal("Expected no processor thread without line handler")
+	}
+	f.dev.Write([]byte("raw\n"))
+	waitAvailable(t, sp, 4)
+
+	// Registering a handler starts the line processing
+	lines := make(chan string, 1)
+	sp.OnLine(func(line string) { lines <- line })
+	f.dev.Write([]byte("OK\n"))
+	select {
+	case line := <-lines:
+		if line != "OK" {
+			t.Fatalf("Expected \"OK\", got %q", line)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Line handler not called")
+	}
+}
+
+func TestHangup(t *testing.T) {
+	sp, f := openFake(t)
+	defer sp.Close()
+	f.dev.Write([]byte("NO CARRIER\r\n"))
+	// Reads of a hung up tty keep returning EOF
+	f.dev.Close()
+
+	select {
+	case err := <-sp.Errors():
+		if err != ErrPortDisconnected {
+			t.Fatalf("Expected ErrPortDisconnected, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Hangup not detected")
+	}
+	if line, err := sp.ReadLine(); err != nil || line != "NO CARRIER" {
+		t.Fatalf("Expected \"NO CARRIER\", got %q (%v)", line, err)
+	}
+	if _, err := sp.ReadLine(); err != ErrPortDisconnected {
+		t.Fatalf("Expected ErrPortDisconnected, got %v", err)
+	}
+	if _, err := sp.Write([]byte("ATH\r")); err != ErrPortDisconnected {
+		t.Fatalf("Expected writes to fail with ErrPortDisconnected, got %v", err)
+	}
+}
+
+// emptyReader counts its reads, all returning at once without data, like a tty with
+// VMIN and VTIME set to 0.
+type emptyReader struct{ reads atomic.Int64 }
+
+func (r *emptyReader) Read(b []byte) (int, error) {
+	r.reads.Add(1)
+	return 0, io.EOF
+}
+
+func TestReadEmptyPolling(t *testing.T) {
+	sp := New()
+	r := &emptyReader{}
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		sp.readSerialPort(r, nil, done, &readWatch{fired: make(chan struct{})}, 64, false, true)
+		close(exited)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	close(done)
+	<-exited
+	if n := r.reads.Load(); n > 50 {
+		t.Fatalf("Expected the empty reads paced, got %v in 100ms", n)
+	}
+}
+
+func TestReadErrorTransient(t *testing.T) {
+	f := newFakePort()
+	f.readErrs = []error{syscall.EINTR, syscall.EAGAIN, errors.New("Glitch")}
+	sp := New()
+	sp.start("fake", 9600, f)
+	defer sp.Close()
+	f.dev.Write([]byte("OK\n"))
+	waitAvailable(t, sp, 3)
+	select {
+	case err := <-sp.Errors():
+		t.Fatalf("Expected transient errors to be retried silently, got %v", err)
+	default:
+	}
+}
+
+func TestReadErrorFatal(t *testing.T) {
+	f := newFakePort()
+	readErr := &os.PathError{Op: "read", Path: "fake", Err: syscall.ENODEV}
+	f.readErrs = []error{readErr}
+	sp := New()
+	sp.start("fake", 9600, f)
+	defer sp.Close()
+	for _, want := range []error{readErr, ErrPortDisconnected} {
+		select {
+		case err := <-sp.Errors():
+			if err != want {
+				t.Fatalf("Expected %v, got %v", want, err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expected %v to be reported", want)
+		}
+	}
+	if _, err := sp.ReadLine(); err != ErrPortDisconnected {
+		t.Fatalf("Expected ErrPortDisconnected, got %v", err)
+	}
+}
+
+func TestLastError(t *testing.T) {
+	f := newFakePort()
+	readErr := &os.PathError{Op: "read", Path: "fake", Err: syscall.EIO}
+	f.readErrs = []error{readErr}
+	sp := New()
+	if err := sp.LastError(); err != nil {
+		t.Fatalf("Expected no error before the open, got %v", err)
+	}
+	sp.start("fake", 9600, f)
+	defer sp.Close()
+	deadline := time.Now().Add(time.Second)
+	for sp.LastError() == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the read error to be kept")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := sp.LastError(); err != readErr {
+		t.Fatalf("Expected %v, got %v", readErr, err)
+	}
+	// The device is released by the reader thread, the port stays open until Close
+	if _, err := f.dev.Write([]byte("x")); err != io.ErrClosedPipe {
+		t.Fatalf("Expected the device to be closed, got %v", err)
+	}
+	if !sp.IsOpen() {
+		t.Fatal("Expected the port to stay open")
+	}
+	if err := sp.Close(); err != nil {
+		t.Fatal(err)
+	}
+	sp.start("fake", 9600, newFakePort())
+	if err := sp.LastError(); err != nil {
+		t.Fatalf("Expected the error to be reset by the open, got %v", err)
+	}
+}
+
+func TestReadErrorClassifier(t *testing.T) {
+	f := newFakePort()
+	quirk := errors.New("Device quirk")
+	f.readErrs = []error{syscall.EIO, quirk}
+	sp := New()
+	// EIO is transient on this platform, the quirk is fatal
+	sp.SetReadErrorClassifier(func(err error) ReadErrorAction {
+		if err == quirk {
+			return ReadStop
+		}
+		return ReadRetry
+	})
+	sp.start("fake", 9600, f)
+	defer sp.Close()
+	select {
+	case err := <-sp.Errors():
+		if err != quirk {
+			t.Fatalf("Expected the quirk to stop the reader, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the quirk to be reported")
+	}
+}
+
+func TestReadContext(t *testing.T) {
+	sp, f := openFake(t)
+	defer sp.Close()
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		f.dev.Write([]byte("boot\r\nready 42\r\ntail"))
+	}()
+	line, err := sp.ReadLineContext(context.Background())
+	if err != nil || line != "boot" {
+		t.Fatalf("Expected \"boot\", got %q (%v)", line, err)
+	}
+	match, err := sp.WaitForRegexContext(context.Background(), `ready \d+`)
+	if err != nil || match != "ready 42" {
+		t.Fatalf("Expected \"ready 42\", got %q (%v)", match, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := sp.ReadLineContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Expected context.DeadlineExceeded, got %v", err)
+	}
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	if _, err := sp.WaitForRegexContext(ctx, "never"); err != context.Canceled {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+	if _, err := sp.WaitForRegexContext(context.Background(), "("); err == nil {
+		t.Fatal("Expected an invalid expression to be rejected")
+	}
+
+	// The partial line is returned once the port is closed
+	sp.Close()
+	if line, err := sp.ReadLineContext(context.Background()); err != ErrIncompleteLine || line != "tail" {
+		t.Fatalf("Expected \"tail\" with ErrIncompleteLine, got %q (%v)", line, err)
+	}
+	if _, err := sp.WaitForRegexContext(context.Background(), "tail"); err != errNotOpen {
+		t.Fatalf("Expected errNotOpen, got %v", err)
+	}
+}
+
+func TestReadUntil(t *testing.T) {
+	sp, f := openFake(t)
+	defer sp.Close()
+	go f.dev.Write([]byte("\x02ab\r\ncd\x03\x02ef"))
+
+	frame, err := sp.ReadUntil(0x03, time.Second)
+	if err != nil || frame != "\x02ab\r\ncd\x03" {
+		t.Fatalf("Expected the frame with its CR LF and ETX, got %q (%v)", frame, err)
+	}
+	partial, err := sp.ReadUntil(0x03, 20*time.Millisecond)
+	if err == nil || partial != "\x02ef" {
+		t.Fatalf("Expected the partial frame with a timeout, got %q (%v)", partial, err)
+	}
+	if n := sp.Available(); n != 0 {
+		t.Fatalf("Expected the partial frame to be consumed, %v bytes left", n)
+	}
+}
+
+func TestTransforms(t *testing.T) {
+	sp, f := openFake(t)
+	defer sp.Close()
+	xor := func(data []byte) []byte {
+		out := make([]byte, len(data))
+		for i, b := range data {
+			out[i] = b ^ 0x5a
+		}
+		return out
+	}
+	sp.SetInputTransform(xor)
+	sp.SetOutputTransform(xor)
+
+	f.dev.Write(xor([]byte("hello\n")))
+	waitAvailable(t, sp, 6)
+	if line, err := sp.ReadLine(); err != nil || line != "hello" {
+		t.Fatalf("Expected the decoded line, got %q (%v)", line, err)
+	}
+	if n, err := sp.Write([]byte("AT\r")); err != nil || n != 3 {
+		t.Fatalf("Expected 3 bytes written, got %v (%v)", n, err)
+	}
+	if tx := f.written(); !bytes.Equal(tx, xor([]byte("AT\r"))) {
+		t.Fatalf("Expected the encoded data, got %q", tx)
+	}
+
+	// A transform changing the length, the count is of the data given
+	sp.SetOutputTransform(hexEncode)
+	if n, err := sp.Write([]byte{0xab}); err != nil || n != 1 {
+		t.Fatalf("Expected 1 byte written, got %v (%v)", n, err)
+	}
+	if tx := f.written(); !bytes.HasSuffix(tx, []byte("ab")) {
+		t.Fatalf("Expected the hex encoded data, got %q", tx)
+	}
+
+	sp.SetInputTransform(nil)
+	f.dev.Write([]byte("raw\n"))
+	waitAvailable(t, sp, 4)
+	if line, err := sp.ReadLine(); err != nil || line != "raw" {
+		t.Fatalf("Expected the data untouched, got %q (%v)", line, err)
+	}
+}
+
+func TestInputTransformPanic(t *testing.T) {
+	sp, f := openFake(t)
+	defer sp.Close()
+	sp.SetInputTransform(func(data []byte) []byte {
+		if bytes.Contains(data, []byte("bad")) {
+			panic("buggy transform")
+		}
+		return data
+	})
+	f.dev.Write([]byte("bad\n"))
+	select {
+	case err := <-sp.Errors():
+		t.Log(err)
+	case <-time.After(time.Second):
+		t.Fatal("Expected the panic to be reported")
+	}
+	f.dev.Write([]byte("good\n"))
+	waitAvailable(t, sp, 5)
+	if line, err := sp.ReadLine(); err != nil || line != "good" {
+		t.Fatalf("Expected the reader to keep running, got %q (%v)", line, err)
+	}
+}
+
+func TestReadUntilPrompt(t *testing.T) {
+	sp, f := openFake(t)
+	defer sp.Close()
+	go func() {
+		f.dev.Write([]byte("show version\r\nv1.2.3\r\nbuilt today\r\ndev"))
+		time.Sleep(5 * time.Millisecond)
+		f.dev.Write([]byte("ice> "))
+	}()
+	out, err := sp.ReadUntilPrompt("device> ", time.Second)
+	if err != nil || out != "show version\r\nv1.2.3\r\nbuilt today\r\n" {
+		t.Fatalf("Expected the command output, got %q (%v)", out, err)
+	}
+	if n := sp.Available(); n != 0 {
+		t.Fatalf("Expected the prompt to be consumed, %v bytes left", n)
+	}
+
+	f.dev.Write([]byte("partial"))
+	waitAvailable(t, sp, 7)
+	if out, err := sp.ReadUntilPrompt("device> ", 20*time.Millisecond); err == nil || out != "partial" {
+		t.Fatalf("Expected \"partial\" with a timeout, got %q (%v)", out, err)
+	}
+}
+
+func TestReadUntilAny(t *testing.T) {
+	sp, f := openFake(t)
+	defer sp.Close()
+	go f.dev.Write([]byte("OK\n> 12"))
+
+	data, delim, err := sp.ReadUntilAny([]byte{'\n', '>'}, time.Second)
+	if err != nil || string(data) != "OK\n" || delim != '\n' {
+		t.Fatalf("Expected \"OK\\n\", got %q %q (%v)", data, delim, err)
+	}
+	data, delim, err = sp.ReadUntilAny([]byte{'\n', '>'}, time.Second)
+	if err != nil || string(data) != ">" || delim != '>' {
+		t.Fatalf("Expected \">\", got %q %q (%v)", data, delim, err)
+	}
+	// Partial data on timeout
+	data, _, err = sp.ReadUntilAny([]byte{'\n', '>'}, 20*time.Millisecond)
+	if err == nil || string(data) != " 12" {
+		t.Fatalf("Expected \" 12\" with a timeout, got %q (%v)", data, err)
+	}
+}
+
+func TestOpenCloseNoLeak(t *testing.T) {
+	sp := New()
+	sp.openPort = func(name string, baud int, readTimeout time.Duration) (io.ReadWriteCloser, error) {
+		return newFakePort(), nil
+	}
+	sp.SetReadWatchdog(time.Second)
+	before := runtime.NumGoroutine()
+	for i := 0; i < 20; i++ {
+		if err := sp.Open("fake", 9600); err != nil {
+			t.Fatal(err)
+		}
+		if n := sp.LiveThreads(); n != 3 {
+			t.Fatalf("Expected reader, processor and watchdog threads, got %v", n)
+		}
+		if err := sp.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deadline := time.Now().Add(time.Second)
+	for sp.LiveThreads() != 0 || runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("Leaked threads: %v live, %v goroutines (%v before)", sp.LiveThreads(), runtime.NumGoroutine(), before)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// stuckClosePort is a fakePort whose Close blocks until release is closed, like a wedged
+// driver.
+type stuckClosePort struct {
+	*fakePort
+	release chan struct{}
+}
+
+func (p *stuckClosePort) Close() error {
+	<-p.release
+	return p.fakePort.Close()
+}
+
+func TestCloseAbandoned(t *testing.T) {
+	sp := New()
+	sp.SetReadWatchdog(50 * time.Millisecond)
+	p := &stuckClosePort{newFakePort(), make(chan struct{})}
+	defer close(p.release)
+	sp.start("fake", 9600, p)
+
+	start := time.Now()
+	if err := sp.Close(); err != ErrCloseAbandoned {
+		t.Fatalf("Expected ErrCloseAbandoned, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Expected Close to give up after two watchdog intervals, took %v", elapsed)
+	}
+	if sp.IsOpen() {
+		t.Fatal("Expected the port closed")
+	}
+}
+
+func TestEOLChangeRescans(t *testing.T) {
+	sp, f := openFake(t)
+	defer sp.Close()
+	lines := make(chan string, 4)
+	sp.OnLine(func(line string) { lines <- line })
+	f.dev.Write([]byte("a;b;c"))
+	waitAvailable(t, sp, 5)
+	if _, err := sp.ReadLine(); err != io.EOF {
+		t.Fatalf("Expected no line with the newline EOL, got %v", err)
+	}
+
+	sp.EOL(';')
+	for _, exp := range []string{"a;", "b;"} {
+		if line, err := sp.ReadLine(); err != nil || line != exp {
+			t.Fatalf("Expected %q, got %q (%v)", exp, line, err)
+		}
+	}
+	// The line handler re-frames its partial line on the next byte
+	f.dev.Write([]byte(";"))
+	for _, exp := range []string{"a", "b", "c"} {
+		select {
+		case line := <-lines:
+			if line != exp {
+				t.Fatalf("Expected %q, got %q", exp, line)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Line %q not handled", exp)
+		}
+	}
+}
+
+func TestEOLString(t *testing.T) {
+	sp, f := openFake(t)
+	defer sp.Close()
+	sp.EOLString("\r\n")
+	lines := make(chan string, 4)
+	sp.OnLine(func(line string) { lines <- line })
+	// The sequence split across writes
+	f.dev.Write([]byte("one\rtwo\r"))
+	time.Sleep(5 * time.Millisecond)
+	f.dev.Write([]byte("\nthree\nfour\r\nfive\r"))
+	waitAvailable(t, sp, 26)
+	for _, exp := range []string{"one\rtwo", "three\nfour"} {
+		if line, err := sp.ReadLine(); err != nil || line != exp {
+			t.Fatalf("Expected %q, got %q (%v)", exp, line, err)
+		}
+		select {
+		case line := <-lines:
+			if line != exp {
+				t.Fatalf("Expected %q in the line handler, got %q", exp, line)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Line %q not handled", exp)
+		}
+	}
+	if line, err := sp.ReadLine(); err != io.EOF {
+		t.Fatalf("Expected the partial line to stay buffered, got %q (%v)", line, err)
+	}
+
+	// A single byte keeps removing CR and LF
+	sp.EOL('\n')
+	f.dev.Write([]byte("\n"))
+	waitAvailable(t, sp, 6)
+	if line, err := sp.ReadLine(); err != nil || line != "five" {
+		t.Fatalf("Expected \"five\", got %q (%v)", line, err)
+	}
+}
+
+func TestSkipUntil(t *testing.T) {
+	sp, f := openFake(t)
+	defer sp.Close()
+	go func() {
+		f.dev.Write([]byte("U-Boot 2024.01\r\nbooting...\r\nlog"))
+		time.Sleep(5 * time.Millisecond)
+		f.dev.Write([]byte("in: "))
+	}()
+	if err := sp.SkipUntil(regexp.MustCompile(`login: $`), time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if n := sp.Available(); n != 0 {
+		t.Fatalf("Expected the banner to be discarded, %v bytes left", n)
+	}
+
+	f.dev.Write([]byte("noise\n"))
+	waitAvailable(t, sp, 6)
+	if err := sp.SkipUntil(regexp.MustCompile(`#`), 20*time.Millisecond); err == nil {
+		t.Fatal("Expected a timeout")
+	}
+	if n := sp.Available(); n != 0 {
+		t.Fatalf("Expected the lines to be discarded on timeout, %v bytes left", n)
+	}
+}
+
+func TestBreakEvent(t *testing.T) {
+	sp, f := openFake(t)
+	defer sp.Close()
+	sp.SetBreakHandling(BreakEvent)
+	f.dev.Write([]byte("a\xff\x00"))
+	f.dev.Write([]byte("\x00b\xff\xffc\xff\x00d"))
+	waitAvailable(t, sp, 5)
+
+	select {
+	case err := <-sp.Errors():
+		if err != ErrBreak {
+			t.Fatalf("Expected ErrBreak, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the break to be reported")
+	}
+	data := make([]byte, 0, 5)
+	for sp.Available() > 0 {
+		b, _ := sp.ReadByte()
+		data = append(data, b)
+	}
+	if string(data) != "ab\xffcd" {
+		t.Fatalf("Expected \"ab\\xffcd\", got %q", data)
+	}
+}
+
+func TestTransaction(t *testing.T) {
+	sp, f := openFake(t)
+	defer sp.Close()
+	started := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		<-started
+		sp.Write([]byte("x"))
+	}()
+	var saved io.Writer
+	err := sp.Transaction(func(w io.Writer) error {
+		saved = w
+		close(started)
+		for _, part := range []string{"AT", "+CMD", "\r"} {
+			if _, err := io.WriteString(w, part); err != nil {
+				return err
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	if tx := string(f.written()); tx != "AT+CMD\rx" {
+		t.Fatalf("Expected \"AT+CMD\\rx\", got %q", tx)
+	}
+	if _, err := saved.Write([]byte("y")); err == nil {
+		t.Fatal("Expected an error writing after the end of the transaction")
+	}
+
+	failed := errors.New("failed")
+	if err := sp.Transaction(func(w io.Writer) error { return failed }); err != failed {
+		t.Fatalf("Expected %v, got %v", failed, err)
+	}
+	sp.Close()
+	if err := sp.Transaction(func(w io.Writer) error { return nil }); err != errNotOpen {
+		t.Fatalf("Expected %v, got %v", errNotOpen, err)
+	}
+}
+
+func TestWriteShortWrites(t *testing.T) {
+	sp, f := openFake(t)
+	defer sp.Close()
+	f.mu.Lock()
+	f.maxWrite = 3
+	f.mu.Unlock()
+	if n, err := sp.Write([]byte("0123456789")); n != 10 || err != nil {
+		t.Fatalf("Expected 10 bytes written, got %v, %v", n, err)
+	}
+	if err := sp.Printf("%s", "abcdefg"); err != nil {
+		t.Fatal(err)
+	}
+	if tx := string(f.written()); tx != "0123456789abcdefg" {
+		t.Fatalf("Expected \"0123456789abcdefg\", got %q", tx)
+	}
+
+	f.mu.Lock()
+	f.writeErr = errors.New("failed")
+	f.mu.Unlock()
+	if err := sp.Print("x"); err == nil {
+		t.Fatal("Expected the write error from Print")
+	}
+}
+
+func TestWriteCompareEcho(t *testing.T) {
+	sp, f := openFake(t)
+	defer sp.Close()
+	f.dev.Write([]byte("pre"))
+	waitAvailable(t, sp, 3)
+	var corrupt func(b []byte) []byte
+	f.mu.Lock()
+	f.onWrite = func(b []byte) {
+		if corrupt != nil {
+			b = corrupt(b)
+		}
+		f.dev.Write(b)
+	}
+	f.mu.Unlock()
+
+	if i, err := sp.WriteCompareEcho([]byte("hello"), time.Second); i != -1 || err != nil {
+		t.Fatalf("Expected a matching echo, got %v, %v", i, err)
+	}
+	corrupt = func(b []byte) []byte {
+		b[2] ^= 0x20
+		return b
+	}
+	if i, err := sp.WriteCompareEcho([]byte("hello"), time.Second); i != 2 || err != nil {
+		t.Fatalf("Expected a mismatch at 2, got %v, %v", i, err)
+	}
+	corrupt = func(b []byte) []byte { return b[:3] }
+	if i, err := sp.WriteCompareEcho([]byte("hello"), 50*time.Millisecond); i != -1 || err == nil {
+		t.Fatalf("Expected a timeout with a matching short echo, got %v, %v", i, err)
+	}
+	corrupt = func(b []byte) []byte { return []byte("hex") }
+	if i, err := sp.WriteCompareEcho([]byte("hello"), 50*time.Millisecond); i != 2 || err == nil {
+		t.Fatalf("Expected a timeout with a mismatch at 2, got %v, %v", i, err)
+	}
+	// The echoes are consumed, the data received before them is left
+	if data, err := sp.ReadChecked(3, nil, time.Second); string(data) != "pre" || sp.Available() != 0 {
+		t.Fatalf("Expected only \"pre\" left, got %q, %v, %v more", data, err, sp.Available())
+	}
+}
+
+func BenchmarkReceive(b *testing.B) {
+	chunk := bytes.Repeat([]byte("0123456789abcdef"), 64)
+	for _, lazy := range []bool{false, true} {
+		name := "Processing"
+		if lazy {
+			name = "Lazy"
+		}
+		b.Run(name, func(b *testing.B) {
+			sp := New()
+			sp.LazyLineProcessing(lazy)
+			f := newFakePort()
+			sp.start("fake", 9600, f)
+			defer sp.Close()
+			b.SetBytes(int64(len(chunk)))
+			for i := 0; i < b.N; i++ {
+				f.dev.Write(chunk)
+				if _, err := sp.ReadChecked(len(chunk), nil, time.Second); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
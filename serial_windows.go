@@ -29,6 +29,12 @@ type structDCB struct {
 	wReserved1                                     uint16
 }
 
+type structComStat struct {
+	flags    uint32
+	cbInQue  uint32
+	cbOutQue uint32
+}
+
 type structTimeouts struct {
 	ReadIntervalTimeout         uint32
 	ReadTotalTimeoutMultiplier  uint32
@@ -89,6 +95,11 @@ func openPort(name string, baud int, readTimeout time.Duration) (p *Port, err er
 	return port, nil
 }
 
+// COM ports can't be opened more than once on Windows
+func openPortReadOnly(name string) (p *Port, err error) {
+	return nil, fmt.Errorf("Shared read-only open not supported")
+}
+
 func (p *Port) Close() error {
 	return p.f.Close()
 }
@@ -130,11 +141,304 @@ func (p *Port) Read(buf []byte) (int, error) {
 // Discards data written to the port but not transmitted,
 // or data received but not read
 func (p *Port) Flush() error {
-	return purgeComm(p.fd)
+	return purgeComm(p.fd, purgeTxAbort|purgeRxAbort|purgeTxClear|purgeRxClear)
+}
+
+// Discards data received but not read
+func (p *Port) flushInput() error {
+	return purgeComm(p.fd, purgeRxAbort|purgeRxClear)
+}
+
+// Discards data written to the port but not transmitted
+func (p *Port) flushOutput() error {
+	return purgeComm(p.fd, purgeTxAbort|purgeTxClear)
+}
+
+// Low-level settings saved by saveState. DTR/RTS are part of the DCB.
+type portState struct {
+	dcb      structDCB
+	timeouts structTimeouts
+}
+
+func (p *Port) saveState() (s portState, err error) {
+	s.dcb.DCBlength = uint32(unsafe.Sizeof(s.dcb))
+	r, _, e := syscall.Syscall(nGetCommState, 2, uintptr(p.fd), uintptr(unsafe.Pointer(&s.dcb)), 0)
+	if r == 0 {
+		return s, e
+	}
+	r, _, e = syscall.Syscall(nGetCommTimeouts, 2, uintptr(p.fd), uintptr(unsafe.Pointer(&s.timeouts)), 0)
+	if r == 0 {
+		return s, e
+	}
+	return s, nil
+}
+
+func (p *Port) restoreState(s portState) error {
+	r, _, err := syscall.Syscall(nSetCommState, 2, uintptr(p.fd), uintptr(unsafe.Pointer(&s.dcb)), 0)
+	if r == 0 {
+		return err
+	}
+	r, _, err = syscall.Syscall(nSetCommTimeouts, 2, uintptr(p.fd), uintptr(unsafe.Pointer(&s.timeouts)), 0)
+	if r == 0 {
+		return err
+	}
+	return nil
+}
+
+// Changes the line speed in place, data buffered by the driver is kept
+func (p *Port) setBaud(baud int) error {
+	s, err := p.saveState()
+	if err != nil {
+		return err
+	}
+	s.dcb.BaudRate = uint32(baud)
+	r, _, err := syscall.Syscall(nSetCommState, 2, uintptr(p.fd), uintptr(unsafe.Pointer(&s.dcb)), 0)
+	if r == 0 {
+		return err
+	}
+	return nil
+}
+
+// Changes the data bits, parity and stop bits in place
+func (p *Port) setFraming(dataBits int, parity Parity, stopBits StopBits) error {
+	s, err := p.saveState()
+	if err != nil {
+		return err
+	}
+	parities := map[Parity]byte{ParityNone: 0, ParityOdd: 1, ParityEven: 2, ParityMark: 3, ParitySpace: 4}
+	stops := map[StopBits]byte{Stop1: 0, Stop1Half: 1, Stop2: 2}
+	par, ok := parities[parity]
+	if !ok {
+		return fmt.Errorf("Invalid parity %q", byte(parity))
+	}
+	stop, ok := stops[stopBits]
+	if !ok {
+		return fmt.Errorf("Invalid stop bits %v", stopBits)
+	}
+	// SetCommState rejects 2 stop bits with 5 data bits
+	if stopBits == Stop2 && dataBits == 5 {
+		return fmt.Errorf("Stop bits 2 not supported with 5 data bits")
+	}
+	s.dcb.ByteSize = byte(dataBits)
+	s.dcb.Parity = par
+	s.dcb.StopBits = stop
+	// fParity
+	if parity != ParityNone {
+		s.dcb.flags[0] |= 0x02
+	} else {
+		s.dcb.flags[0] &^= 0x02
+	}
+	r, _, err := syscall.Syscall(nSetCommState, 2, uintptr(p.fd), uintptr(unsafe.Pointer(&s.dcb)), 0)
+	if r == 0 {
+		return err
+	}
+	return nil
+}
+
+// Configures the hardware (fOutxCtsFlow, RTS handshake) or software (fOutX, fInX) flow
+// control
+func (p *Port) setFlowControl(flow FlowControl, xon, xoff byte) error {
+	const fOutxCtsFlow, fOutX, fInX, fRtsControl, rtsHandshake = 0x04, 0x01, 0x02, 0x30, 0x20
+	s, err := p.saveState()
+	if err != nil {
+		return err
+	}
+	s.dcb.flags[0] &^= fOutxCtsFlow
+	s.dcb.flags[1] &^= fOutX | fInX | fRtsControl
+	switch flow {
+	case FlowNone:
+	case FlowRTSCTS:
+		s.dcb.flags[0] |= fOutxCtsFlow
+		s.dcb.flags[1] |= rtsHandshake
+	case FlowXONXOFF:
+		s.dcb.flags[1] |= fOutX | fInX
+		s.dcb.XonChar = xon
+		s.dcb.XoffChar = xoff
+		s.dcb.XonLim = 2048
+		s.dcb.XoffLim = 512
+	default:
+		return fmt.Errorf("Invalid flow control %v", flow)
+	}
+	r, _, err := syscall.Syscall(nSetCommState, 2, uintptr(p.fd), uintptr(unsafe.Pointer(&s.dcb)), 0)
+	if r == 0 {
+		return err
+	}
+	return nil
+}
+
+// Windows has no termios, see OpenStty
+func (p *Port) applyStty(st sttySettings) (int, error) {
+	return 0, fmt.Errorf("Termios settings not supported on Windows")
+}
+
+// Holds the line in the BREAK condition for d
+func (p *Port) sendBreak(d time.Duration) error {
+	r, _, err := syscall.Syscall(nSetCommBreak, 1, uintptr(p.fd), 0, 0)
+	if r == 0 {
+		return err
+	}
+	time.Sleep(d)
+	r, _, err = syscall.Syscall(nClearCommBreak, 1, uintptr(p.fd), 0, 0)
+	if r == 0 {
+		return err
+	}
+	return nil
+}
+
+// Breaks are signaled by comm events on Windows, not in the data
+func (p *Port) setBreakHandling(mode BreakHandling) error {
+	if mode != BreakInject {
+		return fmt.Errorf("Break handling %v not supported", mode)
+	}
+	return nil
+}
+
+// Number of bytes received by the driver and not read yet
+func (p *Port) inputQueued() (int, error) {
+	var errors uint32
+	var stat structComStat
+	r, _, err := syscall.Syscall(nClearCommError, 3, uintptr(p.fd),
+		uintptr(unsafe.Pointer(&errors)), uintptr(unsafe.Pointer(&stat)))
+	if r == 0 {
+		return 0, err
+	}
+	return int(stat.cbInQue), nil
+}
+
+// Reports whether the transmission is held waiting for CTS or for XON
+func (p *Port) flowHold() (cts, xoff bool, err error) {
+	const fCtsHold, fXoffHold = 0x01, 0x08
+	var errors uint32
+	var stat structComStat
+	r, _, err := syscall.Syscall(nClearCommError, 3, uintptr(p.fd),
+		uintptr(unsafe.Pointer(&errors)), uintptr(unsafe.Pointer(&stat)))
+	if r == 0 {
+		return false, false, err
+	}
+	return stat.flags&fCtsHold != 0, stat.flags&fXoffHold != 0, nil
+}
+
+// Asserts or deasserts the Request To Send line
+func (p *Port) setRTS(on bool) error {
+	const setRTS, clrRTS = 3, 4
+	return p.escapeCommFunction(on, setRTS, clrRTS)
+}
+
+// Asserts or deasserts the Data Terminal Ready line
+func (p *Port) setDTR(on bool) error {
+	const setDTR, clrDTR = 5, 6
+	return p.escapeCommFunction(on, setDTR, clrDTR)
+}
+
+// Disables the DTR control of the DCB, which drops DTR. Windows has no equivalent of
+// HUPCL: whether the line drops on close is up to the driver.
+func (p *Port) holdDTR() error {
+	const fDtrControl = 0x30
+	s, err := p.saveState()
+	if err != nil {
+		return err
+	}
+	s.dcb.flags[0] &^= fDtrControl
+	r, _, err := syscall.Syscall(nSetCommState, 2, uintptr(p.fd), uintptr(unsafe.Pointer(&s.dcb)), 0)
+	if r == 0 {
+		return err
+	}
+	return nil
+}
+
+// Calls EscapeCommFunction with set if on, clr otherwise
+func (p *Port) escapeCommFunction(on bool, set, clr uintptr) error {
+	fn := clr
+	if on {
+		fn = set
+	}
+	r, _, err := syscall.Syscall(nEscapeCommFunction, 2, uintptr(p.fd), fn, 0)
+	if r == 0 {
+		return err
+	}
+	return nil
+}
+
+// Reports whether the Data Carrier Detect (RLSD) line is asserted
+func (p *Port) carrierDetect() (bool, error) {
+	const msRLSDOn = 0x0080
+	var status uint32
+	r, _, err := syscall.Syscall(nGetCommModemStatus, 2, uintptr(p.fd), uintptr(unsafe.Pointer(&status)), 0)
+	if r == 0 {
+		return false, err
+	}
+	return status&msRLSDOn != 0, nil
+}
+
+// Reports whether the Data Set Ready line is asserted
+func (p *Port) dataSetReady() (bool, error) {
+	const msDSROn = 0x0020
+	var status uint32
+	r, _, err := syscall.Syscall(nGetCommModemStatus, 2, uintptr(p.fd), uintptr(unsafe.Pointer(&status)), 0)
+	if r == 0 {
+		return false, err
+	}
+	return status&msDSROn != 0, nil
+}
+
+// Returns the input modem control lines asserted
+func (p *Port) modemLines() (ModemLine, error) {
+	var status uint32
+	r, _, err := syscall.Syscall(nGetCommModemStatus, 2, uintptr(p.fd), uintptr(unsafe.Pointer(&status)), 0)
+	if r == 0 {
+		return 0, err
+	}
+	var lines ModemLine
+	for line, bit := range map[ModemLine]uint32{LineCTS: 0x0010, LineDSR: 0x0020, LineRI: 0x0040, LineDCD: 0x0080} {
+		if status&bit != 0 {
+			lines |= line
+		}
+	}
+	return lines, nil
+}
+
+// Line changes are polled, WaitCommEvent needs the event mask of the port
+func (p *Port) waitModemChange(lines ModemLine) error {
+	return errModemWaitUnsupported
+}
+
+// The communications API has no equivalent of the serial_struct of Linux
+func (p *Port) serialInfo() (SerialInfo, error) {
+	return SerialInfo{}, ErrSerialInfoUnsupported
+}
+
+// The communications API has no UART loopback
+func (p *Port) setLoopback(enable bool) error {
+	return ErrLoopbackUnsupported
+}
+
+// Makes a pending read return by cancelling the I/O of the port
+func (p *Port) unblock() error {
+	return syscall.CancelIoEx(p.fd, nil)
+}
+
+func (p *Port) debugTermios() (string, error) {
+	s, err := p.saveState()
+	if err != nil {
+		return "", err
+	}
+	d := &s.dcb
+	t := &s.timeouts
+	report := fmt.Sprintf("BaudRate: %v ByteSize: %v Parity: %v StopBits: %v\n", d.BaudRate, d.ByteSize, d.Parity, d.StopBits)
+	report += fmt.Sprintf("flags: % 02x\n", d.flags)
+	report += fmt.Sprintf("XonLim: %v XoffLim: %v XonChar: %#02x XoffChar: %#02x\n", d.XonLim, d.XoffLim, d.XonChar, d.XoffChar)
+	report += fmt.Sprintf("ErrorChar: %#02x EofChar: %#02x EvtChar: %#02x\n", d.ErrorChar, d.EofChar, d.EvtChar)
+	report += fmt.Sprintf("ReadIntervalTimeout: %v ReadTotalTimeoutMultiplier: %v ReadTotalTimeoutConstant: %v\n",
+		t.ReadIntervalTimeout, t.ReadTotalTimeoutMultiplier, t.ReadTotalTimeoutConstant)
+	report += fmt.Sprintf("WriteTotalTimeoutMultiplier: %v WriteTotalTimeoutConstant: %v",
+		t.WriteTotalTimeoutMultiplier, t.WriteTotalTimeoutConstant)
+	return report, nil
 }
 
 var (
 	nSetCommState,
+	nGetCommState,
+	nGetCommTimeouts,
 	nSetCommTimeouts,
 	nSetCommMask,
 	nSetupComm,
@@ -142,6 +446,11 @@ var (
 	nCreateEvent,
 	nResetEvent,
 	nPurgeComm,
+	nClearCommError,
+	nGetCommModemStatus,
+	nEscapeCommFunction,
+	nSetCommBreak,
+	nClearCommBreak,
 	nFlushFileBuffers uintptr
 )
 
@@ -153,6 +462,8 @@ func init() {
 	defer syscall.FreeLibrary(k32)
 
 	nSetCommState = getProcAddr(k32, "SetCommState")
+	nGetCommState = getProcAddr(k32, "GetCommState")
+	nGetCommTimeouts = getProcAddr(k32, "GetCommTimeouts")
 	nSetCommTimeouts = getProcAddr(k32, "SetCommTimeouts")
 	nSetCommMask = getProcAddr(k32, "SetCommMask")
 	nSetupComm = getProcAddr(k32, "SetupComm")
@@ -160,6 +471,11 @@ func init() {
 	nCreateEvent = getProcAddr(k32, "CreateEventW")
 	nResetEvent = getProcAddr(k32, "ResetEvent")
 	nPurgeComm = getProcAddr(k32, "PurgeComm")
+	nClearCommError = getProcAddr(k32, "ClearCommError")
+	nGetCommModemStatus = getProcAddr(k32, "GetCommModemStatus")
+	nEscapeCommFunction = getProcAddr(k32, "EscapeCommFunction")
+	nSetCommBreak = getProcAddr(k32, "SetCommBreak")
+	nClearCommBreak = getProcAddr(k32, "ClearCommBreak")
 	nFlushFileBuffers = getProcAddr(k32, "FlushFileBuffers")
 }
 
@@ -264,13 +580,16 @@ func resetEvent(h syscall.Handle) error {
 	return nil
 }
 
-func purgeComm(h syscall.Handle) error {
-	const PURGE_TXABORT = 0x0001
-	const PURGE_RXABORT = 0x0002
-	const PURGE_TXCLEAR = 0x0004
-	const PURGE_RXCLEAR = 0x0008
-	r, _, err := syscall.Syscall(nPurgeComm, 2, uintptr(h),
-		PURGE_TXABORT|PURGE_RXABORT|PURGE_TXCLEAR|PURGE_RXCLEAR, 0)
+// Flags of PurgeComm
+const (
+	purgeTxAbort = 0x0001
+	purgeRxAbort = 0x0002
+	purgeTxClear = 0x0004
+	purgeRxClear = 0x0008
+)
+
+func purgeComm(h syscall.Handle, flags uintptr) error {
+	r, _, err := syscall.Syscall(nPurgeComm, 2, uintptr(h), flags, 0)
 	if r == 0 {
 		return err
 	}
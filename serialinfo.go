@@ -0,0 +1,50 @@
+package serial
+
+// Flags of SerialInfo, from the ASYNC_* flags of linux/serial.h
+const (
+	serialFlagSpdMask    = 0x1030
+	serialFlagSpdCust    = 0x0030
+	serialFlagLowLatency = 0x2000
+)
+
+// SerialInfo is the configuration of a UART as known to the kernel driver, the
+// serial_struct of TIOCGSERIAL on Linux. See the setserial manual for the meaning of
+// the fields.
+type SerialInfo struct {
+	Type          int    // UART type, e.g. 4 for a 16550A, 0 when there is none
+	Line          int    // Port index of the driver, e.g. 0 for ttyS0
+	Port          uint   // I/O port address
+	IRQ           int    // Interrupt line
+	Flags         int    // ASYNC_* flags
+	XmitFIFOSize  int    // Size of the transmit FIFO
+	CustomDivisor int    // Divisor of BaudBase used by the spd_cust setting
+	BaudBase      int    // Base clock of the UART divided by 16, the fastest baud rate
+	CloseDelay    int    // Time DTR is kept low on close, in hundredths of a second
+	ClosingWait   int    // Time to wait for the output to drain on close, in hundredths of a second
+	IOType        int    // Access method of the UART registers
+	MemBase       uint64 // Memory address of the UART registers, if memory mapped
+}
+
+// LowLatency reports whether the driver pushes the received data to the reader without
+// delay (ASYNC_LOW_LATENCY).
+func (si SerialInfo) LowLatency() bool {
+	return si.Flags&serialFlagLowLatency != 0
+}
+
+// CustomSpeed reports whether the 38400 baud setting is replaced by BaudBase divided by
+// CustomDivisor (the spd_cust setting of setserial).
+func (si SerialInfo) CustomSpeed() bool {
+	return si.Flags&serialFlagSpdMask == serialFlagSpdCust
+}
+
+// SerialInfo returns the configuration of the UART from the driver (TIOCGSERIAL on
+// Linux), to diagnose e.g. a custom baud rate: BaudBase and CustomDivisor give the
+// actual speed. It returns ErrSerialInfoUnsupported when the driver or the platform
+// doesn't provide it, as for most USB adapters and pseudo terminals.
+func (sp *SerialPort) SerialInfo() (SerialInfo, error) {
+	p, err := sp.sysPort()
+	if err != nil {
+		return SerialInfo{}, err
+	}
+	return p.serialInfo()
+}
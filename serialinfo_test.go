@@ -0,0 +1,29 @@
+package serial
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSerialInfoFlags(t *testing.T) {
+	si := SerialInfo{Flags: 0x2000 | 0x0030, BaudBase: 115200, CustomDivisor: 3}
+	if !si.LowLatency() || !si.CustomSpeed() {
+		t.Fatalf("Expected low latency and custom speed, got %v and %v", si.LowLatency(), si.CustomSpeed())
+	}
+	si.Flags = 0x0010 // spd_hi
+	if si.LowLatency() || si.CustomSpeed() {
+		t.Fatalf("Expected no low latency nor custom speed, got %v and %v", si.LowLatency(), si.CustomSpeed())
+	}
+}
+
+func TestSerialInfoFakePort(t *testing.T) {
+	sp, _ := openFake(t)
+	defer sp.Close()
+	if _, err := sp.SerialInfo(); err == nil || !strings.Contains(err.Error(), "not supported") {
+		t.Fatalf("Expected an unsupported operation error, got %v", err)
+	}
+	sp.Close()
+	if _, err := sp.SerialInfo(); err != errNotOpen {
+		t.Fatalf("Expected %v, got %v", errNotOpen, err)
+	}
+}
@@ -0,0 +1,109 @@
+package serial
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// SLCANFrame is a CAN frame received from a SLCAN (CAN over serial) adapter.
+type SLCANFrame struct {
+	ID       uint32 // 11 bits, or 29 bits for an extended frame
+	Extended bool
+	RTR      bool // remote transmission request, without data
+	DLC      int
+	Data     []byte
+}
+
+// ReadSLCANFrame waits up to timeout for a CAN frame sent by a SLCAN adapter, in the
+// 't', 'T', 'r' or 'R' ASCII format terminated by CR. The acknowledgements of the adapter
+// (empty, "z" and "Z" lines) are skipped, a malformed frame is rejected with an error. The
+// error response of the adapter, a BEL without CR, is returned as an error on its own, the
+// frame following it being left for the next call.
+func (sp *SerialPort) ReadSLCANFrame(timeout time.Duration) (SLCANFrame, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		var line []byte
+		bell := false
+		err := sp.waitBuffer(time.Until(deadline), func(buff *bytes.Buffer) bool {
+			if b := buff.Bytes(); len(b) > 0 && b[0] == '\a' {
+				buff.Next(1)
+				bell = true
+				return true
+			}
+			i := bytes.IndexByte(buff.Bytes(), '\r')
+			if i < 0 {
+				return false
+			}
+			line = append([]byte(nil), buff.Next(i+1)...)
+			return true
+		})
+		if err != nil {
+			return SLCANFrame{}, err
+		}
+		if bell {
+			return SLCANFrame{}, fmt.Errorf("SLCAN adapter error")
+		}
+		str := removeEOL(string(line))
+		switch str {
+		case "", "z", "Z":
+			continue
+		}
+		return parseSLCANFrame(str)
+	}
+}
+
+// parseSLCANFrame parses a SLCAN frame without its CR.
+func parseSLCANFrame(str string) (SLCANFrame, error) {
+	var f SLCANFrame
+	if len(str) == 0 {
+		return f, fmt.Errorf("Empty SLCAN frame")
+	}
+	idLen := 3
+	switch str[0] {
+	case 't':
+	case 'T':
+		f.Extended, idLen = true, 8
+	case 'r':
+		f.RTR = true
+	case 'R':
+		f.Extended, f.RTR, idLen = true, true, 8
+	case '\a':
+		return f, fmt.Errorf("SLCAN adapter error")
+	default:
+		return f, fmt.Errorf("Malformed SLCAN frame %q - unknown type", str)
+	}
+	if len(str) < 1+idLen+1 {
+		return f, fmt.Errorf("Malformed SLCAN frame %q - too short", str)
+	}
+	id, err := strconv.ParseUint(str[1:1+idLen], 16, 32)
+	if err != nil || (!f.Extended && id > 0x7FF) || id > 0x1FFFFFFF {
+		return f, fmt.Errorf("Malformed SLCAN frame %q - invalid ID", str)
+	}
+	f.ID = uint32(id)
+	dlc := str[1+idLen]
+	if dlc < '0' || dlc > '8' {
+		return f, fmt.Errorf("Malformed SLCAN frame %q - invalid DLC", str)
+	}
+	f.DLC = int(dlc - '0')
+	rest := str[2+idLen:]
+	if !f.RTR {
+		if len(rest) < 2*f.DLC {
+			return f, fmt.Errorf("Malformed SLCAN frame %q - missing data", str)
+		}
+		if f.Data, err = hex.DecodeString(rest[:2*f.DLC]); err != nil {
+			return f, fmt.Errorf("Malformed SLCAN frame %q - invalid data", str)
+		}
+		rest = rest[2*f.DLC:]
+	}
+	// Adapters with timestamps enabled append 4 hex digits
+	if len(rest) != 0 && len(rest) != 4 {
+		return f, fmt.Errorf("Malformed SLCAN frame %q - unexpected trailing data", str)
+	}
+	if _, err = strconv.ParseUint("0"+rest, 16, 16); err != nil {
+		return f, fmt.Errorf("Malformed SLCAN frame %q - invalid timestamp", str)
+	}
+	return f, nil
+}
@@ -0,0 +1,66 @@
+package serial
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestParseSLCANFrame(t *testing.T) {
+	tests := []struct {
+		str   string
+		frame SLCANFrame
+	}{
+		{"t1232AABB", SLCANFrame{ID: 0x123, DLC: 2, Data: []byte{0xAA, 0xBB}}},
+		{"T01ABCDEF81122334455667788", SLCANFrame{ID: 0x1ABCDEF, Extended: true, DLC: 8,
+			Data: []byte{0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88}}},
+		{"r7FF4", SLCANFrame{ID: 0x7FF, RTR: true, DLC: 4}},
+		{"R000000010", SLCANFrame{ID: 1, Extended: true, RTR: true}},
+		{"t0011FF1234", SLCANFrame{ID: 1, DLC: 1, Data: []byte{0xFF}}},
+	}
+	for _, test := range tests {
+		f, err := parseSLCANFrame(test.str)
+		if err != nil {
+			t.Errorf("%q: %v", test.str, err)
+			continue
+		}
+		if f.ID != test.frame.ID || f.Extended != test.frame.Extended || f.RTR != test.frame.RTR ||
+			f.DLC != test.frame.DLC || !bytes.Equal(f.Data, test.frame.Data) {
+			t.Errorf("%q: expected %+v, got %+v", test.str, test.frame, f)
+		}
+	}
+	for _, str := range []string{"x123", "t12", "t8001AA", "t1239", "t1232AA", "t1231GG", "t1231AA12", "\a"} {
+		if _, err := parseSLCANFrame(str); err == nil {
+			t.Errorf("%q: expected an error", str)
+		}
+	}
+}
+
+func TestReadSLCANFrame(t *testing.T) {
+	sp, f := openFake(t)
+	defer sp.Close()
+	f.dev.Write([]byte("z\r\rt1001"))
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		f.dev.Write([]byte("42\r"))
+	}()
+	frame, err := sp.ReadSLCANFrame(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frame.ID != 0x100 || frame.DLC != 1 || !bytes.Equal(frame.Data, []byte{0x42}) {
+		t.Fatalf("Unexpected frame %+v", frame)
+	}
+	if _, err = sp.ReadSLCANFrame(10 * time.Millisecond); err == nil {
+		t.Fatal("Expected a timeout")
+	}
+
+	// A rejected command, then a frame
+	f.dev.Write([]byte("\at2002ABCD\r"))
+	if _, err = sp.ReadSLCANFrame(time.Second); err == nil || err.Error() != "SLCAN adapter error" {
+		t.Fatalf("Expected the adapter error, got %v", err)
+	}
+	if frame, err = sp.ReadSLCANFrame(time.Second); err != nil || frame.ID != 0x200 || !bytes.Equal(frame.Data, []byte{0xAB, 0xCD}) {
+		t.Fatalf("Expected the frame after the error, got %+v (%v)", frame, err)
+	}
+}
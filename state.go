@@ -0,0 +1,93 @@
+package serial
+
+// PortState is the lifecycle state of a SerialPort, see SetStateHandler.
+type PortState int
+
+const (
+	StateClosed       PortState = iota // not open, the initial state
+	StateOpen                          // open and receiving
+	StateErrored                       // still open, but the device is disconnected or stuck
+	StateReconnecting                  // Reconnect or SetAutoReconnect is opening the port again
+)
+
+var portStateNames = []string{"closed", "open", "errored", "reconnecting"}
+
+// String returns the name of the state, e.g. "open".
+func (s PortState) String() string {
+	if s < 0 || int(s) >= len(portStateNames) {
+		return "unknown"
+	}
+	return portStateNames[s]
+}
+
+// StateHandler is called on the transitions of the port from old to new, err telling why
+// for the failures.
+type StateHandler func(old, new PortState, err error)
+
+// stateEvent is a transition waiting to be passed to the StateHandler.
+type stateEvent struct {
+	old, new PortState
+	err      error
+}
+
+// SetStateHandler registers a handler called on every transition of the port: open
+// (StateOpen), Close (StateClosed), disconnection or stuck reads (StateErrored with the
+// error), Reconnect (StateReconnecting then StateOpen) and the failures to open
+// (StateClosed with the error, the state being unchanged). A nil handler removes it.
+//
+// The handler is called in its own goroutine, never by the reader threads, one transition
+// at a time and in order; a slow handler delays the next transitions, not the port.
+func (sp *SerialPort) SetStateHandler(handler StateHandler) {
+	sp.handlersMu.Lock()
+	sp.stateHandler = handler
+	sp.handlersMu.Unlock()
+}
+
+// setState moves the port to state, passing the transition to the StateHandler. err
+// alone is reported when the state doesn't change. A port closed meanwhile doesn't enter
+// StateErrored.
+func (sp *SerialPort) setState(state PortState, err error) {
+	sp.handlersMu.Lock()
+	defer sp.handlersMu.Unlock()
+	if state == StateErrored && !sp.portIsOpen.Load() {
+		return
+	}
+	old := sp.state
+	if state == old && err == nil {
+		return
+	}
+	sp.state = state
+	if sp.stateHandler == nil {
+		return
+	}
+	sp.stateEvents = append(sp.stateEvents, stateEvent{old, state, err})
+	if !sp.delivering {
+		sp.delivering = true
+		sp.goThread(sp.deliverStates)
+	}
+}
+
+// deliverStates passes the queued transitions to the StateHandler until there are none.
+func (sp *SerialPort) deliverStates() {
+	for {
+		sp.handlersMu.Lock()
+		if len(sp.stateEvents) == 0 {
+			sp.delivering = false
+			sp.handlersMu.Unlock()
+			return
+		}
+		ev := sp.stateEvents[0]
+		sp.stateEvents = sp.stateEvents[1:]
+		handler := sp.stateHandler
+		sp.handlersMu.Unlock()
+		if handler != nil {
+			sp.callStateHandler(handler, ev)
+		}
+	}
+}
+
+// callStateHandler calls handler with ev, recovering from a panic in the handler.
+func (sp *SerialPort) callStateHandler(handler StateHandler, ev stateEvent) {
+	defer sp.recoverPanic("state handler")
+	handler(ev.old, ev.new, ev.err)
+}
@@ -0,0 +1,103 @@
+package serial
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestStateHandler(t *testing.T) {
+	sp := New()
+	var opened []*fakePort
+	fail := errors.New("Busy")
+	sp.openPort = func(name string, baud int, readTimeout time.Duration) (io.ReadWriteCloser, error) {
+		if len(opened) == 2 {
+			return nil, fail
+		}
+		opened = append(opened, newFakePort())
+		return opened[len(opened)-1], nil
+	}
+	transitions := make(chan string, 16)
+	sp.SetStateHandler(func(old, new PortState, err error) {
+		transitions <- fmt.Sprintf("%v>%v %v", old, new, err)
+	})
+	expect := func(want ...string) {
+		t.Helper()
+		for _, w := range want {
+			select {
+			case got := <-transitions:
+				if got != w {
+					t.Fatalf("Expected the transition %q, got %q", w, got)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("Expected the transition %q", w)
+			}
+		}
+	}
+
+	if err := sp.Open("fake", 9600); err != nil {
+		t.Fatal(err)
+	}
+	expect("closed>open <nil>")
+	opened[0].dev.CloseWithError(&os.PathError{Op: "read", Path: "fake", Err: syscall.ENODEV})
+	expect("open>errored read fake: no such device")
+	if err := sp.Reconnect(); err != nil {
+		t.Fatal(err)
+	}
+	expect("errored>closed <nil>", "closed>reconnecting <nil>", "reconnecting>open <nil>")
+	if err := sp.Reconnect(); err == nil {
+		t.Fatal("Expected the reconnection to fail")
+	}
+	expect("open>closed <nil>", "closed>reconnecting <nil>", "reconnecting>closed Unable to open port \"fake\" - Busy")
+	if err := sp.Open("fake", 9600); err == nil {
+		t.Fatal("Expected the open to fail")
+	}
+	expect("closed>closed Unable to open port \"fake\" - Busy")
+	select {
+	case got := <-transitions:
+		t.Fatalf("Unexpected transition %q", got)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestStateHandlerDoesNotBlockReader(t *testing.T) {
+	f := newFakePort()
+	f.readErrs = []error{syscall.EIO}
+	sp := New()
+	release := make(chan struct{})
+	called := make(chan struct{}, 4)
+	sp.SetStateHandler(func(old, new PortState, err error) {
+		called <- struct{}{}
+		<-release
+	})
+	sp.start("fake", 9600, f)
+	defer sp.Close()
+	// The reader thread reports the failure while the handler is stuck on the open
+	<-called
+	timeout := time.After(time.Second)
+	for done := false; !done; {
+		select {
+		case err := <-sp.Errors():
+			done = err == ErrPortDisconnected
+		case <-timeout:
+			t.Fatal("Expected the reader thread to report the disconnection")
+		}
+	}
+	if _, err := sp.ReadLine(); err != ErrPortDisconnected {
+		t.Fatalf("Expected the read to see the disconnection, got %v", err)
+	}
+	sp.Close()
+	close(release)
+	// Then the disconnection and the close are passed to the handler
+	for i := 0; i < 2; i++ {
+		select {
+		case <-called:
+		case <-time.After(time.Second):
+			t.Fatalf("Expected %v more transitions", 2-i)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package serial
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sttySettings are the termios settings of an "stty -g" string: the input, output,
+// control and local flags, and the control characters.
+type sttySettings struct {
+	iflag, oflag, cflag, lflag uint32
+	cc                         []byte
+}
+
+// parseStty parses the output of GNU "stty -g": the four flags, then the control
+// characters, all in hexadecimal and separated by colons.
+func parseStty(s string) (sttySettings, error) {
+	var st sttySettings
+	fields := strings.Split(strings.TrimSpace(s), ":")
+	if len(fields) < 5 {
+		return st, fmt.Errorf("Invalid stty settings %q - expected the output of stty -g", s)
+	}
+	var flags [4]uint32
+	for i := range flags {
+		v, err := strconv.ParseUint(fields[i], 16, 32)
+		if err != nil {
+			return st, fmt.Errorf("Invalid stty settings %q - bad flags %q", s, fields[i])
+		}
+		flags[i] = uint32(v)
+	}
+	st.iflag, st.oflag, st.cflag, st.lflag = flags[0], flags[1], flags[2], flags[3]
+	for _, f := range fields[4:] {
+		v, err := strconv.ParseUint(f, 16, 8)
+		if err != nil {
+			return st, fmt.Errorf("Invalid stty settings %q - bad control character %q", s, f)
+		}
+		st.cc = append(st.cc, byte(v))
+	}
+	return st, nil
+}
+
+// OpenStty opens the port with the termios settings of an "stty -g" string, e.g. captured
+// from another tool with "stty -g < /dev/ttyUSB0", applied verbatim instead of the
+// settings of Open: the baud rate, framing, flow control, VMIN and VTIME all come from
+// settings. It fails if the driver doesn't keep all of them. The timeout is the one of
+// Open, for the read functions. Reconnect applies the settings again.
+//
+// The reads of the port wait as VMIN and VTIME tell: for a byte with VMIN above 0, a
+// hangup of the tty then being detected as with Open, or for up to VTIME with VMIN 0.
+// VMIN 0 with VTIME 0, reads never waiting, is rejected.
+//
+// The GNU format is expected, the one of stty on Linux. Windows has no termios, OpenStty
+// fails there.
+func (sp *SerialPort) OpenStty(name string, settings string, timeout ...time.Duration) error {
+	if sp.portIsOpen.Load() {
+		return fmt.Errorf("\"%s\" is already open", name)
+	}
+	st, err := parseStty(settings)
+	if err != nil {
+		return err
+	}
+	var readTimeout time.Duration
+	if len(timeout) > 0 {
+		readTimeout = timeout[0]
+	}
+	comPort, baud, err := sp.openStty(name, st)
+	if err != nil {
+		sp.setState(StateClosed, err)
+		return err
+	}
+	sp.settingsMu.Lock()
+	sp.stty = &st
+	sp.readTimeout = readTimeout
+	sp.settingsMu.Unlock()
+	sp.buffMu.Lock()
+	sp.buff.Reset()
+	sp.buffMu.Unlock()
+	sp.dropped.Store(0)
+	sp.start(name, baud, comPort)
+	if err := sp.skipPreamble(0); err != nil {
+		sp.Close()
+		return err
+	}
+	return nil
+}
+
+// openStty opens the named port with the termios settings st, and returns it with its
+// baud rate.
+func (sp *SerialPort) openStty(name string, st sttySettings) (io.ReadWriteCloser, int, error) {
+	comPort, err := sp.openPort(name, 9600, 0)
+	if err != nil {
+		return nil, 0, fmt.Errorf("Unable to open port \"%s\" - %s", name, err)
+	}
+	p, ok := comPort.(*Port)
+	if !ok {
+		comPort.Close()
+		return nil, 0, fmt.Errorf("Operation not supported on \"%s\"", name)
+	}
+	sp.settingsMu.RLock()
+	holdDTR, clearOnOpen := sp.holdDTR, sp.clearOnOpen
+	sp.settingsMu.RUnlock()
+	baud, err := p.applyStty(st)
+	if err != nil {
+		comPort.Close()
+		return nil, 0, fmt.Errorf("Unable to apply stty settings to \"%s\" - %s", name, err)
+	}
+	if holdDTR {
+		if err = p.holdDTR(); err != nil {
+			comPort.Close()
+			return nil, 0, fmt.Errorf("Unable to open port \"%s\" - %s", name, err)
+		}
+	}
+	if clearOnOpen {
+		if err = p.Flush(); err != nil {
+			comPort.Close()
+			return nil, 0, fmt.Errorf("Unable to clear port \"%s\" - %s", name, err)
+		}
+	}
+	return comPort, baud, nil
+}
@@ -0,0 +1,44 @@
+package serial
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseStty(t *testing.T) {
+	st, err := parseStty("500:5:bf:8a3b:3:1c:7f:15:4:0:1:0:11:13:1a:0:12:f:17:16:0:0:0:0:0:0:0:0:0:0:0:0:0:0:0:0\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.iflag != 0x500 || st.oflag != 0x5 || st.cflag != 0xbf || st.lflag != 0x8a3b {
+		t.Fatalf("Unexpected flags %+v", st)
+	}
+	if len(st.cc) != 32 || st.cc[0] != 0x03 || st.cc[8] != 0x11 {
+		t.Fatalf("Unexpected control characters %v", st.cc)
+	}
+	for _, bad := range []string{"", "500:5:bf", "500:5:bf:zz:3", "500:5:bf:8a3b:100", "speed 9600 baud; line = 0;"} {
+		if _, err := parseStty(bad); err == nil {
+			t.Errorf("Expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestOpenSttyUnsupported(t *testing.T) {
+	sp := New()
+	var f *fakePort
+	sp.openPort = func(name string, baud int, readTimeout time.Duration) (io.ReadWriteCloser, error) {
+		f = newFakePort()
+		return f, nil
+	}
+	if err := sp.OpenStty("fake", "0:0:8bd:0:0:0"); err == nil || !strings.Contains(err.Error(), "not supported") {
+		t.Fatalf("Expected the settings to be rejected on a port without termios, got %v", err)
+	}
+	if _, err := f.dev.Write([]byte("x")); sp.portIsOpen.Load() || err == nil {
+		t.Fatal("Expected the port to be closed after the failure")
+	}
+	if err := sp.OpenStty("fake", "bad"); err == nil {
+		t.Fatal("Expected bad settings to be rejected")
+	}
+}
@@ -0,0 +1,112 @@
+package serial
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+)
+
+// MeasureThroughput measures the rate a looped back link sustains, with the hardware
+// loopback (see SetHardwareLoopback) or a loopback plug: it writes a pattern for d, reads
+// it back and returns the rate of the data received, in bytes per second. The data
+// buffered before is discarded.
+//
+// Every byte received is checked against the pattern, a mismatch failing with an error
+// telling its offset, returned with the rate measured up to it. Data that stops coming
+// back before all of it is received fails too.
+func (sp *SerialPort) MeasureThroughput(d time.Duration) (float64, error) {
+	if !sp.portIsOpen.Load() {
+		return 0, errNotOpen
+	}
+	sp.buffMu.Lock()
+	sp.buff.Reset()
+	sp.buffMu.Unlock()
+
+	type progress struct {
+		sent int
+		err  error
+	}
+	written := make(chan progress, 1)
+	stop := make(chan struct{})
+	defer close(stop)
+	start := time.Now()
+	sp.goThread(func() {
+		chunk := make([]byte, 256)
+		sent := 0
+		for time.Since(start) < d {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			for i := range chunk {
+				chunk[i] = throughputPattern(sent + i)
+			}
+			n, err := sp.write(chunk)
+			sent += n
+			if err != nil {
+				written <- progress{sent, err}
+				return
+			}
+		}
+		written <- progress{sent, nil}
+	})
+
+	// Time for the data queued in the driver to come back
+	idle := 100*time.Millisecond + sp.FrameDuration(256)
+	received := 0
+	last := start
+	rate := func() float64 {
+		if received == 0 {
+			return 0
+		}
+		return float64(received) / last.Sub(start).Seconds()
+	}
+	total := -1
+	for total < 0 || received < total {
+		var mismatch error
+		err := sp.waitBuffer(idle, func(buff *bytes.Buffer) bool {
+			if buff.Len() == 0 {
+				return false
+			}
+			for _, b := range buff.Next(buff.Len()) {
+				if want := throughputPattern(received); b != want {
+					mismatch = fmt.Errorf("Loopback data mismatch at byte %v - expected 0x%02x, got 0x%02x", received, want, b)
+					return true
+				}
+				received++
+			}
+			last = time.Now()
+			return true
+		})
+		if mismatch != nil {
+			return rate(), mismatch
+		}
+		if total < 0 {
+			select {
+			case p := <-written:
+				if p.err != nil {
+					return rate(), p.err
+				}
+				total = p.sent
+			default:
+			}
+		}
+		if err != nil {
+			if total < 0 && received == 0 {
+				return 0, fmt.Errorf("No data looped back")
+			}
+			if total >= 0 {
+				return rate(), fmt.Errorf("Loopback data lost - received %v of %v bytes", received, total)
+			}
+			return rate(), err
+		}
+	}
+	return rate(), nil
+}
+
+// throughputPattern returns the byte at offset i of the pattern of MeasureThroughput,
+// whose period of 251 bytes (a prime) doesn't align with the chunks.
+func throughputPattern(i int) byte {
+	return byte(i % 251)
+}
@@ -0,0 +1,48 @@
+package serial
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMeasureThroughput(t *testing.T) {
+	sp, f := openFake(t)
+	defer sp.Close()
+	f.onWrite = func(b []byte) { f.dev.Write(b) }
+	rate, err := sp.MeasureThroughput(20 * time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rate <= 0 {
+		t.Fatalf("Expected a positive rate, got %v", rate)
+	}
+}
+
+func TestMeasureThroughputMismatch(t *testing.T) {
+	sp, f := openFake(t)
+	defer sp.Close()
+	echoed := 0
+	f.onWrite = func(b []byte) {
+		c := append([]byte(nil), b...)
+		for i := range c {
+			if echoed+i == 300 {
+				c[i] ^= 0xff
+			}
+		}
+		echoed += len(c)
+		f.dev.Write(c)
+	}
+	_, err := sp.MeasureThroughput(20 * time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "at byte 300") {
+		t.Fatalf("Expected a mismatch at byte 300, got %v", err)
+	}
+}
+
+func TestMeasureThroughputNoLoopback(t *testing.T) {
+	sp, _ := openFake(t)
+	defer sp.Close()
+	if _, err := sp.MeasureThroughput(10 * time.Millisecond); err == nil {
+		t.Fatal("Expected an error without loopback")
+	}
+}
@@ -0,0 +1,99 @@
+package serial
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// usbIDs returns the USB vendor and product IDs of the device behind the tty name, read
+// from sysfs (rooted at sysRoot, "/sys" on a live system).
+func usbIDs(sysRoot string, name string) (vid uint16, pid uint16, err error) {
+	dir, err := usbDevice(sysRoot, name)
+	if err != nil {
+		return 0, 0, err
+	}
+	vid, _ = readHexAttr(filepath.Join(dir, "idVendor"))
+	pid, _ = readHexAttr(filepath.Join(dir, "idProduct"))
+	return vid, pid, nil
+}
+
+// usbDevice returns the sysfs directory of the USB device behind the tty name.
+func usbDevice(sysRoot string, name string) (string, error) {
+	if target, err := filepath.EvalSymlinks(name); err == nil {
+		// e.g. /dev/serial/by-id/... links
+		name = target
+	}
+	dev, err := filepath.EvalSymlinks(filepath.Join(sysRoot, "class", "tty", filepath.Base(name), "device"))
+	if err != nil {
+		return "", err
+	}
+	// The IDs are attributes of the USB device, a parent of the tty device
+	for dir := dev; strings.HasPrefix(dir, sysRoot) && dir != sysRoot; dir = filepath.Dir(dir) {
+		_, verr := readHexAttr(filepath.Join(dir, "idVendor"))
+		_, perr := readHexAttr(filepath.Join(dir, "idProduct"))
+		if verr == nil && perr == nil {
+			return dir, nil
+		}
+	}
+	return "", fmt.Errorf("\"%s\" is not a USB device", name)
+}
+
+// listPorts returns the serial ports of /dev, see ListPorts.
+func listPorts() ([]PortInfo, error) {
+	return listPortsIn("/dev", "/sys")
+}
+
+// listPortsIn lists the ttyUSB, ttyACM and ttyS devices of devRoot, described with the
+// sysfs rooted at sysRoot. The ttyS devices the kernel creates for UARTs that aren't there
+// (of type 0, PORT_UNKNOWN) are left out.
+func listPortsIn(devRoot string, sysRoot string) ([]PortInfo, error) {
+	names, err := globPorts(devRoot, "ttyUSB*", "ttyACM*", "ttyS*")
+	if err != nil {
+		return nil, err
+	}
+	ports := []PortInfo{}
+	for _, name := range names {
+		class := filepath.Join(sysRoot, "class", "tty", filepath.Base(name))
+		if strings.HasPrefix(filepath.Base(name), "ttyS") {
+			if t, err := os.ReadFile(filepath.Join(class, "type")); err != nil || strings.TrimSpace(string(t)) == "0" {
+				continue
+			}
+		}
+		info := PortInfo{Name: name}
+		if dir, err := usbDevice(sysRoot, name); err == nil {
+			info.VID, _ = readHexAttr(filepath.Join(dir, "idVendor"))
+			info.PID, _ = readHexAttr(filepath.Join(dir, "idProduct"))
+			if product, err := os.ReadFile(filepath.Join(dir, "product")); err == nil {
+				info.Product = strings.TrimSpace(string(product))
+			}
+		}
+		ports = append(ports, info)
+	}
+	return ports, nil
+}
+
+// portAppeared returns when the device node name was created, its change time: udev
+// sets its owner and mode once the device is plugged, whereas the kernel updates the
+// modification time of the ttys on their writes.
+func portAppeared(name string) (time.Time, error) {
+	var st syscall.Stat_t
+	if err := syscall.Stat(name, &st); err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(st.Ctim.Unix()), nil
+}
+
+// readHexAttr reads a sysfs attribute holding a 16-bit hexadecimal value.
+func readHexAttr(path string) (uint16, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.ParseUint(strings.TrimSpace(string(data)), 16, 16)
+	return uint16(v), err
+}
@@ -0,0 +1,101 @@
+package serial
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUSBIDs(t *testing.T) {
+	root := t.TempDir()
+	usb := filepath.Join(root, "devices", "pci0000:00", "usb1", "1-1")
+	iface := filepath.Join(usb, "1-1:1.0", "ttyUSB0")
+	if err := os.MkdirAll(iface, 0755); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(usb, "idVendor"), []byte("10c4\n"), 0644)
+	os.WriteFile(filepath.Join(usb, "idProduct"), []byte("ea60\n"), 0644)
+	class := filepath.Join(root, "class", "tty", "ttyUSB0")
+	os.MkdirAll(class, 0755)
+	if err := os.Symlink(iface, filepath.Join(class, "device")); err != nil {
+		t.Fatal(err)
+	}
+
+	vid, pid, err := usbIDs(root, "/dev/ttyUSB0")
+	if err != nil || vid != 0x10c4 || pid != 0xea60 {
+		t.Fatalf("Unexpected IDs %04x:%04x (%v)", vid, pid, err)
+	}
+	if chip := chipName(vid); chip != "CP210x" {
+		t.Fatalf("Expected CP210x, got %v", chip)
+	}
+	if _, _, err := usbIDs(root, "/dev/ttyS0"); err == nil {
+		t.Fatal("Expected an error for a device missing from sysfs")
+	}
+}
+
+func TestListPorts(t *testing.T) {
+	root := t.TempDir()
+	dev := filepath.Join(root, "dev")
+	sys := filepath.Join(root, "sys")
+	os.MkdirAll(dev, 0755)
+	for _, name := range []string{"ttyACM0", "ttyS0", "ttyS1", "tty0", "null"} {
+		os.WriteFile(filepath.Join(dev, name), nil, 0644)
+	}
+	usb := filepath.Join(sys, "devices", "usb1", "1-2")
+	iface := filepath.Join(usb, "1-2:1.0", "tty", "ttyACM0")
+	os.MkdirAll(iface, 0755)
+	os.WriteFile(filepath.Join(usb, "idVendor"), []byte("2341\n"), 0644)
+	os.WriteFile(filepath.Join(usb, "idProduct"), []byte("0043\n"), 0644)
+	os.WriteFile(filepath.Join(usb, "product"), []byte("Arduino Uno\n"), 0644)
+	os.MkdirAll(filepath.Join(sys, "class", "tty", "ttyACM0"), 0755)
+	os.Symlink(iface, filepath.Join(sys, "class", "tty", "ttyACM0", "device"))
+	// ttyS0 is a present UART, ttyS1 a placeholder of type 0
+	for name, typ := range map[string]string{"ttyS0": "4\n", "ttyS1": "0\n"} {
+		os.MkdirAll(filepath.Join(sys, "class", "tty", name), 0755)
+		os.WriteFile(filepath.Join(sys, "class", "tty", name, "type"), []byte(typ), 0644)
+	}
+
+	ports, err := listPortsIn(dev, sys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []PortInfo{
+		{Name: filepath.Join(dev, "ttyACM0"), VID: 0x2341, PID: 0x0043, Product: "Arduino Uno"},
+		{Name: filepath.Join(dev, "ttyS0")},
+	}
+	if len(ports) != len(want) {
+		t.Fatalf("Expected %+v, got %+v", want, ports)
+	}
+	for i := range want {
+		if ports[i] != want[i] {
+			t.Errorf("Expected %+v, got %+v", want[i], ports[i])
+		}
+	}
+}
+
+func TestMostRecentPort(t *testing.T) {
+	dev := t.TempDir()
+	var ports []PortInfo
+	for _, name := range []string{"ttyUSB1", "ttyACM0", "ttyS0"} {
+		path := filepath.Join(dev, name)
+		os.WriteFile(path, nil, 0644)
+		ports = append(ports, PortInfo{Name: path})
+	}
+	// ttyACM0 is plugged again last
+	time.Sleep(10 * time.Millisecond)
+	os.Chmod(ports[1].Name, 0660)
+	if name, err := mostRecentPort(ports, portAppeared); err != nil || name != ports[1].Name {
+		t.Fatalf("Expected %v, got %v (%v)", ports[1].Name, name, err)
+	}
+	if _, err := mostRecentPort(nil, portAppeared); err == nil {
+		t.Fatal("Expected an error without ports")
+	}
+	undated := func(name string) (time.Time, error) { return time.Time{}, nil }
+	if name, err := mostRecentPort(ports[:1], undated); err != nil || name != ports[0].Name {
+		t.Fatalf("Expected the single port, got %v (%v)", name, err)
+	}
+	if _, err := mostRecentPort(ports, undated); err == nil {
+		t.Fatal("Expected an error with several undated ports")
+	}
+}
@@ -0,0 +1,11 @@
+// +build !linux
+
+package serial
+
+import "fmt"
+
+// usbIDs returns the USB vendor and product IDs of the device behind the tty name. The
+// lookup is only implemented with the sysfs of Linux.
+func usbIDs(sysRoot string, name string) (vid uint16, pid uint16, err error) {
+	return 0, 0, fmt.Errorf("USB device lookup not supported")
+}
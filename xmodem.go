@@ -0,0 +1,329 @@
+package serial
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// XMODEM control characters
+const (
+	xmodemSOH = 0x01 // 128-byte block
+	xmodemSTX = 0x02 // 1024-byte block
+	xmodemEOT = 0x04
+	xmodemACK = 0x06
+	xmodemNAK = 0x15
+	xmodemCAN = 0x18
+	xmodemSUB = 0x1A // padding of the last block
+	xmodemCRC = 'C'  // receiver request for CRC-16 mode
+)
+
+var (
+	// Time waited for each answer of the receiver
+	xmodemTimeout = 10 * time.Second
+	// Attempts for the start of the transfer, each block and the final EOT
+	xmodemRetries = 10
+	// Start requests of the receiver in CRC-16 mode before falling back to checksum mode
+	xmodemCRCRequests = 3
+)
+
+// errXModemFallback is returned by sendXModemBlock when a 1024-byte block is refused.
+var errXModemFallback = errors.New("1K block refused")
+
+// SendXModem sends the data read from r with XMODEM, in 128-byte blocks, the last one
+// padded with SUB (0x1A). The receiver selects the CRC-16 mode ('C') or the checksum of the
+// original XMODEM (NAK). Every block is sent again until it is acknowledged, up to 10
+// times waiting 10 seconds for each answer, and the transfer ends with EOT.
+func (sp *SerialPort) SendXModem(r io.Reader) error {
+	return sp.sendXModem(r, 128)
+}
+
+// SendXModem1K sends the data read from r with XMODEM-1K. The block size is selected from
+// the answer of the receiver: 1024-byte blocks with CRC-16 when it requests CRC mode ('C'),
+// 128-byte blocks with checksum when it answers NAK. If the receiver keeps refusing the
+// 1024-byte blocks, the transfer falls back to 128-byte blocks.
+func (sp *SerialPort) SendXModem1K(r io.Reader) error {
+	return sp.sendXModem(r, 1024)
+}
+
+// sendXModem sends the data read from r using blocks of up to maxBlock bytes.
+func (sp *SerialPort) sendXModem(r io.Reader, maxBlock int) error {
+	if !sp.portIsOpen.Load() {
+		return errNotOpen
+	}
+	crc, err := sp.waitXModemStart()
+	if err != nil {
+		return err
+	}
+	if !crc {
+		// 1K blocks require CRC-16
+		maxBlock = 128
+	}
+	data := make([]byte, 0, 1024)
+	eof := false
+	num := byte(1)
+	for {
+		if !eof && len(data) < 1024 {
+			buf := make([]byte, 1024-len(data))
+			n, err := io.ReadFull(r, buf)
+			data = append(data, buf[:n]...)
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				eof = true
+			} else if err != nil {
+				sp.cancelXModem()
+				return err
+			}
+		}
+		if len(data) == 0 {
+			break
+		}
+		size := 128
+		if maxBlock == 1024 && len(data) > 128 {
+			size = 1024
+		}
+		n := size
+		if n > len(data) {
+			n = len(data)
+		}
+		err := sp.sendXModemBlock(num, data[:n], size, crc)
+		if err == errXModemFallback {
+			maxBlock = 128
+			continue
+		} else if err != nil {
+			return err
+		}
+		data = data[n:]
+		num++
+	}
+	// End of transfer
+	for retry := 0; retry < xmodemRetries; retry++ {
+		if err := sp.writeRaw([]byte{xmodemEOT}); err != nil {
+			return err
+		}
+		if b, err := sp.readByteTimeout(xmodemTimeout); err == nil && b == xmodemACK {
+			return nil
+		}
+	}
+	return fmt.Errorf("XMODEM end of transfer not acknowledged")
+}
+
+// waitXModemStart waits for the receiver to start the transfer, reporting whether it
+// requested CRC-16 mode.
+func (sp *SerialPort) waitXModemStart() (crc bool, err error) {
+	for retry := 0; retry < xmodemRetries; retry++ {
+		b, err := sp.readByteTimeout(xmodemTimeout)
+		if err == errNotOpen || err == ErrPortDisconnected {
+			return false, err
+		}
+		switch {
+		case err != nil:
+		case b == xmodemCRC:
+			return true, nil
+		case b == xmodemNAK:
+			return false, nil
+		case b == xmodemCAN:
+			return false, fmt.Errorf("XMODEM transfer cancelled by receiver")
+		}
+	}
+	return false, fmt.Errorf("XMODEM receiver not ready")
+}
+
+// sendXModemBlock sends the block num holding payload, padded to size, until the receiver
+// acknowledges it.
+func (sp *SerialPort) sendXModemBlock(num byte, payload []byte, size int, crc bool) error {
+	block := make([]byte, 0, size+5)
+	if size == 1024 {
+		block = append(block, xmodemSTX)
+	} else {
+		block = append(block, xmodemSOH)
+	}
+	block = append(block, num, ^num)
+	block = append(block, payload...)
+	for len(block) < size+3 {
+		block = append(block, xmodemSUB)
+	}
+	if crc {
+		sum := crc16XModem(block[3:])
+		block = append(block, byte(sum>>8), byte(sum))
+	} else {
+		block = append(block, xmodemChecksum(block[3:]))
+	}
+	naks := 0
+	for retry := 0; retry < xmodemRetries; retry++ {
+		if err := sp.writeRaw(block); err != nil {
+			return err
+		}
+		b, err := sp.readByteTimeout(xmodemTimeout)
+		if err == errNotOpen || err == ErrPortDisconnected {
+			return err
+		}
+		switch {
+		case err != nil:
+		case b == xmodemACK:
+			return nil
+		case b == xmodemNAK:
+			if naks++; size == 1024 && naks >= 2 {
+				return errXModemFallback
+			}
+		case b == xmodemCAN:
+			return fmt.Errorf("XMODEM transfer cancelled by receiver")
+		}
+	}
+	sp.cancelXModem()
+	return fmt.Errorf("XMODEM block %v not acknowledged", num)
+}
+
+// ReceiveXModem receives an XMODEM transfer into w, requesting the CRC-16 mode first then
+// falling back to the checksum of the original XMODEM if the sender doesn't answer. The
+// 128- and 1024-byte blocks (XMODEM-1K) are both accepted. XMODEM doesn't tell the size
+// of the data: the padding of the last block (usually SUB, 0x1A) is written to w too.
+//
+// Bad blocks are refused with NAK for the sender to send them again, up to 10 times, and
+// the blocks repeated because their ACK got lost are skipped. The transfer ends with the
+// EOT of the sender.
+func (sp *SerialPort) ReceiveXModem(w io.Writer) error {
+	if !sp.portIsOpen.Load() {
+		return errNotOpen
+	}
+	crc := true
+	answered := false
+	expected := byte(1)
+	for failures := 0; failures < xmodemRetries; {
+		if !answered {
+			start := byte(xmodemCRC)
+			if failures >= xmodemCRCRequests {
+				crc, start = false, xmodemNAK
+			}
+			if err := sp.writeRaw([]byte{start}); err != nil {
+				return err
+			}
+		}
+		header, num, payload, err := sp.readXModemBlock(crc)
+		if err == errNotOpen || err == ErrPortDisconnected {
+			return err
+		}
+		answered = answered || header != 0
+		switch {
+		case header == xmodemCAN:
+			return fmt.Errorf("XMODEM transfer cancelled by sender")
+		case header == xmodemEOT:
+			return sp.writeRaw([]byte{xmodemACK})
+		case err != nil:
+			failures++
+			if answered {
+				sp.purgeXModem()
+				if err := sp.writeRaw([]byte{xmodemNAK}); err != nil {
+					return err
+				}
+			}
+			continue
+		case num == expected-1:
+			// Repeated block, its ACK got lost
+			sp.writeRaw([]byte{xmodemACK})
+			continue
+		case num != expected:
+			sp.cancelXModem()
+			return fmt.Errorf("XMODEM block %v out of sequence, expected %v", num, expected)
+		}
+		if _, err := w.Write(payload); err != nil {
+			sp.cancelXModem()
+			return err
+		}
+		expected++
+		failures = 0
+		if err := sp.writeRaw([]byte{xmodemACK}); err != nil {
+			return err
+		}
+	}
+	sp.cancelXModem()
+	if !answered {
+		return fmt.Errorf("XMODEM sender not ready")
+	}
+	return fmt.Errorf("XMODEM block %v not received", expected)
+}
+
+// readXModemBlock reads the next block: its header, and for the data blocks its number
+// and payload once checked with the CRC-16 or the checksum. EOT and CAN are returned as
+// headers alone.
+func (sp *SerialPort) readXModemBlock(crc bool) (header byte, num byte, payload []byte, err error) {
+	header, err = sp.readByteTimeout(xmodemTimeout)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	size := 128
+	switch header {
+	case xmodemSOH:
+	case xmodemSTX:
+		size = 1024
+	case xmodemEOT, xmodemCAN:
+		return header, 0, nil, nil
+	default:
+		return header, 0, nil, fmt.Errorf("Unexpected XMODEM header 0x%02x", header)
+	}
+	trailer := 1
+	if crc {
+		trailer = 2
+	}
+	var block []byte
+	err = sp.waitBuffer(xmodemTimeout, func(buff *bytes.Buffer) bool {
+		if buff.Len() < size+2+trailer {
+			return false
+		}
+		block = append([]byte(nil), buff.Next(size+2+trailer)...)
+		return true
+	})
+	if err != nil {
+		return header, 0, nil, err
+	}
+	num, payload = block[0], block[2:2+size]
+	ok := block[1] == ^num
+	if crc {
+		sum := crc16XModem(payload)
+		ok = ok && block[2+size] == byte(sum>>8) && block[3+size] == byte(sum)
+	} else {
+		ok = ok && block[2+size] == xmodemChecksum(payload)
+	}
+	if !ok {
+		return header, num, nil, fmt.Errorf("Bad XMODEM block %v", num)
+	}
+	return header, num, payload, nil
+}
+
+// purgeXModem discards the data buffered, the rest of a bad block.
+func (sp *SerialPort) purgeXModem() {
+	sp.buffMu.Lock()
+	sp.buff.Reset()
+	sp.buffMu.Unlock()
+}
+
+// cancelXModem aborts the transfer on the receiver side.
+func (sp *SerialPort) cancelXModem() {
+	sp.writeRaw([]byte{xmodemCAN, xmodemCAN})
+}
+
+// crc16XModem computes the CRC-16 used by XMODEM (polynomial 0x1021, initial value 0).
+func crc16XModem(data []byte) uint16 {
+	var crc uint16
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// xmodemChecksum computes the arithmetic checksum of the original XMODEM.
+func xmodemChecksum(data []byte) byte {
+	var sum byte
+	for _, b := range data {
+		sum += b
+	}
+	return sum
+}
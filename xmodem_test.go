@@ -0,0 +1,219 @@
+package serial
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// xmodemReceiver simulates the receiving side of an XMODEM transfer on f. It answers
+// each block with reply, ACKs the EOT and returns the received blocks on done.
+type xmodemReceiver struct {
+	t      *testing.T
+	f      *fakePort
+	crc    bool
+	reply  func(header byte) byte
+	data   bytes.Buffer
+	blocks []byte // header of each accepted block
+	done   chan struct{}
+}
+
+func newXModemReceiver(t *testing.T, f *fakePort, crc bool, reply func(header byte) byte) *xmodemReceiver {
+	rx := &xmodemReceiver{t: t, f: f, crc: crc, reply: reply, done: make(chan struct{})}
+	f.onWrite = rx.handle
+	return rx
+}
+
+func (rx *xmodemReceiver) handle(b []byte) {
+	switch b[0] {
+	case xmodemEOT:
+		rx.f.dev.Write([]byte{xmodemACK})
+		close(rx.done)
+		return
+	case xmodemCAN:
+		return
+	}
+	size := 128
+	if b[0] == xmodemSTX {
+		size = 1024
+	}
+	trailer := 1
+	if rx.crc {
+		trailer = 2
+	}
+	if len(b) != size+3+trailer || b[2] != ^b[1] {
+		rx.t.Errorf("Malformed block % x", b[:3])
+		rx.f.dev.Write([]byte{xmodemNAK})
+		return
+	}
+	payload := b[3 : 3+size]
+	if rx.crc {
+		sum := crc16XModem(payload)
+		if b[3+size] != byte(sum>>8) || b[4+size] != byte(sum) {
+			rx.t.Errorf("Bad CRC for block %v", b[1])
+		}
+	} else if b[3+size] != xmodemChecksum(payload) {
+		rx.t.Errorf("Bad checksum for block %v", b[1])
+	}
+	r := rx.reply(b[0])
+	if r == xmodemACK {
+		rx.blocks = append(rx.blocks, b[0])
+		rx.data.Write(payload)
+	}
+	rx.f.dev.Write([]byte{r})
+}
+
+func (rx *xmodemReceiver) wait() {
+	select {
+	case <-rx.done:
+	case <-time.After(time.Second):
+		rx.t.Fatal("Transfer not completed")
+	}
+}
+
+func ack(byte) byte { return xmodemACK }
+
+func TestCRC16XModem(t *testing.T) {
+	if crc := crc16XModem([]byte("123456789")); crc != 0x31C3 {
+		t.Fatalf("Expected 0x31c3, got %#x", crc)
+	}
+}
+
+func TestSendXModem1K(t *testing.T) {
+	payload := bytes.Repeat([]byte("0123456789abcdef"), 130) // 2080 bytes
+	expected := append(append([]byte(nil), payload...), bytes.Repeat([]byte{xmodemSUB}, 96)...)
+
+	tests := []struct {
+		name   string
+		start  byte
+		reply  func(header byte) byte
+		blocks []byte
+	}{
+		// 2 1K blocks, the remaining 32 bytes in a 128-byte block
+		{"CRC", xmodemCRC, ack, []byte{xmodemSTX, xmodemSTX, xmodemSOH}},
+		// Checksum mode only supports 128-byte blocks
+		{"Checksum", xmodemNAK, ack, bytes.Repeat([]byte{xmodemSOH}, 17)},
+		// Receiver refusing 1K blocks
+		{"Fallback", xmodemCRC, func(header byte) byte {
+			if header == xmodemSTX {
+				return xmodemNAK
+			}
+			return xmodemACK
+		}, bytes.Repeat([]byte{xmodemSOH}, 17)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sp, f := openFake(t)
+			rx := newXModemReceiver(t, f, test.start == xmodemCRC, test.reply)
+			go f.dev.Write([]byte{test.start})
+
+			if err := sp.SendXModem1K(bytes.NewReader(payload)); err != nil {
+				t.Fatal(err)
+			}
+			rx.wait()
+			if !bytes.Equal(rx.blocks, test.blocks) {
+				t.Fatalf("Expected blocks % x, got % x", test.blocks, rx.blocks)
+			}
+			if !bytes.Equal(rx.data.Bytes(), expected) {
+				t.Fatalf("Received data differs, %v bytes", rx.data.Len())
+			}
+		})
+	}
+}
+
+func TestSendXModemCancelled(t *testing.T) {
+	sp, f := openFake(t)
+	newXModemReceiver(t, f, true, func(byte) byte { return xmodemCAN })
+	go f.dev.Write([]byte{xmodemCRC})
+
+	if err := sp.SendXModem1K(bytes.NewReader([]byte("data"))); err == nil {
+		t.Fatal("Expected an error for a cancelled transfer")
+	}
+}
+
+func TestSendXModem(t *testing.T) {
+	payload := bytes.Repeat([]byte("0123456789abcdef"), 20) // 320 bytes
+	expected := append(append([]byte(nil), payload...), bytes.Repeat([]byte{xmodemSUB}, 64)...)
+	for _, start := range []byte{xmodemCRC, xmodemNAK} {
+		sp, f := openFake(t)
+		rx := newXModemReceiver(t, f, start == xmodemCRC, ack)
+		go f.dev.Write([]byte{start})
+
+		if err := sp.SendXModem(bytes.NewReader(payload)); err != nil {
+			t.Fatal(err)
+		}
+		rx.wait()
+		if want := bytes.Repeat([]byte{xmodemSOH}, 3); !bytes.Equal(rx.blocks, want) {
+			t.Fatalf("Expected blocks % x, got % x", want, rx.blocks)
+		}
+		if !bytes.Equal(rx.data.Bytes(), expected) {
+			t.Fatalf("Received data differs, %v bytes", rx.data.Len())
+		}
+		sp.Close()
+	}
+}
+
+// xmodemChecksumBlock builds a checksum block num holding payload, padded to 128 bytes.
+func xmodemChecksumBlock(num byte, payload []byte) []byte {
+	block := append([]byte{xmodemSOH, num, ^num}, payload...)
+	for len(block) < 128+3 {
+		block = append(block, xmodemSUB)
+	}
+	return append(block, xmodemChecksum(block[3:]))
+}
+
+func TestReceiveXModem(t *testing.T) {
+	// Both ends of the transfer, each writing to the other
+	tx, txPort := openFake(t)
+	defer tx.Close()
+	rx, rxPort := openFake(t)
+	defer rx.Close()
+	txPort.onWrite = func(b []byte) { rxPort.dev.Write(b) }
+	rxPort.onWrite = func(b []byte) { txPort.dev.Write(b) }
+
+	payload := bytes.Repeat([]byte("0123456789abcdef"), 130) // 2080 bytes
+	sent := make(chan error, 1)
+	go func() { sent <- tx.SendXModem1K(bytes.NewReader(payload)) }()
+	var received bytes.Buffer
+	if err := rx.ReceiveXModem(&received); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-sent; err != nil {
+		t.Fatal(err)
+	}
+	if got := bytes.TrimRight(received.Bytes(), "\x1a"); !bytes.Equal(got, payload) {
+		t.Fatalf("Received data differs, %v bytes", len(got))
+	}
+}
+
+func TestReceiveXModemChecksum(t *testing.T) {
+	defer func(timeout time.Duration) { xmodemTimeout = timeout }(xmodemTimeout)
+	xmodemTimeout = 50 * time.Millisecond
+	sp, f := openFake(t)
+	defer sp.Close()
+	bad := xmodemChecksumBlock(1, []byte("first"))
+	bad[10] ^= 0xFF
+	// A sender ignoring the CRC-16 requests, resending a block whose ACK got lost
+	tx := newYModemSender(f, []ymodemStep{
+		{nil, xmodemCRC},
+		{nil, xmodemCRC},
+		{nil, xmodemCRC},
+		{bad, xmodemNAK},
+		{xmodemChecksumBlock(1, []byte("first")), xmodemNAK},
+		{xmodemChecksumBlock(1, []byte("first")), xmodemACK},
+		{xmodemChecksumBlock(2, []byte("second")), xmodemACK},
+		{[]byte{xmodemEOT}, xmodemACK},
+		{nil, xmodemACK},
+	})
+	var received bytes.Buffer
+	if err := sp.ReceiveXModem(&received); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-tx.done; err != nil {
+		t.Fatal(err)
+	}
+	want := append(xmodemChecksumBlock(1, []byte("first"))[3:131], xmodemChecksumBlock(2, []byte("second"))[3:131]...)
+	if !bytes.Equal(received.Bytes(), want) {
+		t.Fatalf("Expected %q, got %q", want, received.Bytes())
+	}
+}
@@ -0,0 +1,156 @@
+package serial
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ReceiveYMODEM receives a YMODEM batch transfer, in CRC-16 mode, into files of destDir
+// named after the block 0 header of each file, and returns their paths. The 128- and
+// 1024-byte blocks are both accepted, the size of the header is used to drop the padding
+// of the last block, and the transfer ends with the empty header of the sender.
+//
+// The optional progress callback is called after every data block with the name of the
+// file, the bytes received so far and the size of the header, -1 when unknown.
+func (sp *SerialPort) ReceiveYMODEM(destDir string, progress ...func(name string, received, size int64)) ([]string, error) {
+	if !sp.portIsOpen.Load() {
+		return nil, errNotOpen
+	}
+	var report func(name string, received, size int64)
+	if len(progress) > 0 {
+		report = progress[0]
+	}
+	var paths []string
+	for {
+		name, size, err := sp.receiveYModemHeader()
+		if err != nil {
+			return paths, err
+		}
+		if name == "" {
+			// Empty header, end of the batch
+			return paths, nil
+		}
+		path := filepath.Join(destDir, filepath.Base(name))
+		if err := sp.receiveYModemFile(path, size, report); err != nil {
+			return paths, err
+		}
+		paths = append(paths, path)
+	}
+}
+
+// receiveYModemHeader requests and acknowledges the block 0 of the next file, returning
+// its name and size (-1 if the header doesn't tell). The name is empty at the end of the
+// batch.
+func (sp *SerialPort) receiveYModemHeader() (name string, size int64, err error) {
+	for retry := 0; retry < xmodemRetries; retry++ {
+		if err := sp.writeRaw([]byte{xmodemCRC}); err != nil {
+			return "", 0, err
+		}
+		header, num, payload, err := sp.readXModemBlock(true)
+		if err == errNotOpen || err == ErrPortDisconnected {
+			return "", 0, err
+		}
+		switch {
+		case header == xmodemCAN:
+			return "", 0, fmt.Errorf("YMODEM transfer cancelled by sender")
+		case header == xmodemEOT:
+			// Repeated end of the previous file, its ACK got lost
+			sp.writeRaw([]byte{xmodemACK})
+			continue
+		case err != nil || num != 0:
+			sp.purgeXModem()
+			continue
+		}
+		fields := bytes.SplitN(payload, []byte{0}, 2)
+		name, size = string(fields[0]), -1
+		if len(fields) > 1 {
+			info := strings.Fields(string(bytes.TrimRight(fields[1], "\x00")))
+			if len(info) > 0 {
+				if n, err := strconv.ParseInt(info[0], 10, 64); err == nil {
+					size = n
+				}
+			}
+		}
+		if err := sp.writeRaw([]byte{xmodemACK}); err != nil {
+			return "", 0, err
+		}
+		return name, size, nil
+	}
+	sp.cancelXModem()
+	return "", 0, fmt.Errorf("YMODEM header not received")
+}
+
+// receiveYModemFile receives the data blocks of a file into path, up to size bytes if
+// it isn't -1, until the end of transmission of the sender.
+func (sp *SerialPort) receiveYModemFile(path string, size int64, report func(name string, received, size int64)) error {
+	f, err := os.Create(path)
+	if err != nil {
+		sp.cancelXModem()
+		return err
+	}
+	defer f.Close()
+
+	// Start the data blocks
+	if err := sp.writeRaw([]byte{xmodemCRC}); err != nil {
+		return err
+	}
+	expected := byte(1)
+	received := int64(0)
+	eots := 0
+	for failures := 0; failures < xmodemRetries; {
+		header, num, payload, err := sp.readXModemBlock(true)
+		if err == errNotOpen || err == ErrPortDisconnected {
+			return err
+		}
+		switch {
+		case header == xmodemCAN:
+			return fmt.Errorf("YMODEM transfer cancelled by sender")
+		case header == xmodemEOT:
+			// The first EOT is refused, the sender confirms it with another one
+			if eots++; eots == 1 {
+				sp.writeRaw([]byte{xmodemNAK})
+				continue
+			}
+			if err := sp.writeRaw([]byte{xmodemACK}); err != nil {
+				return err
+			}
+			return f.Close()
+		case err != nil:
+			failures++
+			sp.purgeXModem()
+			if err := sp.writeRaw([]byte{xmodemNAK}); err != nil {
+				return err
+			}
+			continue
+		case num == expected-1:
+			// Repeated block, its ACK got lost
+			sp.writeRaw([]byte{xmodemACK})
+			continue
+		case num != expected:
+			sp.cancelXModem()
+			return fmt.Errorf("YMODEM block %v out of sequence, expected %v", num, expected)
+		}
+		if size >= 0 && received+int64(len(payload)) > size {
+			payload = payload[:size-received]
+		}
+		if _, err := f.Write(payload); err != nil {
+			sp.cancelXModem()
+			return err
+		}
+		received += int64(len(payload))
+		expected++
+		failures, eots = 0, 0
+		if err := sp.writeRaw([]byte{xmodemACK}); err != nil {
+			return err
+		}
+		if report != nil {
+			report(filepath.Base(path), received, size)
+		}
+	}
+	sp.cancelXModem()
+	return fmt.Errorf("YMODEM block %v not received", expected)
+}
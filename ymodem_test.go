@@ -0,0 +1,133 @@
+package serial
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// ymodemBlock builds a CRC-16 block num holding payload, padded to size.
+func ymodemBlock(num byte, payload []byte, size int, pad byte) []byte {
+	header := byte(xmodemSOH)
+	if size == 1024 {
+		header = xmodemSTX
+	}
+	block := append([]byte{header, num, ^num}, payload...)
+	for len(block) < size+3 {
+		block = append(block, pad)
+	}
+	sum := crc16XModem(block[3:])
+	return append(block, byte(sum>>8), byte(sum))
+}
+
+// ymodemSender simulates the sending side of a YMODEM batch on f: it sends each frame
+// once the receiver answers the previous one with the expected byte.
+type ymodemSender struct {
+	answers chan byte
+	done    chan error
+}
+
+type ymodemStep struct {
+	frame  []byte
+	expect byte // answer of the receiver to wait for before sending frame
+}
+
+func newYModemSender(f *fakePort, steps []ymodemStep) *ymodemSender {
+	tx := &ymodemSender{answers: make(chan byte, 64), done: make(chan error, 1)}
+	f.onWrite = func(b []byte) {
+		for _, c := range b {
+			tx.answers <- c
+		}
+	}
+	go func() {
+		for _, step := range steps {
+			select {
+			case c := <-tx.answers:
+				if c != step.expect {
+					tx.done <- fmt.Errorf("Expected 0x%02x, got 0x%02x", step.expect, c)
+					return
+				}
+			case <-time.After(time.Second):
+				tx.done <- fmt.Errorf("No answer 0x%02x", step.expect)
+				return
+			}
+			if step.frame != nil {
+				f.dev.Write(step.frame)
+			}
+		}
+		tx.done <- nil
+	}()
+	return tx
+}
+
+func TestReceiveYMODEM(t *testing.T) {
+	sp, f := openFake(t)
+	defer sp.Close()
+	dir := t.TempDir()
+
+	first := bytes.Repeat([]byte("0123456789abcdef"), 70) // 1120 bytes: a 1K and a 128-byte block
+	second := []byte("hello")
+	corrupted := ymodemBlock(1, second, 128, xmodemSUB)
+	corrupted[10] ^= 0xff
+	steps := []ymodemStep{
+		{ymodemBlock(0, []byte("first.bin\x001120 0 0"), 128, 0), xmodemCRC},
+		{nil, xmodemACK},
+		{ymodemBlock(1, first[:1024], 1024, xmodemSUB), xmodemCRC},
+		{ymodemBlock(2, first[1024:], 128, xmodemSUB), xmodemACK},
+		{[]byte{xmodemEOT}, xmodemACK},
+		{[]byte{xmodemEOT}, xmodemNAK},
+		{nil, xmodemACK},
+		// The directory of the name is dropped
+		{ymodemBlock(0, []byte("../up/second.txt\x005"), 128, 0), xmodemCRC},
+		{nil, xmodemACK},
+		{corrupted, xmodemCRC},
+		{ymodemBlock(1, second, 128, xmodemSUB), xmodemNAK},
+		{[]byte{xmodemEOT}, xmodemACK},
+		{[]byte{xmodemEOT}, xmodemNAK},
+		{nil, xmodemACK},
+		{ymodemBlock(0, nil, 128, 0), xmodemCRC},
+		{nil, xmodemACK},
+	}
+	tx := newYModemSender(f, steps)
+
+	var progress []int64
+	paths, err := sp.ReceiveYMODEM(dir, func(name string, received, size int64) {
+		progress = append(progress, received)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-tx.done; err != nil {
+		t.Fatal(err)
+	}
+	want := []string{filepath.Join(dir, "first.bin"), filepath.Join(dir, "second.txt")}
+	if len(paths) != 2 || paths[0] != want[0] || paths[1] != want[1] {
+		t.Fatalf("Expected %v, got %v", want, paths)
+	}
+	for i, content := range [][]byte{first, second} {
+		data, err := os.ReadFile(paths[i])
+		if err != nil || !bytes.Equal(data, content) {
+			t.Fatalf("Unexpected content of %v, %v bytes (%v)", paths[i], len(data), err)
+		}
+	}
+	if fmt.Sprint(progress) != "[1024 1120 5]" {
+		t.Fatalf("Unexpected progress %v", progress)
+	}
+}
+
+func TestReceiveYMODEMCancelled(t *testing.T) {
+	sp, f := openFake(t)
+	defer sp.Close()
+	tx := newYModemSender(f, []ymodemStep{
+		{ymodemBlock(0, []byte("file\x0010"), 128, 0), xmodemCRC},
+		{nil, xmodemACK},
+		{[]byte{xmodemCAN, xmodemCAN}, xmodemCRC},
+	})
+	if _, err := sp.ReceiveYMODEM(t.TempDir()); err == nil {
+		t.Fatal("Expected an error for a cancelled transfer")
+	}
+	<-tx.done
+}